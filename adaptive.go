@@ -0,0 +1,247 @@
+package imagewatermark
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+
+	"github.com/disintegration/imaging"
+)
+
+// AdaptiveConfig enables automatic selection of the watermark variant with the best contrast against the background.
+//
+// The candidates are either a set of watermark variants (for example, light and dark versions of a logo),
+// or the main watermark used as a monochrome mask and recolored with each tint color, or both.
+// For every placement position (every tile in a grid), the candidate with the highest contrast ratio
+// against the pixels under it is drawn.
+//
+// Fields:
+//   - Variants: Alternative watermark images. The main watermark is also a candidate unless TintColors is set.
+//     Variants are sized with the same settings and centered on the position of the main watermark.
+//   - TintColors: Colors used to recolor the main watermark (keeping its alpha) into additional candidates.
+//     When set, the main watermark is only used as a mask and is not a candidate itself.
+type AdaptiveConfig struct {
+	Variants   []image.Image
+	TintColors []color.Color
+}
+
+// validate checks if the AdaptiveConfig has at least one candidate source and no nil entries.
+//
+// Returns:
+//   - An error describing the first invalid value found, or nil if the configuration is valid.
+func (c AdaptiveConfig) validate() error {
+	if len(c.Variants) == 0 && len(c.TintColors) == 0 {
+		return errors.New("at least one variant or tint color is required")
+	}
+
+	for i, variant := range c.Variants {
+		if variant == nil {
+			return fmt.Errorf("variant %d is nil", i)
+		}
+	}
+
+	for i, tint := range c.TintColors {
+		if tint == nil {
+			return fmt.Errorf("tint color %d is nil", i)
+		}
+	}
+
+	return nil
+}
+
+// candidates builds the list of watermark candidates from the main watermark and the adaptive settings.
+//
+// Parameters:
+//   - watermarkImg: The main watermark image.
+//
+// Returns:
+//   - A slice of watermark images to choose from, in a stable order.
+func (c AdaptiveConfig) candidates(watermarkImg image.Image) []image.Image {
+	var candidates []image.Image
+
+	if len(c.TintColors) == 0 {
+		candidates = append(candidates, watermarkImg)
+	}
+
+	candidates = append(candidates, c.Variants...)
+
+	for _, tint := range c.TintColors {
+		candidates = append(candidates, recolor(watermarkImg, tint))
+	}
+
+	return candidates
+}
+
+// recolor replaces the color of every watermark pixel with a solid color, keeping the original alpha.
+//
+// Parameters:
+//   - img: The watermark image used as a mask.
+//   - c: The color to paint. Its alpha multiplies the watermark alpha.
+//
+// Returns:
+//   - A pointer to an image.NRGBA containing the recolored watermark.
+func recolor(img image.Image, c color.Color) *image.NRGBA {
+	tint := color.NRGBAModel.Convert(c).(color.NRGBA)
+	result := imaging.Clone(img)
+
+	for i := 0; i < len(result.Pix); i += 4 {
+		result.Pix[i] = tint.R
+		result.Pix[i+1] = tint.G
+		result.Pix[i+2] = tint.B
+		result.Pix[i+3] = uint8(uint16(result.Pix[i+3]) * uint16(tint.A) / 255)
+	}
+
+	return result
+}
+
+// relativeLuminance computes the WCAG relative luminance of an 8-bit sRGB color.
+func relativeLuminance(r, g, b uint8) float64 {
+	luts := linearLUTs()
+	return 0.2126*luts.toLinear(r) + 0.7152*luts.toLinear(g) + 0.0722*luts.toLinear(b)
+}
+
+// contrastRatio computes the WCAG contrast ratio between two relative luminances, from 1 (none) to 21 (black on white).
+func contrastRatio(l1, l2 float64) float64 {
+	if l1 < l2 {
+		l1, l2 = l2, l1
+	}
+
+	return (l1 + 0.05) / (l2 + 0.05)
+}
+
+// watermarkLuminance computes the alpha-weighted mean relative luminance of a watermark.
+//
+// Parameters:
+//   - wm: The watermark image.
+//
+// Returns:
+//   - The mean relative luminance of the visible watermark pixels, or 0 if the watermark is fully transparent.
+func watermarkLuminance(wm *image.NRGBA) float64 {
+	var sum, weight float64
+
+	for i := 0; i < len(wm.Pix); i += 4 {
+		a := float64(wm.Pix[i+3])
+		if a == 0 {
+			continue
+		}
+		sum += relativeLuminance(wm.Pix[i], wm.Pix[i+1], wm.Pix[i+2]) * a
+		weight += a
+	}
+
+	if weight == 0 {
+		return 0
+	}
+
+	return sum / weight
+}
+
+// backgroundLuminance computes the mean relative luminance of the canvas pixels under a watermark,
+// weighted by the watermark alpha and the canvas alpha.
+//
+// Parameters:
+//   - canvas: The RGBA image the watermark will be drawn onto.
+//   - wm: The watermark image, whose alpha is used as the weight of each pixel.
+//   - pos: The top-left corner where the watermark will be drawn.
+//
+// Returns:
+//   - The mean relative luminance of the covered background.
+//   - A bool that is false when the watermark does not cover any visible canvas pixel.
+func backgroundLuminance(canvas *image.RGBA, wm *image.NRGBA, pos image.Point) (float64, bool) {
+	dr := image.Rectangle{Min: pos, Max: pos.Add(wm.Bounds().Size())}.Intersect(canvas.Bounds())
+
+	var sum, weight float64
+
+	for y := dr.Min.Y; y < dr.Max.Y; y++ {
+		srcRow := wm.Pix[(y-pos.Y)*wm.Stride:]
+		dstRow := canvas.Pix[canvas.PixOffset(dr.Min.X, y):]
+
+		for x := dr.Min.X; x < dr.Max.X; x++ {
+			wa := float64(srcRow[(x-pos.X)*4+3])
+			di := (x - dr.Min.X) * 4
+			ca := dstRow[di+3]
+			if wa == 0 || ca == 0 {
+				continue
+			}
+
+			r := uint8(uint16(dstRow[di]) * 255 / uint16(ca))
+			g := uint8(uint16(dstRow[di+1]) * 255 / uint16(ca))
+			b := uint8(uint16(dstRow[di+2]) * 255 / uint16(ca))
+
+			w := wa * float64(ca)
+			sum += relativeLuminance(r, g, b) * w
+			weight += w
+		}
+	}
+
+	if weight == 0 {
+		return 0, false
+	}
+
+	return sum / weight, true
+}
+
+// pickVariant selects the candidate with the highest contrast ratio against the canvas at the given position.
+//
+// The background is measured under the first candidate, which defines the placement footprint.
+//
+// Parameters:
+//   - canvas: The RGBA image the watermark will be drawn onto.
+//   - candidates: The fitted watermark candidates.
+//   - luminances: The mean luminance of each candidate, as returned by watermarkLuminance.
+//   - pos: The top-left corner where the watermark will be drawn.
+//
+// Returns:
+//   - The index of the selected candidate.
+func pickVariant(canvas *image.RGBA, candidates []*image.NRGBA, luminances []float64, pos image.Point) int {
+	background, ok := backgroundLuminance(canvas, candidates[0], pos)
+	if !ok {
+		return 0
+	}
+
+	best, bestContrast := 0, 0.0
+	for i, luminance := range luminances {
+		if contrast := contrastRatio(background, luminance); contrast > bestContrast {
+			best, bestContrast = i, contrast
+		}
+	}
+
+	return best
+}
+
+// chooseVariants selects, for every position, the candidate with the best contrast against the canvas.
+//
+// Parameters:
+//   - canvas: The RGBA image the watermarks will be drawn onto.
+//   - candidates: The fitted watermark candidates. The first one defines the placement footprint.
+//   - positions: A slice of image.Point objects indicating where each watermark will be placed.
+//
+// Returns:
+//   - A slice with the index of the selected candidate for each position.
+func chooseVariants(canvas *image.RGBA, candidates []*image.NRGBA, positions []image.Point) []int {
+	luminances := make([]float64, len(candidates))
+	for i, candidate := range candidates {
+		luminances[i] = watermarkLuminance(candidate)
+	}
+
+	variants := make([]int, len(positions))
+	for i, pos := range positions {
+		variants[i] = pickVariant(canvas, candidates, luminances, pos)
+	}
+
+	return variants
+}
+
+// variantPosition returns where a candidate must be drawn so that it is centered on the footprint
+// of the first candidate placed at pos.
+//
+// Parameters:
+//   - footprint: The bounds of the first candidate.
+//   - variant: The bounds of the candidate to draw.
+//   - pos: The top-left corner of the footprint.
+//
+// Returns:
+//   - An image.Point containing the top-left corner where the candidate must be drawn.
+func variantPosition(footprint, variant image.Rectangle, pos image.Point) image.Point {
+	return pos.Add(footprint.Size().Sub(variant.Size()).Div(2))
+}
@@ -0,0 +1,136 @@
+package imagewatermark
+
+import (
+	"image"
+	"image/color"
+	"slices"
+	"testing"
+)
+
+// splitImage returns an image whose left half is filled with left and right half with right.
+func splitImage(width, height int, left, right color.NRGBA) *image.NRGBA {
+	img := uniformImage(width, height, right)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width/2; x++ {
+			img.SetNRGBA(x, y, left)
+		}
+	}
+	return img
+}
+
+func TestApplyAdaptiveVariants(t *testing.T) {
+	white := color.NRGBA{R: 255, G: 255, B: 255, A: 255}
+	black := color.NRGBA{A: 255}
+	gray := color.NRGBA{R: 128, G: 128, B: 128, A: 255}
+	positions := []image.Point{{10, 10}, {70, 10}, {10, 70}, {70, 70}}
+
+	tests := []struct {
+		name         string
+		watermark    color.NRGBA
+		adaptive     AdaptiveConfig
+		wantVariants []int
+		wantColors   []color.NRGBA
+	}{
+		{
+			name:         "light and dark variants",
+			watermark:    white,
+			adaptive:     AdaptiveConfig{Variants: []image.Image{uniformImage(20, 20, black)}},
+			wantVariants: []int{0, 1, 0, 1},
+			wantColors:   []color.NRGBA{white, black, white, black},
+		},
+		{
+			name:         "variant order does not matter",
+			watermark:    black,
+			adaptive:     AdaptiveConfig{Variants: []image.Image{uniformImage(20, 20, white)}},
+			wantVariants: []int{1, 0, 1, 0},
+			wantColors:   []color.NRGBA{white, black, white, black},
+		},
+		{
+			name:         "tint colors",
+			watermark:    gray,
+			adaptive:     AdaptiveConfig{TintColors: []color.Color{color.Black, color.White}},
+			wantVariants: []int{1, 0, 1, 0},
+			wantColors:   []color.NRGBA{white, black, white, black},
+		},
+		{
+			name:         "variants before tints",
+			watermark:    gray,
+			adaptive:     AdaptiveConfig{Variants: []image.Image{uniformImage(20, 20, gray)}, TintColors: []color.Color{color.White}},
+			wantVariants: []int{1, 0, 1, 0},
+			wantColors:   []color.NRGBA{white, gray, white, gray},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			placer := PlacerFunc(func(image.Image, image.Rectangle) []image.Point { return positions })
+			config := GeneralConfig{OpacityAlpha: 1, WatermarkWidthPercent: 20, Adaptive: &tt.adaptive}
+
+			result, report, err := ApplyWithReport(splitImage(100, 100, black, white), uniformImage(20, 20, tt.watermark), config, placer)
+			if err != nil {
+				t.Fatalf("ApplyWithReport: %v", err)
+			}
+
+			if !slices.Equal(report.Variants, tt.wantVariants) {
+				t.Errorf("report variants = %v, want %v", report.Variants, tt.wantVariants)
+			}
+			for i, pos := range positions {
+				if got := nrgbaAt(result, pos.X+10, pos.Y+10); got != tt.wantColors[i] {
+					t.Errorf("pixel at position %v = %v, want %v", pos, got, tt.wantColors[i])
+				}
+			}
+		})
+	}
+}
+
+func TestApplyAdaptiveGridTiles(t *testing.T) {
+	white := color.NRGBA{R: 255, G: 255, B: 255, A: 255}
+	black := color.NRGBA{A: 255}
+
+	config := GridConfig{
+		GeneralConfig: GeneralConfig{
+			OpacityAlpha:          1,
+			WatermarkWidthPercent: 10,
+			Adaptive:              &AdaptiveConfig{Variants: []image.Image{uniformImage(10, 10, black)}},
+		},
+		GridSpacingX: 10,
+		GridSpacingY: 10,
+	}
+	_, report, err := ApplyWithReport(splitImage(100, 40, black, white), uniformImage(10, 10, white), config.GeneralConfig, config)
+	if err != nil {
+		t.Fatalf("ApplyWithReport: %v", err)
+	}
+	if len(report.Positions) == 0 {
+		t.Fatal("ApplyWithReport placed no tiles")
+	}
+
+	for i, pos := range report.Positions {
+		want := 0
+		if pos.X >= 50 {
+			want = 1
+		}
+		if report.Variants[i] != want {
+			t.Errorf("tile at %v used variant %d, want %d", pos, report.Variants[i], want)
+		}
+	}
+}
+
+func TestAdaptiveConfigValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  AdaptiveConfig
+		wantErr string
+	}{
+		{"variants", AdaptiveConfig{Variants: []image.Image{image.NewNRGBA(image.Rect(0, 0, 1, 1))}}, ""},
+		{"tint colors", AdaptiveConfig{TintColors: []color.Color{color.White}}, ""},
+		{"empty", AdaptiveConfig{}, "at least one variant"},
+		{"nil variant", AdaptiveConfig{Variants: []image.Image{nil}}, "variant 0 is nil"},
+		{"nil tint color", AdaptiveConfig{TintColors: []color.Color{color.White, nil}}, "tint color 1 is nil"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checkValidateError(t, tt.config.validate(), tt.wantErr)
+		})
+	}
+}
@@ -0,0 +1,875 @@
+package imagewatermark
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+	"io"
+	"os"
+)
+
+// Animation holds the frames of an animated image, fully reconstructed so each frame can be watermarked on its own.
+//
+// Fields:
+//   - Frames: The frames, each one the complete picture shown at that time, all with the same bounds.
+//   - Delays: The delay of each frame, in 100ths of a second.
+//   - LoopCount: The number of times the animation loops (0 loops forever, -1 plays once), as in image/gif.
+type Animation struct {
+	Frames    []image.Image
+	Delays    []int
+	LoopCount int
+}
+
+// validate checks if the Animation has at least one frame and a delay per frame, and optionally frames of equal size.
+//
+// Parameters:
+//   - sameBounds: Whether all frames must have the same bounds, as required for inputs and encoding.
+//
+// Returns:
+//   - An error describing the first invalid value found, or nil if the animation is valid.
+func (a *Animation) validate(sameBounds bool) error {
+	if a == nil || len(a.Frames) == 0 {
+		return errors.New("animation has no frames")
+	}
+
+	if len(a.Delays) != len(a.Frames) {
+		return fmt.Errorf("animation has %d frames but %d delays", len(a.Frames), len(a.Delays))
+	}
+
+	bounds := a.Frames[0].Bounds()
+	for i, frame := range a.Frames {
+		if frame == nil {
+			return fmt.Errorf("frame %d is nil", i)
+		}
+		if sameBounds && frame.Bounds() != bounds {
+			return fmt.Errorf("frame %d bounds %v differ from the first frame bounds %v", i, frame.Bounds(), bounds)
+		}
+	}
+
+	return nil
+}
+
+// checkPixels checks the number of pixels of the frames of an animation.
+//
+// Parameters:
+//   - maxPixels: The maximum number of pixels of each frame, or 0 for no limit.
+//
+// Returns:
+//   - A LimitError wrapping ErrTooManyPixels, prefixed with the index of the first frame that is too large, or nil.
+func (a *Animation) checkPixels(maxPixels int) error {
+	if maxPixels == 0 {
+		return nil
+	}
+
+	for i, frame := range a.Frames {
+		bounds := frame.Bounds()
+		if pixels := int64(bounds.Dx()) * int64(bounds.Dy()); pixels > int64(maxPixels) {
+			return fmt.Errorf("frame %d: %w", i, &LimitError{Err: ErrTooManyPixels, Value: pixels, Limit: int64(maxPixels)})
+		}
+	}
+
+	return nil
+}
+
+// OpenGIF loads all frames of a (possibly animated) GIF file.
+//
+// It is OpenGIFWithOptions without limits; use OpenGIFWithOptions for untrusted files.
+//
+// Parameters:
+//   - path: The file path to the GIF image.
+//
+// Returns:
+//   - A pointer to an Animation with the reconstructed frames, their delays and the loop count.
+//   - An error if the file cannot be read or is not a valid GIF.
+//
+// Example:
+//
+//	anim, err := OpenGIF("banner.gif")
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+func OpenGIF(path string) (*Animation, error) {
+	return OpenGIFWithOptions(path, OpenOptions{})
+}
+
+// OpenGIFWithOptions loads all frames of a (possibly animated) GIF file, checking the limits of the options.
+//
+// The file size is checked before reading it, the format and the logical screen size are read from the header,
+// and the frames are counted from the file (without decompressing them) to check MaxAnimationPixels before any
+// frame is decoded. KeepCMYK does not apply to GIFs.
+//
+// Parameters:
+//   - path: The file path to the GIF image.
+//   - options: OpenOptions with the limits.
+//
+// Returns:
+//   - A pointer to an Animation with the reconstructed frames, their delays and the loop count.
+//   - An error if the options are invalid, the file cannot be read, is not a valid GIF or exceeds a limit
+//     (a *LimitError wrapping ErrFileTooLarge, ErrTooManyPixels or ErrFormatNotAllowed).
+//
+// Example:
+//
+//	anim, err := OpenGIFWithOptions(upload, OpenOptions{MaxPixels: 4_000_000, MaxAnimationPixels: 200_000_000})
+//	if errors.Is(err, ErrTooManyPixels) {
+//		// Reject the upload
+//	}
+func OpenGIFWithOptions(path string, options OpenOptions) (*Animation, error) {
+	if err := options.validate(); err != nil {
+		return nil, fmt.Errorf("invalid open options: %w", err)
+	}
+
+	data, err := readFileLimited(path, options.MaxFileBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	return decodeGIF(data, options)
+}
+
+// DecodeGIF reads all frames of a (possibly animated) GIF.
+//
+// GIF frames are usually partial updates of the previous picture. Each frame is composited over the
+// previous state, honoring the disposal method of the frame before it, so every returned frame is the
+// complete picture shown at that time.
+//
+// It is DecodeGIFWithOptions without limits; use DecodeGIFWithOptions for untrusted data.
+//
+// Parameters:
+//   - r: The reader containing the GIF data.
+//
+// Returns:
+//   - A pointer to an Animation with the reconstructed frames, their delays and the loop count.
+//   - An error if the data is not a valid GIF.
+func DecodeGIF(r io.Reader) (*Animation, error) {
+	return DecodeGIFWithOptions(r, OpenOptions{})
+}
+
+// DecodeGIFWithOptions reads all frames of a (possibly animated) GIF like DecodeGIF, checking the limits of
+// the options as OpenGIFWithOptions does.
+//
+// Parameters:
+//   - r: The reader containing the GIF data. MaxFileBytes limits how much of it is read.
+//   - options: OpenOptions with the limits.
+//
+// Returns:
+//   - A pointer to an Animation with the reconstructed frames, their delays and the loop count.
+//   - An error if the options are invalid, the data cannot be read, is not a valid GIF or exceeds a limit
+//     (a *LimitError).
+func DecodeGIFWithOptions(r io.Reader, options OpenOptions) (*Animation, error) {
+	if err := options.validate(); err != nil {
+		return nil, fmt.Errorf("invalid open options: %w", err)
+	}
+
+	data, err := readLimited(r, options.MaxFileBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	return decodeGIF(data, options)
+}
+
+// decodeGIF checks the limits of the options against a GIF file and reconstructs its frames.
+//
+// Parameters:
+//   - data: The contents of the GIF file.
+//   - options: Validated OpenOptions.
+//
+// Returns:
+//   - A pointer to an Animation with the reconstructed frames.
+//   - A LimitError if the file exceeds a limit, or an error if it is not a valid GIF.
+func decodeGIF(data []byte, options OpenOptions) (*Animation, error) {
+	if err := options.checkHeader(data); err != nil {
+		return nil, err
+	}
+
+	if options.MaxAnimationPixels > 0 {
+		config, err := gif.DecodeConfig(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+
+		frames, err := countGIFFrames(data)
+		if err != nil {
+			return nil, err
+		}
+
+		if pixels := int64(config.Width) * int64(config.Height) * int64(frames); pixels > options.MaxAnimationPixels {
+			return nil, &LimitError{Err: ErrTooManyPixels, Format: "gif", Value: pixels, Limit: options.MaxAnimationPixels}
+		}
+	}
+
+	decoded, err := gif.DecodeAll(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	bounds := image.Rect(0, 0, decoded.Config.Width, decoded.Config.Height)
+	if bounds.Empty() && len(decoded.Image) > 0 {
+		bounds = decoded.Image[0].Bounds()
+	}
+
+	animation := &Animation{LoopCount: decoded.LoopCount}
+	state := image.NewRGBA(bounds)
+
+	for i, frame := range decoded.Image {
+		var previous *image.RGBA
+		disposal := byte(gif.DisposalNone)
+		if i < len(decoded.Disposal) {
+			disposal = decoded.Disposal[i]
+		}
+		if disposal == gif.DisposalPrevious {
+			previous = cloneRGBA(state)
+		}
+
+		draw.Draw(state, frame.Bounds(), frame, frame.Bounds().Min, draw.Over)
+		animation.Frames = append(animation.Frames, cloneRGBA(state))
+
+		delay := 0
+		if i < len(decoded.Delay) {
+			delay = decoded.Delay[i]
+		}
+		animation.Delays = append(animation.Delays, delay)
+
+		switch disposal {
+		case gif.DisposalBackground:
+			draw.Draw(state, frame.Bounds(), image.Transparent, image.Point{}, draw.Src)
+		case gif.DisposalPrevious:
+			state = previous
+		}
+	}
+
+	return animation, nil
+}
+
+// countGIFFrames counts the image descriptors of a GIF file by skipping its blocks, without decompressing them.
+//
+// Parameters:
+//   - data: The contents of the GIF file.
+//
+// Returns:
+//   - The number of frames.
+//   - An error if the file is truncated or has an unknown block.
+func countGIFFrames(data []byte) (int, error) {
+	errTruncated := errors.New("gif: truncated file")
+
+	// Header and logical screen descriptor, followed by the optional global color table.
+	offset := 13
+	if len(data) < offset {
+		return 0, errTruncated
+	}
+	if flags := data[10]; flags&0x80 != 0 {
+		offset += 3 << (flags&0x07 + 1)
+	}
+
+	// skipSubBlocks skips a sequence of data sub-blocks, ended by an empty one.
+	skipSubBlocks := func() error {
+		for {
+			if offset >= len(data) {
+				return errTruncated
+			}
+			size := int(data[offset])
+			offset += 1 + size
+			if size == 0 {
+				return nil
+			}
+		}
+	}
+
+	frames := 0
+	for {
+		if offset >= len(data) {
+			return 0, errTruncated
+		}
+
+		switch data[offset] {
+		case 0x21: // Extension: introducer, label and sub-blocks.
+			offset += 2
+			if err := skipSubBlocks(); err != nil {
+				return 0, err
+			}
+		case 0x2C: // Image descriptor, optional local color table, LZW code size and sub-blocks.
+			if offset+10 > len(data) {
+				return 0, errTruncated
+			}
+			if flags := data[offset+9]; flags&0x80 != 0 {
+				offset += 3 << (flags&0x07 + 1)
+			}
+			offset += 11
+			if err := skipSubBlocks(); err != nil {
+				return 0, err
+			}
+			frames++
+		case 0x3B: // Trailer.
+			return frames, nil
+		default:
+			return 0, fmt.Errorf("gif: unknown block type: 0x%.2x", data[offset])
+		}
+	}
+}
+
+// SaveGIF encodes an animation as a GIF file.
+//
+// Parameters:
+//   - animation: The animation to save.
+//   - path: The destination file path.
+//
+// Returns:
+//   - An error if the animation is invalid or the file cannot be written. The destination is left untouched
+//     when the encoding fails.
+func SaveGIF(animation *Animation, path string) error {
+	// The animation is encoded in memory first, so an invalid animation or a failed encoding does not
+	// replace an existing file or leave a truncated one at the destination.
+	var buf bytes.Buffer
+	if err := EncodeGIF(&buf, animation); err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, buf.Bytes(), 0o666)
+}
+
+// EncodeGIF encodes an animation as a GIF and writes it to w.
+//
+// Pixels with less than 50% alpha become transparent. When all frames together use at most 256 colors, they
+// share one global palette and keep their exact colors; otherwise every frame is re-quantized to its own
+// palette with the median cut algorithm, so the colors of the watermark are represented alongside the
+// original ones.
+//
+// Only the rectangle that changed since the previous frame is written, over the previous frame
+// (DisposalNone), and frames identical to the previous one are merged into it by adding their delays. When a
+// pixel becomes transparent, the previous frame is extended over the changed area and cleared after it is
+// shown (DisposalBackground), and the next frame redraws that area. The total duration and the loop count are preserved.
+//
+// Parameters:
+//   - w: The destination writer.
+//   - animation: The animation to encode.
+//
+// Returns:
+//   - An error if the animation is invalid or the encoding fails.
+func EncodeGIF(w io.Writer, animation *Animation) error {
+	if err := animation.validate(true); err != nil {
+		return fmt.Errorf("invalid animation: %w", err)
+	}
+
+	frames := make([]*image.NRGBA, len(animation.Frames))
+	processBatch(len(frames), 0, func(index int) {
+		frames[index] = snapGIFAlpha(animation.Frames[index])
+	})
+
+	bounds := frames[0].Bounds()
+	encoded := &gif.GIF{
+		LoopCount: animation.LoopCount,
+		Config:    image.Config{Width: bounds.Dx(), Height: bounds.Dy()},
+	}
+
+	// sources holds the index of the animation frame written by each GIF frame.
+	var sources []int
+	var rects []image.Rectangle
+
+	for i, frame := range frames {
+		rect := bounds
+		if i > 0 {
+			changed, cleared := diffGIFFrames(frames[i-1], frame)
+			if changed.Empty() {
+				encoded.Delay[len(encoded.Delay)-1] += animation.Delays[i]
+				continue
+			}
+
+			rect = changed
+			if cleared {
+				// DisposalNone cannot make a pixel transparent again, so the previous frame is extended over
+				// the changed area, cleared after it is shown, and its whole area is redrawn.
+				last := len(rects) - 1
+				rects[last] = rects[last].Union(changed)
+				encoded.Disposal[last] = gif.DisposalBackground
+				rect = rects[last]
+			}
+		}
+
+		sources = append(sources, i)
+		rects = append(rects, rect)
+		encoded.Delay = append(encoded.Delay, animation.Delays[i])
+		encoded.Disposal = append(encoded.Disposal, gif.DisposalNone)
+	}
+
+	palette, indexes := gifGlobalPalette(frames)
+	if palette != nil {
+		encoded.Config.ColorModel = palette
+	}
+
+	encoded.Image = make([]*image.Paletted, len(sources))
+	processBatch(len(sources), 0, func(index int) {
+		region := frames[sources[index]].SubImage(rects[index]).(*image.NRGBA)
+		if palette == nil {
+			encoded.Image[index] = quantize(region, 256, false)
+			return
+		}
+
+		paletted := image.NewPaletted(rects[index], palette)
+		for y := rects[index].Min.Y; y < rects[index].Max.Y; y++ {
+			for x := rects[index].Min.X; x < rects[index].Max.X; x++ {
+				paletted.SetColorIndex(x, y, indexes[region.NRGBAAt(x, y)])
+			}
+		}
+		encoded.Image[index] = paletted
+	})
+
+	return gif.EncodeAll(w, encoded)
+}
+
+// snapGIFAlpha converts a frame to NRGBA with bounds at the origin, making pixels below 50% alpha fully
+// transparent (with zero color) and the others fully opaque, as they will be written.
+func snapGIFAlpha(img image.Image) *image.NRGBA {
+	src := toNRGBA(img)
+	result := image.NewNRGBA(src.Bounds())
+
+	for y := 0; y < src.Bounds().Dy(); y++ {
+		srcRow := src.Pix[y*src.Stride:]
+		dstRow := result.Pix[y*result.Stride:]
+		for x := 0; x < src.Bounds().Dx(); x++ {
+			p, q := srcRow[x*4:x*4+4:x*4+4], dstRow[x*4:x*4+4:x*4+4]
+			if p[3] >= quantizeAlphaThreshold {
+				q[0], q[1], q[2], q[3] = p[0], p[1], p[2], 255
+			}
+		}
+	}
+
+	return result
+}
+
+// diffGIFFrames compares two consecutive frames with the same bounds.
+//
+// Returns:
+//   - The bounding rectangle of the pixels that changed, empty when the frames are identical.
+//   - Whether any pixel went from opaque to transparent.
+func diffGIFFrames(previous, current *image.NRGBA) (image.Rectangle, bool) {
+	var changed image.Rectangle
+	cleared := false
+
+	for y := 0; y < current.Bounds().Dy(); y++ {
+		previousRow := previous.Pix[y*previous.Stride:]
+		currentRow := current.Pix[y*current.Stride:]
+		for x := 0; x < current.Bounds().Dx(); x++ {
+			p, c := previousRow[x*4:x*4+4:x*4+4], currentRow[x*4:x*4+4:x*4+4]
+			if p[0] == c[0] && p[1] == c[1] && p[2] == c[2] && p[3] == c[3] {
+				continue
+			}
+
+			changed = changed.Union(image.Rect(x, y, x+1, y+1))
+			cleared = cleared || c[3] == 0
+		}
+	}
+
+	return changed, cleared
+}
+
+// gifGlobalPalette builds an exact palette shared by all frames, when they use at most 256 colors together.
+//
+// Returns:
+//   - The palette, with a transparent entry when any frame has transparency, or nil if there are too many colors.
+//   - The palette index of each color.
+func gifGlobalPalette(frames []*image.NRGBA) (color.Palette, map[color.NRGBA]uint8) {
+	indexes := make(map[color.NRGBA]uint8)
+	var palette color.Palette
+
+	for _, frame := range frames {
+		for i := 0; i < len(frame.Pix); i += 4 {
+			c := color.NRGBA{R: frame.Pix[i], G: frame.Pix[i+1], B: frame.Pix[i+2], A: frame.Pix[i+3]}
+			if _, ok := indexes[c]; ok {
+				continue
+			}
+			if len(palette) == 256 {
+				return nil, nil
+			}
+			indexes[c] = uint8(len(palette))
+			palette = append(palette, c)
+		}
+	}
+
+	return palette, indexes
+}
+
+// ApplySingleToAnimation applies a single watermark to every frame of an animation.
+//
+// See ApplyToAnimationWithPlacer for how decisions are kept stable across frames.
+//
+// Parameters:
+//   - animation: The animation to watermark.
+//   - watermarkImg: The watermark image to overlay on each frame.
+//   - config: SingleConfig containing all watermark settings.
+//
+// Returns:
+//   - A pointer to a new Animation with the watermarked frames and the original delays and loop count.
+//   - An error if the configuration or the animation is invalid, or its frames exceed MaxInputPixels.
+func ApplySingleToAnimation(animation *Animation, watermarkImg image.Image, config SingleConfig) (*Animation, error) {
+	if err := config.validate(); err != nil {
+		return nil, fmt.Errorf("invalid single watermark configuration: %w", err)
+	}
+
+	return applyToAnimation(animation, watermarkImg, config.GeneralConfig, config)
+}
+
+// ApplyGridToAnimation applies a grid of watermarks to every frame of an animation.
+//
+// See ApplyToAnimationWithPlacer for how decisions are kept stable across frames.
+//
+// Parameters:
+//   - animation: The animation to watermark.
+//   - watermarkImg: The watermark image to overlay on each frame.
+//   - config: GridConfig containing all watermark settings.
+//
+// Returns:
+//   - A pointer to a new Animation with the watermarked frames and the original delays and loop count.
+//   - An error if the configuration or the animation is invalid, or its frames exceed MaxInputPixels.
+func ApplyGridToAnimation(animation *Animation, watermarkImg image.Image, config GridConfig) (*Animation, error) {
+	if err := config.validate(); err != nil {
+		return nil, fmt.Errorf("invalid grid watermark configuration: %w", err)
+	}
+
+	return applyToAnimation(animation, watermarkImg, config.GeneralConfig, config)
+}
+
+// ApplyToAnimationWithPlacer applies a watermark to every frame of an animation using a custom Placer.
+//
+// Placement and appearance are decided once, on the first frame: positions (including random alignments),
+// exclusions, detections, adaptive variants and automatic opacity stay the same on every frame, so the watermark
+// does not jump or flicker. Underlay and ClipToInputAlpha are evaluated on each frame, since they follow its content.
+// Frames are rendered concurrently, limited by MaxWorkers.
+//
+// Parameters:
+//   - animation: The animation to watermark.
+//   - watermarkImg: The watermark image to overlay on each frame.
+//   - config: GeneralConfig containing the watermark appearance and concurrency settings.
+//   - placer: The Placer that computes where the watermark is drawn.
+//
+// Returns:
+//   - A pointer to a new Animation with the watermarked frames and the original delays and loop count.
+//   - An error if the configuration or the animation is invalid, the placer is nil or its frames exceed
+//     MaxInputPixels.
+func ApplyToAnimationWithPlacer(animation *Animation, watermarkImg image.Image, config GeneralConfig, placer Placer) (*Animation, error) {
+	if err := validatePlacer(config, placer); err != nil {
+		return nil, fmt.Errorf("invalid watermark configuration: %w", err)
+	}
+
+	return applyToAnimation(animation, watermarkImg, config, placer)
+}
+
+// applyToAnimation runs the watermarking pipeline on every frame with an already validated configuration.
+//
+// Parameters:
+//   - animation: The animation to watermark.
+//   - watermarkImg: The original watermark image.
+//   - config: GeneralConfig containing the watermark appearance and concurrency settings.
+//   - placer: The Placer that computes where the watermark is drawn.
+//
+// Returns:
+//   - A pointer to a new Animation with the watermarked frames.
+//   - An error if the animation is invalid or its frames exceed MaxInputPixels.
+func applyToAnimation(animation *Animation, watermarkImg image.Image, config GeneralConfig, placer Placer) (*Animation, error) {
+	if err := animation.validate(true); err != nil {
+		return nil, fmt.Errorf("invalid animation: %w", err)
+	}
+
+	if err := animation.checkPixels(config.MaxInputPixels); err != nil {
+		return nil, fmt.Errorf("input animation: %w", err)
+	}
+
+	preparedWM := prepareWatermark(watermarkImg, config)
+
+	first := generateBaseCanvas(animation.Frames[0])
+	layout := planLayer(first, animation.Frames[0], preparedWM, config, placer)
+
+	result := &Animation{
+		Frames:    make([]image.Image, len(animation.Frames)),
+		Delays:    append([]int(nil), animation.Delays...),
+		LoopCount: animation.LoopCount,
+	}
+
+	processBatch(len(animation.Frames), config.MaxWorkers, func(index int) {
+		canvas := generateBaseCanvas(animation.Frames[index])
+		layout.paint(canvas, animation.Frames[index], config, BlendNormal)
+		result.Frames[index] = canvas
+	})
+
+	return result, nil
+}
+
+// defaultFrameDelay is the delay, in 100ths of a second, assumed for frames with a zero delay when
+// timelines are merged, matching how browsers play such frames.
+const defaultFrameDelay = 10
+
+// StillAnimation wraps a static image into a single-frame Animation, so it can be used as the input
+// (or the watermark) of the animated watermark functions.
+//
+// Parameters:
+//   - img: The static image.
+//
+// Returns:
+//   - A pointer to an Animation with one frame and no delay.
+func StillAnimation(img image.Image) *Animation {
+	return &Animation{Frames: []image.Image{img}, Delays: []int{0}}
+}
+
+// ApplySingleWithAnimatedWatermark applies an animated watermark as a single watermark to a static or animated input.
+//
+// See ApplyAnimatedWatermarkWithPlacer for how the timelines are synchronized.
+//
+// Parameters:
+//   - input: The input animation. Use StillAnimation for a static image.
+//   - watermark: The watermark frames and their delays. Frames may have different sizes.
+//   - config: SingleConfig containing all watermark settings.
+//
+// Returns:
+//   - A pointer to a new Animation with the merged timeline.
+//   - An error if the configuration or any of the animations is invalid, or the input frames exceed MaxInputPixels.
+func ApplySingleWithAnimatedWatermark(input, watermark *Animation, config SingleConfig) (*Animation, error) {
+	if err := config.validate(); err != nil {
+		return nil, fmt.Errorf("invalid single watermark configuration: %w", err)
+	}
+
+	return applyAnimatedWatermark(input, watermark, config.GeneralConfig, config)
+}
+
+// ApplyGridWithAnimatedWatermark applies an animated watermark as a grid to a static or animated input.
+//
+// See ApplyAnimatedWatermarkWithPlacer for how the timelines are synchronized.
+//
+// Parameters:
+//   - input: The input animation. Use StillAnimation for a static image.
+//   - watermark: The watermark frames and their delays. Frames may have different sizes.
+//   - config: GridConfig containing all watermark settings.
+//
+// Returns:
+//   - A pointer to a new Animation with the merged timeline.
+//   - An error if the configuration or any of the animations is invalid, or the input frames exceed MaxInputPixels.
+func ApplyGridWithAnimatedWatermark(input, watermark *Animation, config GridConfig) (*Animation, error) {
+	if err := config.validate(); err != nil {
+		return nil, fmt.Errorf("invalid grid watermark configuration: %w", err)
+	}
+
+	return applyAnimatedWatermark(input, watermark, config.GeneralConfig, config)
+}
+
+// ApplyAnimatedWatermarkWithPlacer applies an animated watermark to a static or animated input using a custom Placer.
+//
+// The output lasts one loop of the input, or one loop of the watermark when the input is static, and keeps
+// the loop count of the input (of the watermark when the input is static). The watermark loops when it is
+// shorter than the input. When it is longer, it is cut at the end of the input loop, and every loop of the
+// output restarts it from its first frame, so its last frames may never be shown. A new output frame starts
+// whenever either the input or the watermark changes, so both keep their timing. Zero delays are treated as
+// 10 (100ms), as browsers do.
+//
+// Every watermark frame is sized with the same settings, and placement is decided once with the first frames:
+// each watermark frame is centered where the first one was placed, so a pulsing or rotating logo stays in place.
+// Automatic opacity and detections are also taken from the first frames. Positions are never relocated again:
+// when a later watermark frame overlaps an excluded area or an avoided detection, it is clipped (or skipped
+// with ExclusionSkip).
+//
+// Parameters:
+//   - input: The input animation. Use StillAnimation for a static image.
+//   - watermark: The watermark frames and their delays. Frames may have different sizes.
+//   - config: GeneralConfig containing the watermark appearance and concurrency settings.
+//   - placer: The Placer that computes where the watermark is drawn.
+//
+// Returns:
+//   - A pointer to a new Animation with the merged timeline.
+//   - An error if the configuration or any of the animations is invalid, the placer is nil or the input frames
+//     exceed MaxInputPixels.
+//
+// Example:
+//
+//	logo, err := OpenGIF("pulsing_logo.gif")
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	result, err := ApplyAnimatedWatermarkWithPlacer(StillAnimation(thumbnail), logo, config.GeneralConfig, config)
+func ApplyAnimatedWatermarkWithPlacer(input, watermark *Animation, config GeneralConfig, placer Placer) (*Animation, error) {
+	if err := validatePlacer(config, placer); err != nil {
+		return nil, fmt.Errorf("invalid watermark configuration: %w", err)
+	}
+
+	return applyAnimatedWatermark(input, watermark, config, placer)
+}
+
+// applyAnimatedWatermark runs the animated watermarking pipeline with an already validated configuration.
+//
+// Parameters:
+//   - input: The input animation.
+//   - watermark: The watermark animation.
+//   - config: GeneralConfig containing the watermark appearance and concurrency settings.
+//   - placer: The Placer that computes where the watermark is drawn.
+//
+// Returns:
+//   - A pointer to a new Animation with the merged timeline.
+//   - An error if any of the animations is invalid, or the input frames exceed MaxInputPixels.
+func applyAnimatedWatermark(input, watermark *Animation, config GeneralConfig, placer Placer) (*Animation, error) {
+	if err := input.validate(true); err != nil {
+		return nil, fmt.Errorf("invalid input animation: %w", err)
+	}
+
+	if err := input.checkPixels(config.MaxInputPixels); err != nil {
+		return nil, fmt.Errorf("input animation: %w", err)
+	}
+
+	if err := watermark.validate(false); err != nil {
+		return nil, fmt.Errorf("invalid watermark animation: %w", err)
+	}
+
+	firstInput := input.Frames[0]
+	firstCanvas := generateBaseCanvas(firstInput)
+
+	layouts := make([]layerLayout, len(watermark.Frames))
+	layouts[0] = planLayer(firstCanvas, firstInput, prepareWatermark(watermark.Frames[0], config), config, placer)
+
+	// Pin the decisions of the first frame, so that every watermark frame is placed and faded the same way.
+	first := layouts[0].report
+	pinned := config
+	pinned.AutoOpacity = nil
+	pinned.OpacityAlpha = first.Opacity
+	if config.Detection != nil {
+		detection := *config.Detection
+		detection.Detector = DetectorFunc(func(image.Image) []image.Rectangle { return first.Detections })
+		detection.PaddingPercent = 0
+		pinned.Detection = &detection
+	}
+	// The pinned positions were already relocated or skipped on the first frames. Relocating them again for the
+	// footprint of another watermark frame would make the logo jump, so overlaps are only clipped (or skipped).
+	if config.Exclusions != nil || (config.Detection != nil && config.Detection.Action == DetectionAvoid) {
+		var exclusions Exclusions
+		if config.Exclusions != nil {
+			exclusions = *config.Exclusions
+		}
+		if exclusions.Mode != ExclusionSkip {
+			exclusions.Mode = ExclusionClip
+		}
+		pinned.Exclusions = &exclusions
+	}
+	pinnedPlacer := PlacerFunc(func(_ image.Image, wmBounds image.Rectangle) []image.Point {
+		offset := layouts[0].footprint.Size().Sub(wmBounds.Size()).Div(2)
+		positions := make([]image.Point, len(first.Positions))
+		for i, pos := range first.Positions {
+			positions[i] = pos.Add(offset)
+		}
+		return positions
+	})
+
+	processBatch(len(watermark.Frames)-1, config.MaxWorkers, func(index int) {
+		preparedWM := prepareWatermark(watermark.Frames[index+1], pinned)
+		layouts[index+1] = planLayer(firstCanvas, firstInput, preparedWM, pinned, pinnedPlacer)
+	})
+
+	timeline := mergeTimelines(input.Delays, watermark.Delays)
+
+	loopCount := input.LoopCount
+	if len(input.Frames) == 1 {
+		loopCount = watermark.LoopCount
+	}
+
+	result := &Animation{
+		Frames:    make([]image.Image, len(timeline)),
+		Delays:    make([]int, len(timeline)),
+		LoopCount: loopCount,
+	}
+
+	processBatch(len(timeline), config.MaxWorkers, func(index int) {
+		frame := timeline[index]
+		inputFrame := input.Frames[frame.input]
+
+		canvas := generateBaseCanvas(inputFrame)
+		layouts[frame.watermark].paint(canvas, inputFrame, config, BlendNormal)
+
+		result.Frames[index] = canvas
+		result.Delays[index] = frame.delay
+	})
+
+	return result, nil
+}
+
+// timelineFrame is one frame of a merged timeline.
+//
+// Fields:
+//   - input: The index of the input frame shown.
+//   - watermark: The index of the watermark frame shown.
+//   - delay: How long the frame is shown, in 100ths of a second.
+type timelineFrame struct {
+	input     int
+	watermark int
+	delay     int
+}
+
+// mergeTimelines combines the input and watermark timelines into frames that change whenever either one changes.
+//
+// The merged timeline lasts one loop of the input, or one loop of the watermark when the input has a single frame.
+// The shorter timeline loops as needed.
+//
+// Parameters:
+//   - inputDelays: The delays of the input frames.
+//   - watermarkDelays: The delays of the watermark frames.
+//
+// Returns:
+//   - The merged frames.
+func mergeTimelines(inputDelays, watermarkDelays []int) []timelineFrame {
+	normalize := func(delays []int) []int {
+		result := make([]int, len(delays))
+		for i, delay := range delays {
+			result[i] = delay
+			if delay <= 0 {
+				result[i] = defaultFrameDelay
+			}
+		}
+		return result
+	}
+
+	if len(inputDelays) == 1 && len(watermarkDelays) == 1 {
+		return []timelineFrame{{delay: inputDelays[0]}}
+	}
+
+	input, wm := normalize(inputDelays), normalize(watermarkDelays)
+	if len(inputDelays) == 1 {
+		input[0] = 0
+		for _, delay := range wm {
+			input[0] += delay
+		}
+	}
+
+	duration := 0
+	for _, delay := range input {
+		duration += delay
+	}
+
+	// A static watermark never changes, so it must not split the input frames.
+	if len(watermarkDelays) == 1 {
+		wm[0] = duration
+	}
+
+	var frames []timelineFrame
+	inputIndex, wmIndex := 0, 0
+	inputEnd, wmEnd := input[0], wm[0]
+
+	for now := 0; now < duration; {
+		next := min(inputEnd, wmEnd)
+		frames = append(frames, timelineFrame{input: inputIndex, watermark: wmIndex, delay: next - now})
+		now = next
+
+		if now == inputEnd && inputIndex+1 < len(input) {
+			inputIndex++
+			inputEnd += input[inputIndex]
+		}
+		if now == wmEnd {
+			wmIndex = (wmIndex + 1) % len(wm)
+			wmEnd += wm[wmIndex]
+		}
+	}
+
+	return frames
+}
+
+// cloneRGBA returns a deep copy of an RGBA image.
+func cloneRGBA(img *image.RGBA) *image.RGBA {
+	clone := *img
+	clone.Pix = append([]uint8(nil), img.Pix...)
+	return &clone
+}
@@ -0,0 +1,378 @@
+package imagewatermark
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/gif"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync/atomic"
+	"testing"
+)
+
+// palettedRect returns a paletted image covering rect, filled with one palette index.
+func palettedRect(rect image.Rectangle, palette color.Palette, index uint8) *image.Paletted {
+	img := image.NewPaletted(rect, palette)
+	for i := range img.Pix {
+		img.Pix[i] = index
+	}
+	return img
+}
+
+func TestDecodeGIFDisposal(t *testing.T) {
+	red := color.NRGBA{R: 255, A: 255}
+	blue := color.NRGBA{B: 255, A: 255}
+	green := color.NRGBA{G: 255, A: 255}
+	transparent := color.NRGBA{}
+	palette := color.Palette{transparent, red, blue, green}
+
+	encoded := &gif.GIF{
+		Image: []*image.Paletted{
+			palettedRect(image.Rect(0, 0, 4, 4), palette, 1),
+			palettedRect(image.Rect(0, 0, 2, 2), palette, 2),
+			palettedRect(image.Rect(3, 3, 4, 4), palette, 3),
+			palettedRect(image.Rect(3, 0, 4, 1), palette, 0),
+		},
+		Delay:     []int{10, 20, 30, 40},
+		Disposal:  []byte{gif.DisposalNone, gif.DisposalBackground, gif.DisposalPrevious, gif.DisposalNone},
+		LoopCount: 3,
+		Config:    image.Config{Width: 4, Height: 4, ColorModel: palette},
+	}
+	var buf bytes.Buffer
+	if err := gif.EncodeAll(&buf, encoded); err != nil {
+		t.Fatalf("gif.EncodeAll: %v", err)
+	}
+
+	animation, err := DecodeGIF(&buf)
+	if err != nil {
+		t.Fatalf("DecodeGIF: %v", err)
+	}
+	if len(animation.Frames) != 4 || !slices.Equal(animation.Delays, []int{10, 20, 30, 40}) || animation.LoopCount != 3 {
+		t.Fatalf("DecodeGIF() = %d frames, delays %v, loop count %d", len(animation.Frames), animation.Delays, animation.LoopCount)
+	}
+
+	tests := []struct {
+		name  string
+		frame int
+		want  map[image.Point]color.NRGBA
+	}{
+		{"first frame", 0, map[image.Point]color.NRGBA{{0, 0}: red, {3, 3}: red}},
+		{"partial frame over the first", 1, map[image.Point]color.NRGBA{{0, 0}: blue, {1, 1}: blue, {2, 2}: red, {3, 3}: red}},
+		{"background disposal clears the area", 2, map[image.Point]color.NRGBA{{0, 0}: transparent, {1, 1}: transparent, {2, 2}: red, {3, 3}: green}},
+		{"previous disposal restores the area", 3, map[image.Point]color.NRGBA{{0, 0}: transparent, {3, 3}: red, {3, 0}: red}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			frame := animation.Frames[tt.frame]
+			if frame.Bounds() != image.Rect(0, 0, 4, 4) {
+				t.Errorf("frame bounds = %v, want the logical screen", frame.Bounds())
+			}
+			for p, want := range tt.want {
+				if got := nrgbaAt(frame, p.X, p.Y); got != want {
+					t.Errorf("pixel %v = %v, want %v", p, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestEncodeGIFRoundTrip(t *testing.T) {
+	red := color.NRGBA{R: 255, A: 255}
+	blue := color.NRGBA{B: 255, A: 255}
+	transparent := color.NRGBA{}
+
+	withSquare := func(background color.NRGBA, square image.Rectangle, c color.NRGBA) image.Image {
+		img := uniformImage(8, 8, background)
+		for y := square.Min.Y; y < square.Max.Y; y++ {
+			for x := square.Min.X; x < square.Max.X; x++ {
+				img.SetNRGBA(x, y, c)
+			}
+		}
+		return img
+	}
+
+	gradient := image.NewNRGBA(image.Rect(0, 0, 20, 20))
+	for i := 0; i < len(gradient.Pix); i += 4 {
+		gradient.Pix[i], gradient.Pix[i+1], gradient.Pix[i+3] = uint8(i/4), uint8(i/8), 255
+	}
+
+	tests := []struct {
+		name       string
+		animation  *Animation
+		want       []image.Image
+		wantDelays []int
+		// tolerance allows for palette quantization.
+		tolerance int
+	}{
+		{
+			name: "moving square",
+			animation: &Animation{
+				Frames: []image.Image{withSquare(red, image.Rect(0, 0, 2, 2), blue), withSquare(red, image.Rect(4, 4, 6, 6), blue)},
+				Delays: []int{5, 7},
+			},
+		},
+		{
+			name: "identical frames are merged",
+			animation: &Animation{
+				Frames: []image.Image{uniformImage(8, 8, red), uniformImage(8, 8, red), withSquare(red, image.Rect(0, 0, 1, 1), blue)},
+				Delays: []int{5, 7, 9},
+			},
+			want:       []image.Image{uniformImage(8, 8, red), withSquare(red, image.Rect(0, 0, 1, 1), blue)},
+			wantDelays: []int{12, 9},
+		},
+		{
+			name: "pixels become transparent again",
+			animation: &Animation{
+				Frames: []image.Image{withSquare(transparent, image.Rect(2, 2, 6, 6), red), withSquare(transparent, image.Rect(3, 3, 4, 4), red), uniformImage(8, 8, transparent)},
+				Delays: []int{1, 2, 3},
+			},
+		},
+		{
+			name: "semi-transparent pixels are snapped",
+			animation: &Animation{
+				Frames: []image.Image{withSquare(color.NRGBA{R: 255, A: 100}, image.Rect(0, 0, 4, 8), color.NRGBA{B: 255, A: 200})},
+				Delays: []int{1},
+			},
+			want:       []image.Image{withSquare(transparent, image.Rect(0, 0, 4, 8), blue)},
+			wantDelays: []int{1},
+		},
+		{
+			name: "more than 256 colors",
+			animation: &Animation{
+				Frames: []image.Image{gradient},
+				Delays: []int{1},
+			},
+			tolerance: 24,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			want, wantDelays := tt.want, tt.wantDelays
+			if want == nil {
+				want, wantDelays = tt.animation.Frames, tt.animation.Delays
+			}
+
+			var buf bytes.Buffer
+			if err := EncodeGIF(&buf, tt.animation); err != nil {
+				t.Fatalf("EncodeGIF: %v", err)
+			}
+			decoded, err := DecodeGIF(&buf)
+			if err != nil {
+				t.Fatalf("DecodeGIF: %v", err)
+			}
+
+			if len(decoded.Frames) != len(want) || !slices.Equal(decoded.Delays, wantDelays) {
+				t.Fatalf("round trip = %d frames with delays %v, want %d frames with delays %v", len(decoded.Frames), decoded.Delays, len(want), wantDelays)
+			}
+			for i := range want {
+				bounds := want[i].Bounds()
+				for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+					for x := bounds.Min.X; x < bounds.Max.X; x++ {
+						got, w := nrgbaAt(decoded.Frames[i], x, y), nrgbaAt(want[i], x, y)
+						if got.A != w.A || (w.A != 0 && (absInt(int(got.R)-int(w.R)) > tt.tolerance ||
+							absInt(int(got.G)-int(w.G)) > tt.tolerance || absInt(int(got.B)-int(w.B)) > tt.tolerance)) {
+							t.Fatalf("frame %d pixel (%d, %d) = %v, want %v", i, x, y, got, w)
+						}
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestSaveGIFFailureKeepsExistingFile(t *testing.T) {
+	frame := image.NewNRGBA(image.Rect(0, 0, 4, 4))
+
+	tests := []struct {
+		name      string
+		animation *Animation
+	}{
+		{"nil", nil},
+		{"missing delays", &Animation{Frames: []image.Image{frame}}},
+		{"different bounds", &Animation{Frames: []image.Image{frame, image.NewNRGBA(image.Rect(0, 0, 2, 2))}, Delays: []int{1, 1}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "out.gif")
+			if err := os.WriteFile(path, []byte("previous"), 0o666); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := SaveGIF(tt.animation, path); err == nil {
+				t.Fatal("SaveGIF() = nil, want an error")
+			}
+			if data, _ := os.ReadFile(path); string(data) != "previous" {
+				t.Errorf("existing file content = %q, want it untouched", data)
+			}
+		})
+	}
+}
+
+func TestAnimationValidate(t *testing.T) {
+	frame := image.NewNRGBA(image.Rect(0, 0, 4, 4))
+
+	tests := []struct {
+		name       string
+		animation  *Animation
+		sameBounds bool
+		wantErr    string
+	}{
+		{"valid", &Animation{Frames: []image.Image{frame, frame}, Delays: []int{1, 2}}, true, ""},
+		{"different bounds allowed", &Animation{Frames: []image.Image{frame, image.NewNRGBA(image.Rect(0, 0, 2, 2))}, Delays: []int{1, 2}}, false, ""},
+		{"nil", nil, true, "no frames"},
+		{"no frames", &Animation{}, true, "no frames"},
+		{"missing delays", &Animation{Frames: []image.Image{frame, frame}, Delays: []int{1}}, true, "2 frames but 1 delays"},
+		{"nil frame", &Animation{Frames: []image.Image{frame, nil}, Delays: []int{1, 2}}, false, "frame 1 is nil"},
+		{"different bounds", &Animation{Frames: []image.Image{frame, image.NewNRGBA(image.Rect(0, 0, 2, 2))}, Delays: []int{1, 2}}, true, "frame 1 bounds"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checkValidateError(t, tt.animation.validate(tt.sameBounds), tt.wantErr)
+		})
+	}
+}
+
+func TestApplySingleToAnimation(t *testing.T) {
+	white := color.NRGBA{R: 255, G: 255, B: 255, A: 255}
+	black := color.NRGBA{A: 255}
+	red := color.NRGBA{R: 255, A: 255}
+
+	animation := &Animation{
+		Frames:    []image.Image{uniformImage(50, 50, white), uniformImage(50, 50, black)},
+		Delays:    []int{3, 4},
+		LoopCount: -1,
+	}
+	config := SingleConfig{GeneralConfig: GeneralConfig{OpacityAlpha: 1, WatermarkWidthPercent: 20}, Placement: PlacementAbsolute, Position: image.Pt(5, 5)}
+
+	result, err := ApplySingleToAnimation(animation, uniformImage(10, 10, red), config)
+	if err != nil {
+		t.Fatalf("ApplySingleToAnimation: %v", err)
+	}
+
+	if !slices.Equal(result.Delays, animation.Delays) || result.LoopCount != animation.LoopCount {
+		t.Errorf("result delays %v and loop count %d, want %v and %d", result.Delays, result.LoopCount, animation.Delays, animation.LoopCount)
+	}
+	for i, background := range []color.NRGBA{white, black} {
+		if got := nrgbaAt(result.Frames[i], 10, 10); got != red {
+			t.Errorf("frame %d watermark pixel = %v, want %v", i, got, red)
+		}
+		if got := nrgbaAt(result.Frames[i], 30, 30); got != background {
+			t.Errorf("frame %d background pixel = %v, want %v", i, got, background)
+		}
+	}
+}
+
+func TestMergeTimelines(t *testing.T) {
+	tests := []struct {
+		name      string
+		input     []int
+		watermark []int
+		want      []timelineFrame
+	}{
+		{"both static", []int{5}, []int{7}, []timelineFrame{{delay: 5}}},
+		{"static input", []int{5}, []int{10, 20}, []timelineFrame{{0, 0, 10}, {0, 1, 20}}},
+		{"static watermark", []int{10, 20}, []int{7}, []timelineFrame{{0, 0, 10}, {1, 0, 20}}},
+		{"watermark loops", []int{30, 30}, []int{10, 10}, []timelineFrame{{0, 0, 10}, {0, 1, 10}, {0, 0, 10}, {1, 1, 10}, {1, 0, 10}, {1, 1, 10}}},
+		{"watermark is cut", []int{10, 10}, []int{15, 15}, []timelineFrame{{0, 0, 10}, {1, 0, 5}, {1, 1, 5}}},
+		{"aligned changes", []int{10, 10}, []int{5, 5}, []timelineFrame{{0, 0, 5}, {0, 1, 5}, {1, 0, 5}, {1, 1, 5}}},
+		{"zero delays", []int{0, 0}, []int{5}, []timelineFrame{{0, 0, defaultFrameDelay}, {1, 0, defaultFrameDelay}}},
+		{"zero watermark delays", []int{20}, []int{0, -1}, []timelineFrame{{0, 0, defaultFrameDelay}, {0, 1, defaultFrameDelay}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := mergeTimelines(tt.input, tt.watermark); !slices.Equal(got, tt.want) {
+				t.Errorf("mergeTimelines(%v, %v) = %v, want %v", tt.input, tt.watermark, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyAnimatedWatermarkPinned(t *testing.T) {
+	white := color.NRGBA{R: 255, G: 255, B: 255, A: 255}
+	red := color.NRGBA{R: 255, A: 255}
+	blue := color.NRGBA{B: 255, A: 255}
+
+	// The second watermark frame is four times taller, so centered on the first one it reaches the excluded band.
+	watermark := &Animation{
+		Frames: []image.Image{uniformImage(10, 10, red), uniformImage(10, 40, blue)},
+		Delays: []int{10, 10},
+	}
+	band := image.Rect(0, 0, 100, 20)
+
+	tests := []struct {
+		name string
+		mode ExclusionMode
+		want [2]map[image.Point]color.NRGBA
+	}{
+		{
+			name: "overlaps are clipped",
+			mode: ExclusionAuto,
+			want: [2]map[image.Point]color.NRGBA{
+				{{50, 50}: red, {50, 30}: white},
+				{{50, 50}: blue, {50, 25}: blue, {50, 17}: white, {50, 90}: blue},
+			},
+		},
+		{
+			name: "overlaps are skipped",
+			mode: ExclusionSkip,
+			want: [2]map[image.Point]color.NRGBA{
+				{{50, 50}: red},
+				{{50, 50}: white, {50, 25}: white},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := SingleConfig{
+				GeneralConfig: GeneralConfig{OpacityAlpha: 1, WatermarkWidthPercent: 20, Exclusions: &Exclusions{Rects: []image.Rectangle{band}, Mode: tt.mode}},
+				Placement:     PlacementAbsolute,
+				Position:      image.Pt(45, 45),
+			}
+
+			result, err := ApplySingleWithAnimatedWatermark(StillAnimation(uniformImage(100, 100, white)), watermark, config)
+			if err != nil {
+				t.Fatalf("ApplySingleWithAnimatedWatermark: %v", err)
+			}
+			if len(result.Frames) != 2 {
+				t.Fatalf("result has %d frames, want 2", len(result.Frames))
+			}
+
+			for i, want := range tt.want {
+				for p, c := range want {
+					if got := nrgbaAt(result.Frames[i], p.X, p.Y); got != c {
+						t.Errorf("frame %d pixel %v = %v, want %v", i, p, got, c)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestApplyAnimatedWatermarkDetectsOnce(t *testing.T) {
+	var calls atomic.Int32
+	detector := DetectorFunc(func(image.Image) []image.Rectangle {
+		calls.Add(1)
+		return []image.Rectangle{image.Rect(0, 0, 30, 30)}
+	})
+
+	watermark := &Animation{
+		Frames: []image.Image{uniformImage(10, 10, color.NRGBA{R: 255, A: 255}), uniformImage(20, 20, color.NRGBA{B: 255, A: 255}), uniformImage(10, 10, color.NRGBA{G: 255, A: 255})},
+		Delays: []int{10, 10, 10},
+	}
+	config := SingleConfig{GeneralConfig: GeneralConfig{OpacityAlpha: 1, WatermarkWidthPercent: 20, Detection: &DetectionConfig{Detector: detector}}}
+
+	if _, err := ApplySingleWithAnimatedWatermark(StillAnimation(uniformImage(100, 100, color.NRGBA{A: 255})), watermark, config); err != nil {
+		t.Fatalf("ApplySingleWithAnimatedWatermark: %v", err)
+	}
+	if got := calls.Load(); got != 1 {
+		t.Errorf("detector called %d times, want once", got)
+	}
+}
@@ -0,0 +1,119 @@
+package imagewatermark
+
+import (
+	"fmt"
+	"image"
+	"math"
+)
+
+// visibilityNoiseFloor is added to the background luminance deviation so that perfectly flat backgrounds
+// still produce a finite visibility score.
+const visibilityNoiseFloor = 0.05
+
+// AutoOpacityConfig enables automatic opacity tuning to guarantee a minimum legibility of the watermark.
+//
+// The visibility score of a watermark drawn with opacity a is:
+//
+//	score = a * meanDifference / (backgroundDeviation + 0.05)
+//
+// where meanDifference is the mean absolute difference between the relative luminance of the watermark and of
+// the pixels under it, and backgroundDeviation is the standard deviation of the background luminance (how busy
+// it is). The opacity that reaches Target is chosen and clamped to [Min, Max]: flat, high-contrast backgrounds
+// get a subtle watermark, while busy or low-contrast ones get a stronger one. When enabled, OpacityAlpha is ignored.
+//
+// Fields:
+//   - Min: Minimum opacity (0.0 to 1.0].
+//   - Max: Maximum opacity (Min to 1.0].
+//   - Target: Visibility score to reach. Typical values range from 1 (subtle) to 4 (strong).
+type AutoOpacityConfig struct {
+	Min    float64
+	Max    float64
+	Target float64
+}
+
+// validate checks if the AutoOpacityConfig defines a valid opacity range and a positive target.
+//
+// Returns:
+//   - An error describing the first invalid value found, or nil if the configuration is valid.
+func (c AutoOpacityConfig) validate() error {
+	if c.Min <= 0 || c.Min > 1 {
+		return fmt.Errorf("min opacity must be greater than 0 and less than or equal to 1: %f", c.Min)
+	}
+
+	if c.Max < c.Min || c.Max > 1 {
+		return fmt.Errorf("max opacity must be between min opacity and 1: %f", c.Max)
+	}
+
+	if c.Target <= 0 {
+		return fmt.Errorf("visibility target must be positive: %f", c.Target)
+	}
+
+	return nil
+}
+
+// opacity measures the background under every watermark position and returns the opacity that reaches the target
+// visibility score, clamped to [Min, Max].
+//
+// Parameters:
+//   - canvas: The RGBA image the watermarks will be drawn onto.
+//   - candidates: The fitted (fully opaque) watermark candidates.
+//   - positions: A slice of image.Point objects indicating where each watermark will be placed.
+//   - variants: The index of the candidate drawn at each position.
+//
+// Returns:
+//   - The chosen opacity. Max is returned when the watermark does not cover any visible pixel or has no contrast.
+func (c AutoOpacityConfig) opacity(canvas *image.RGBA, candidates []*image.NRGBA, positions []image.Point, variants []int) float64 {
+	var weight, diffSum, bgSum, bgSquareSum float64
+
+	footprint := candidates[0].Bounds()
+
+	for i, pos := range positions {
+		wm := candidates[variants[i]]
+		pos = variantPosition(footprint, wm.Bounds(), pos)
+		dr := image.Rectangle{Min: pos, Max: pos.Add(wm.Bounds().Size())}.Intersect(canvas.Bounds())
+
+		for y := dr.Min.Y; y < dr.Max.Y; y++ {
+			srcRow := wm.Pix[(y-pos.Y)*wm.Stride:]
+			dstRow := canvas.Pix[canvas.PixOffset(dr.Min.X, y):]
+
+			for x := dr.Min.X; x < dr.Max.X; x++ {
+				si := (x - pos.X) * 4
+				di := (x - dr.Min.X) * 4
+
+				wa, ca := srcRow[si+3], dstRow[di+3]
+				if wa == 0 || ca == 0 {
+					continue
+				}
+
+				background := relativeLuminance(
+					uint8(uint16(dstRow[di])*255/uint16(ca)),
+					uint8(uint16(dstRow[di+1])*255/uint16(ca)),
+					uint8(uint16(dstRow[di+2])*255/uint16(ca)),
+				)
+				foreground := relativeLuminance(srcRow[si], srcRow[si+1], srcRow[si+2])
+
+				w := float64(wa) * float64(ca)
+				weight += w
+				diffSum += math.Abs(foreground-background) * w
+				bgSum += background * w
+				bgSquareSum += background * background * w
+			}
+		}
+	}
+
+	if weight == 0 {
+		return c.Max
+	}
+
+	difference := diffSum / weight
+	mean := bgSum / weight
+	deviation := math.Sqrt(math.Max(bgSquareSum/weight-mean*mean, 0))
+
+	if difference == 0 {
+		return c.Max
+	}
+
+	opacity := c.Target * (deviation + visibilityNoiseFloor) / difference
+
+	return math.Min(math.Max(opacity, c.Min), c.Max)
+}
@@ -0,0 +1,98 @@
+package imagewatermark
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"testing"
+)
+
+// checkerboard returns an image alternating black and white pixels.
+func checkerboard(width, height int) *image.NRGBA {
+	img := uniformImage(width, height, color.NRGBA{A: 255})
+	for y := 0; y < height; y++ {
+		for x := (y % 2); x < width; x += 2 {
+			img.SetNRGBA(x, y, color.NRGBA{R: 255, G: 255, B: 255, A: 255})
+		}
+	}
+	return img
+}
+
+func TestAutoOpacity(t *testing.T) {
+	white := color.NRGBA{R: 255, G: 255, B: 255, A: 255}
+	black := color.NRGBA{A: 255}
+	gray := color.NRGBA{R: 128, G: 128, B: 128, A: 255}
+
+	tests := []struct {
+		name      string
+		input     image.Image
+		watermark color.NRGBA
+		auto      AutoOpacityConfig
+		position  image.Point
+		want      float64
+	}{
+		{"flat high contrast", uniformImage(100, 100, black), white, AutoOpacityConfig{Min: 0.1, Max: 0.9, Target: 4}, image.Pt(40, 40), 0.2},
+		{"higher target", uniformImage(100, 100, black), white, AutoOpacityConfig{Min: 0.1, Max: 0.9, Target: 10}, image.Pt(40, 40), 0.5},
+		{"clamped to min", uniformImage(100, 100, black), white, AutoOpacityConfig{Min: 0.1, Max: 0.9, Target: 1}, image.Pt(40, 40), 0.1},
+		{"clamped to max", uniformImage(100, 100, black), white, AutoOpacityConfig{Min: 0.1, Max: 0.9, Target: 30}, image.Pt(40, 40), 0.9},
+		{"busy background", checkerboard(100, 100), white, AutoOpacityConfig{Min: 0.1, Max: 1, Target: 0.5}, image.Pt(40, 40), 0.55},
+		{"no contrast", uniformImage(100, 100, gray), gray, AutoOpacityConfig{Min: 0.1, Max: 0.7, Target: 2}, image.Pt(40, 40), 0.7},
+		{"outside the image", uniformImage(100, 100, black), white, AutoOpacityConfig{Min: 0.1, Max: 0.8, Target: 2}, image.Pt(200, 200), 0.8},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			placer := PlacerFunc(func(image.Image, image.Rectangle) []image.Point { return []image.Point{tt.position} })
+			config := GeneralConfig{WatermarkWidthPercent: 20, AutoOpacity: &tt.auto}
+
+			_, report, err := ApplyWithReport(tt.input, uniformImage(20, 20, tt.watermark), config, placer)
+			if err != nil {
+				t.Fatalf("ApplyWithReport: %v", err)
+			}
+			if math.Abs(report.Opacity-tt.want) > 1e-6 {
+				t.Errorf("report opacity = %v, want %v", report.Opacity, tt.want)
+			}
+		})
+	}
+}
+
+func TestAutoOpacityApplied(t *testing.T) {
+	black := color.NRGBA{A: 255}
+	white := color.NRGBA{R: 255, G: 255, B: 255, A: 255}
+
+	config := SingleConfig{
+		GeneralConfig: GeneralConfig{WatermarkWidthPercent: 20, AutoOpacity: &AutoOpacityConfig{Min: 0.1, Max: 0.9, Target: 10}},
+		Placement:     PlacementAbsolute,
+		Position:      image.Pt(40, 40),
+	}
+	result, err := ApplySingle(uniformImage(100, 100, black), uniformImage(20, 20, white), config)
+	if err != nil {
+		t.Fatalf("ApplySingle: %v", err)
+	}
+
+	if got := nrgbaAt(result, 50, 50); got.R < 126 || got.R > 129 {
+		t.Errorf("pixel under the watermark = %v, want about half white", got)
+	}
+}
+
+func TestAutoOpacityConfigValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  AutoOpacityConfig
+		wantErr string
+	}{
+		{"valid", AutoOpacityConfig{Min: 0.2, Max: 0.8, Target: 2}, ""},
+		{"fixed opacity", AutoOpacityConfig{Min: 1, Max: 1, Target: 1}, ""},
+		{"zero min", AutoOpacityConfig{Max: 0.8, Target: 2}, "min opacity"},
+		{"min above 1", AutoOpacityConfig{Min: 1.2, Max: 1, Target: 2}, "min opacity"},
+		{"max below min", AutoOpacityConfig{Min: 0.5, Max: 0.4, Target: 2}, "max opacity"},
+		{"max above 1", AutoOpacityConfig{Min: 0.5, Max: 1.1, Target: 2}, "max opacity"},
+		{"zero target", AutoOpacityConfig{Min: 0.2, Max: 0.8}, "visibility target"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checkValidateError(t, tt.config.validate(), tt.wantErr)
+		})
+	}
+}
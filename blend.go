@@ -0,0 +1,194 @@
+package imagewatermark
+
+import (
+	"fmt"
+	"image"
+	"math"
+)
+
+// BlendMode defines how the watermark colors are combined with the underlying image colors.
+//
+// Supported values:
+//   - BlendNormal: Standard "Over" compositing (default).
+//   - BlendMultiply: Multiplies the colors, darkening the result.
+//   - BlendScreen: Inverse of multiply, lightening the result.
+//   - BlendOverlay: Multiplies dark areas and screens light areas of the image.
+//   - BlendDarken: Keeps the darker of both colors.
+//   - BlendLighten: Keeps the lighter of both colors.
+type BlendMode int
+
+const (
+	BlendNormal BlendMode = iota
+	BlendMultiply
+	BlendScreen
+	BlendOverlay
+	BlendDarken
+	BlendLighten
+)
+
+// validate checks if the BlendMode is one of the supported values.
+//
+// Returns:
+//   - An error if the blend mode is unknown, or nil if it is valid.
+func (m BlendMode) validate() error {
+	if m < BlendNormal || m > BlendLighten {
+		return fmt.Errorf("unknown blend mode: %d", m)
+	}
+
+	return nil
+}
+
+// blendChannel applies the separable blend function of the given mode to a single color channel.
+//
+// Both values are non-premultiplied and normalized to the [0, 1] range.
+//
+// Parameters:
+//   - mode: The blend mode to apply.
+//   - backdrop: The channel value of the underlying image.
+//   - source: The channel value of the watermark.
+//
+// Returns:
+//   - The blended channel value in the [0, 1] range.
+func blendChannel(mode BlendMode, backdrop, source float64) float64 {
+	switch mode {
+	case BlendMultiply:
+		return backdrop * source
+	case BlendScreen:
+		return backdrop + source - backdrop*source
+	case BlendOverlay:
+		if backdrop <= 0.5 {
+			return 2 * backdrop * source
+		}
+		return 1 - 2*(1-backdrop)*(1-source)
+	case BlendDarken:
+		return math.Min(backdrop, source)
+	case BlendLighten:
+		return math.Max(backdrop, source)
+	default:
+		return source
+	}
+}
+
+// drawWatermarkBlended draws the watermark image onto the canvas at a specific position using the given blend mode.
+//
+// BlendNormal is delegated to drawWatermarkAtPosition so the fast "image/draw" path is kept, or to
+// drawWatermarkLinear in linear light. Any other mode uses the W3C separable blending formula on the premultiplied canvas pixels:
+//
+//	co = cs*(1-ab) + cb*(1-as) + as*ab*B(Cb, Cs)
+//	ao = as + ab*(1-as)
+//
+// In linear light, the colors are converted from sRGB with lookup tables before blending and back afterwards.
+//
+// Parameters:
+//   - canvas: The RGBA image onto which the watermark will be drawn.
+//   - watermarkImg: The watermark image to be drawn.
+//   - pos: The image.Point representing the top-left corner where the watermark should be placed.
+//   - mode: The blend mode used to combine the watermark with the canvas.
+//   - linear: Whether to blend in linear light instead of on sRGB-encoded values.
+func drawWatermarkBlended(canvas *image.RGBA, watermarkImg image.Image, pos image.Point, mode BlendMode, linear bool) {
+	if mode == BlendNormal {
+		if linear {
+			drawWatermarkLinear(canvas, watermarkImg, pos)
+		} else {
+			drawWatermarkAtPosition(canvas, watermarkImg, pos)
+		}
+		return
+	}
+
+	wm := toNRGBA(watermarkImg)
+
+	dr := image.Rectangle{Min: pos, Max: pos.Add(wm.Bounds().Size())}.Intersect(canvas.Bounds())
+	if dr.Empty() {
+		return
+	}
+
+	decode := func(v float64) float64 { return v }
+	encode := decode
+	if linear {
+		luts := linearLUTs()
+		decode = func(v float64) float64 { return luts.toLinear(uint8(math.Round(v * 255))) }
+		encode = func(v float64) float64 { return float64(luts.toSRGB(v)) / 255 }
+	}
+
+	for y := dr.Min.Y; y < dr.Max.Y; y++ {
+		srcRow := wm.Pix[(y-pos.Y)*wm.Stride:]
+		dstRow := canvas.Pix[canvas.PixOffset(dr.Min.X, y):]
+
+		for x := dr.Min.X; x < dr.Max.X; x++ {
+			si := (x - pos.X) * 4
+			di := (x - dr.Min.X) * 4
+
+			as := float64(srcRow[si+3]) / 255
+			if as == 0 {
+				continue
+			}
+
+			ab := float64(dstRow[di+3]) / 255
+			ao := as + ab*(1-as)
+
+			for c := 0; c < 3; c++ {
+				cs := decode(float64(srcRow[si+c]) / 255)
+
+				cb := 0.0
+				if ab > 0 {
+					cb = decode(math.Min(float64(dstRow[di+c])/255/ab, 1))
+				}
+
+				co := as*cs*(1-ab) + cb*ab*(1-as) + as*ab*blendChannel(mode, cb, cs)
+				dstRow[di+c] = uint8(math.Round(math.Min(encode(co/ao)*ao, ao) * 255))
+			}
+
+			dstRow[di+3] = uint8(math.Round(ao * 255))
+		}
+	}
+}
+
+// drawWatermarkLinear draws the watermark image onto the canvas with the "Over" operator in linear light.
+//
+// Colors are converted with the lookup tables and composited with integer arithmetic, so the cost stays
+// close to that of sRGB compositing.
+//
+// Parameters:
+//   - canvas: The RGBA image onto which the watermark will be drawn.
+//   - watermarkImg: The watermark image to be drawn.
+//   - pos: The image.Point representing the top-left corner where the watermark should be placed.
+func drawWatermarkLinear(canvas *image.RGBA, watermarkImg image.Image, pos image.Point) {
+	wm := toNRGBA(watermarkImg)
+
+	dr := image.Rectangle{Min: pos, Max: pos.Add(wm.Bounds().Size())}.Intersect(canvas.Bounds())
+	if dr.Empty() {
+		return
+	}
+
+	luts := linearLUTs()
+
+	for y := dr.Min.Y; y < dr.Max.Y; y++ {
+		srcRow := wm.Pix[(y-pos.Y)*wm.Stride:]
+		dstRow := canvas.Pix[canvas.PixOffset(dr.Min.X, y):]
+
+		for x := dr.Min.X; x < dr.Max.X; x++ {
+			s := srcRow[(x-pos.X)*4 : (x-pos.X)*4+4 : (x-pos.X)*4+4]
+			d := dstRow[(x-dr.Min.X)*4 : (x-dr.Min.X)*4+4 : (x-dr.Min.X)*4+4]
+
+			as, ab := uint64(s[3]), uint64(d[3])
+			if as == 0 {
+				continue
+			}
+
+			// Output alpha and premultiplied colors, scaled by 255*255.
+			ao := as*255 + ab*(255-as)
+
+			for c := 0; c < 3; c++ {
+				cb := uint64(0)
+				if ab > 0 {
+					cb = uint64(luts.decode[min(uint64(d[c])*255/ab, 255)])
+				}
+
+				co := uint64(luts.decode[s[c]])*as*255 + cb*ab*(255-as)
+				d[c] = uint8((uint64(luts.encode8(uint32(co/ao)))*ao + 255*255/2) / (255 * 255))
+			}
+
+			d[3] = uint8((ao + 127) / 255)
+		}
+	}
+}
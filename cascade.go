@@ -0,0 +1,266 @@
+package imagewatermark
+
+import (
+	_ "embed"
+	"encoding/binary"
+	"errors"
+	"image"
+	"math"
+	"sort"
+	"sync"
+
+	"github.com/disintegration/imaging"
+)
+
+// facefinderCascade is the frontal face cascade of pico (Markuš et al., "Object Detection with Pixel Intensity
+// Comparisons Organized in Decision Trees"), as redistributed by pigo under the MIT license (see cascades/LICENSE).
+//
+//go:embed cascades/facefinder
+var facefinderCascade []byte
+
+// cascadeDetectionSize is the length of the longer side of the downscaled image analyzed by CascadeDetector.
+const cascadeDetectionSize = 640
+
+// cascade is a parsed pixel intensity comparison cascade: a sequence of binary decision trees whose nodes
+// compare the intensity of two pixels at offsets relative to the detection window.
+//
+// Fields:
+//   - depth: The depth of every tree.
+//   - codes: Four signed offsets (row and column of each pixel, in 1/256 of the window size) per tree node.
+//   - predictions: The output of each leaf, 2^depth per tree.
+//   - thresholds: The rejection threshold applied to the accumulated output after each tree.
+type cascade struct {
+	depth       int
+	codes       []int8
+	predictions []float32
+	thresholds  []float32
+}
+
+// parseCascade parses a cascade in the binary format of pico.
+//
+// Parameters:
+//   - data: The cascade file.
+//
+// Returns:
+//   - A pointer to the cascade.
+//   - An error if the file is truncated.
+func parseCascade(data []byte) (*cascade, error) {
+	if len(data) < 16 {
+		return nil, errors.New("truncated cascade")
+	}
+
+	// The first 8 bytes hold the training bounding box, which is not needed for detection.
+	depth := int(binary.LittleEndian.Uint32(data[8:12]))
+	trees := int(binary.LittleEndian.Uint32(data[12:16]))
+	leaves := 1 << depth
+	if depth < 1 || depth > 16 || trees < 1 || 16+trees*(4*(leaves-1)+4*leaves+4) != len(data) {
+		return nil, errors.New("invalid cascade size")
+	}
+
+	c := &cascade{
+		depth:       depth,
+		codes:       make([]int8, 0, trees*4*leaves),
+		predictions: make([]float32, 0, trees*leaves),
+		thresholds:  make([]float32, 0, trees),
+	}
+
+	offset := 16
+	for t := 0; t < trees; t++ {
+		// Nodes are numbered from 1, so every tree starts with an unused node.
+		c.codes = append(c.codes, 0, 0, 0, 0)
+		for _, b := range data[offset : offset+4*(leaves-1)] {
+			c.codes = append(c.codes, int8(b))
+		}
+		offset += 4 * (leaves - 1)
+
+		for i := 0; i < leaves; i++ {
+			c.predictions = append(c.predictions, math.Float32frombits(binary.LittleEndian.Uint32(data[offset:])))
+			offset += 4
+		}
+		c.thresholds = append(c.thresholds, math.Float32frombits(binary.LittleEndian.Uint32(data[offset:])))
+		offset += 4
+	}
+
+	return c, nil
+}
+
+// faceCascade parses the bundled face cascade on first use.
+var faceCascade = sync.OnceValue(func() *cascade {
+	c, err := parseCascade(facefinderCascade)
+	if err != nil {
+		panic("imagewatermark: invalid bundled face cascade: " + err.Error())
+	}
+	return c
+})
+
+// classify runs the cascade on a square window.
+//
+// Parameters:
+//   - pixels: The grayscale image, row by row.
+//   - stride: The width of the image.
+//   - row: The row of the window center.
+//   - col: The column of the window center.
+//   - size: The side of the window, which must fit inside the image around its center.
+//
+// Returns:
+//   - The detection score, which is positive when every tree accepts the window.
+func (c *cascade) classify(pixels []uint8, stride, row, col, size int) float32 {
+	leaves := 1 << c.depth
+	row, col = row*256, col*256
+
+	var score float32
+	for t, threshold := range c.thresholds {
+		codes := c.codes[t*4*leaves:]
+		node := 1
+		for d := 0; d < c.depth; d++ {
+			p := codes[4*node:]
+			a := ((row+int(p[0])*size)>>8)*stride + (col+int(p[1])*size)>>8
+			b := ((row+int(p[2])*size)>>8)*stride + (col+int(p[3])*size)>>8
+			node = 2 * node
+			if pixels[a] <= pixels[b] {
+				node++
+			}
+		}
+
+		score += c.predictions[t*leaves+node-leaves]
+		if score <= threshold {
+			return -1
+		}
+	}
+
+	return score - c.thresholds[len(c.thresholds)-1]
+}
+
+// cascadeDetection is a window accepted by the cascade.
+type cascadeDetection struct {
+	row, col, size int
+	score          float32
+}
+
+// CascadeDetector is a pure-Go frontal face detector using the pixel intensity comparison cascade of pico,
+// bundled with the package.
+//
+// The image is converted to grayscale (so grayscale portraits work too) and downscaled so its longer side is
+// at most 640 pixels. Square windows of increasing size are slid over it, every window accepted by the cascade
+// is recorded, and overlapping windows are merged into one detection whose score is the sum of their scores.
+// Faces turned more than about 30 degrees away from the camera or rotated in the image plane may be missed.
+//
+// Fields:
+//   - MinSizePercent: Minimum face size, as a percentage of the shorter side of the image (Default is 5).
+//   - MinScore: Minimum score of a merged detection; higher values report fewer false positives (Default is 5).
+type CascadeDetector struct {
+	MinSizePercent float64
+	MinScore       float64
+}
+
+// Detect returns the bounding boxes of the faces found in the image.
+func (d CascadeDetector) Detect(img image.Image) []image.Rectangle {
+	bounds := img.Bounds()
+	if bounds.Empty() {
+		return nil
+	}
+
+	small := img
+	if bounds.Dx() > cascadeDetectionSize || bounds.Dy() > cascadeDetectionSize {
+		small = imaging.Fit(img, cascadeDetectionSize, cascadeDetectionSize, imaging.Box)
+	}
+	src := toNRGBA(small)
+	width, height := src.Bounds().Dx(), src.Bounds().Dy()
+
+	pixels := make([]uint8, width*height)
+	for y := 0; y < height; y++ {
+		row := src.Pix[y*src.Stride:]
+		for x := 0; x < width; x++ {
+			pixels[y*width+x] = uint8(luma16(row[x*4:]) >> 8)
+		}
+	}
+
+	minSizePercent := d.MinSizePercent
+	if minSizePercent <= 0 {
+		minSizePercent = 5
+	}
+	minScore := d.MinScore
+	if minScore <= 0 {
+		minScore = 5
+	}
+
+	c := faceCascade()
+	var detections []cascadeDetection
+	// The cascade was trained on windows of about 20 pixels and above.
+	minSize := max(int(float64(min(width, height))*minSizePercent/100), 20)
+	maxSize := min(width, height) - 2
+	for size := minSize; size <= maxSize; size = max(size+2, size*11/10) {
+		step := max(size/10, 1)
+		half := size/2 + 1
+		for row := half; row <= height-half; row += step {
+			for col := half; col <= width-half; col += step {
+				if score := c.classify(pixels, width, row, col, size); score > 0 {
+					detections = append(detections, cascadeDetection{row: row, col: col, size: size, score: score})
+				}
+			}
+		}
+	}
+
+	scaleX := float64(bounds.Dx()) / float64(width)
+	scaleY := float64(bounds.Dy()) / float64(height)
+
+	var faces []image.Rectangle
+	for _, face := range clusterDetections(detections) {
+		if float64(face.score) < minScore {
+			continue
+		}
+
+		faces = append(faces, image.Rect(
+			bounds.Min.X+int(float64(face.col-face.size/2)*scaleX),
+			bounds.Min.Y+int(float64(face.row-face.size/2)*scaleY),
+			bounds.Min.X+int(float64(face.col+face.size/2)*scaleX+0.5),
+			bounds.Min.Y+int(float64(face.row+face.size/2)*scaleY+0.5),
+		).Intersect(bounds))
+	}
+
+	return faces
+}
+
+// clusterDetections merges overlapping windows, so each face is reported once.
+//
+// Windows are visited from the highest score, and every window whose intersection over union with the
+// visited one exceeds 0.2 joins its cluster. Each cluster is the average window, scored with the sum of
+// the scores of its windows.
+//
+// Parameters:
+//   - detections: The windows accepted by the cascade.
+//
+// Returns:
+//   - One merged detection per cluster.
+func clusterDetections(detections []cascadeDetection) []cascadeDetection {
+	sort.Slice(detections, func(i, j int) bool { return detections[i].score > detections[j].score })
+
+	overlap := func(a, b cascadeDetection) float64 {
+		ra := image.Rect(a.col-a.size/2, a.row-a.size/2, a.col+a.size/2, a.row+a.size/2)
+		rb := image.Rect(b.col-b.size/2, b.row-b.size/2, b.col+b.size/2, b.row+b.size/2)
+		intersection := ra.Intersect(rb)
+		shared := float64(intersection.Dx() * intersection.Dy())
+		return shared / (float64(ra.Dx()*ra.Dy()+rb.Dx()*rb.Dy()) - shared)
+	}
+
+	assigned := make([]bool, len(detections))
+	var clusters []cascadeDetection
+	for i, seed := range detections {
+		if assigned[i] {
+			continue
+		}
+
+		var row, col, size, count int
+		var score float32
+		for j, other := range detections {
+			if !assigned[j] && overlap(seed, other) > 0.2 {
+				assigned[j] = true
+				row, col, size, count = row+other.row, col+other.col, size+other.size, count+1
+				score += other.score
+			}
+		}
+		clusters = append(clusters, cascadeDetection{row: row / count, col: col / count, size: size / count, score: score})
+	}
+
+	return clusters
+}
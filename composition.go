@@ -0,0 +1,236 @@
+package imagewatermark
+
+import (
+	"errors"
+	"fmt"
+	"image"
+)
+
+// Layer describes a single watermark layer inside a Composition.
+//
+// Each layer carries its own watermark image and exactly one placement configuration:
+// either Single (one watermark aligned on the image) or Grid (a tiled pattern).
+//
+// Fields:
+//   - Watermark: The watermark image used by this layer.
+//   - Single: Configuration for single watermark placement. Mutually exclusive with Grid.
+//   - Grid: Configuration for grid watermark placement. Mutually exclusive with Single.
+//   - Placer: Optional custom Placer. When set, it replaces the positioning of Single or Grid,
+//     whose GeneralConfig is still used for the watermark appearance.
+//   - Blend: Blend mode used to combine this layer with the layers below it (Default is BlendNormal).
+type Layer struct {
+	Watermark image.Image
+	Single    *SingleConfig
+	Grid      *GridConfig
+	Placer    Placer
+	Blend     BlendMode
+}
+
+// validate checks if the Layer has a watermark, exactly one valid placement configuration and a known blend mode.
+//
+// Returns:
+//   - An error describing the first invalid value found, or nil if the layer is valid.
+func (l Layer) validate() error {
+	if l.Watermark == nil {
+		return errors.New("watermark image is required")
+	}
+
+	switch {
+	case l.Single != nil && l.Grid != nil:
+		return errors.New("only one of single or grid configuration can be set")
+	case l.Single != nil:
+		if err := l.Single.validate(); err != nil {
+			return fmt.Errorf("invalid single watermark configuration: %w", err)
+		}
+	case l.Grid != nil:
+		if err := l.Grid.validate(); err != nil {
+			return fmt.Errorf("invalid grid watermark configuration: %w", err)
+		}
+	default:
+		return errors.New("either single or grid configuration is required")
+	}
+
+	return l.Blend.validate()
+}
+
+// generalConfig returns the GeneralConfig of whichever placement configuration is set on the layer.
+func (l Layer) generalConfig() GeneralConfig {
+	if l.Single != nil {
+		return l.Single.GeneralConfig
+	}
+
+	return l.Grid.GeneralConfig
+}
+
+// placer returns the custom Placer of the layer, falling back to its Single or Grid configuration.
+func (l Layer) placer() Placer {
+	switch {
+	case l.Placer != nil:
+		return l.Placer
+	case l.Single != nil:
+		return *l.Single
+	default:
+		return *l.Grid
+	}
+}
+
+// Composition holds an ordered list of watermark layers rendered onto a single canvas.
+//
+// Layers are drawn in order, so the first layer ends up at the bottom and the last one on top.
+//
+// Fields:
+//   - Layers: The ordered list of layers to render.
+//   - MaxWorkers: Maximum number of concurrent workers for batch processing (Default is number of CPU cores).
+//     The MaxWorkers value of each layer configuration is ignored.
+//   - MaxInputPixels: Optional maximum number of pixels of each input image passed to BatchApplyComposition
+//     (see GeneralConfig.MaxInputPixels). The MaxInputPixels value of each layer configuration is ignored.
+//   - ForceRGBA: Always returns an *image.RGBA instead of an image in the pixel format of the input
+//     (see GeneralConfig.ForceRGBA). The ForceRGBA value of each layer configuration is ignored.
+type Composition struct {
+	Layers         []Layer
+	MaxWorkers     int
+	MaxInputPixels int
+	ForceRGBA      bool
+}
+
+// validate checks if the Composition has at least one layer and all of its layers are valid.
+//
+// Returns:
+//   - An error describing the first invalid layer found, or nil if the composition is valid.
+func (c Composition) validate() error {
+	if len(c.Layers) == 0 {
+		return errors.New("at least one layer is required")
+	}
+
+	if c.MaxWorkers < 0 {
+		return fmt.Errorf("max workers must be a non-negative integer: %d", c.MaxWorkers)
+	}
+
+	if c.MaxInputPixels < 0 {
+		return fmt.Errorf("max input pixels must be a non-negative integer: %d", c.MaxInputPixels)
+	}
+
+	for i, layer := range c.Layers {
+		if err := layer.validate(); err != nil {
+			return fmt.Errorf("layer %d: %w", i, err)
+		}
+	}
+
+	return nil
+}
+
+// ApplyComposition renders every layer of the composition onto the input image in a single pass.
+//
+// The function performs the following steps:
+//  1. Validates the Composition and all of its layers.
+//  2. Creates one RGBA copy of the input image (converted back to the input pixel format at the end).
+//  3. For each layer, preprocesses its watermark, computes its positions and draws it using the layer blend mode.
+//  4. Returns the final image with all layers applied.
+//
+// Parameters:
+//   - inputImg: The input image to which the layers will be applied.
+//   - composition: Composition struct containing the ordered layers.
+//
+// Returns:
+//   - An image.Image containing the final image with all layers applied.
+//   - An error if the composition is invalid.
+//
+// Example:
+//
+//	composition := Composition{
+//		Layers: []Layer{
+//			{Watermark: patternImg, Grid: &GridConfig{GeneralConfig: GeneralConfig{WatermarkWidthPercent: 10, OpacityAlpha: 0.1}}},
+//			{Watermark: logoImg, Single: &SingleConfig{GeneralConfig: GeneralConfig{WatermarkWidthPercent: 20, OpacityAlpha: 0.8},
+//				VerticalAlign: VerticalBottom, HorizontalAlign: HorizontalRight, Spacing: 20}},
+//		},
+//	}
+//	result, err := ApplyComposition(inputImg, composition)
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+func ApplyComposition(inputImg image.Image, composition Composition) (image.Image, error) {
+	if err := composition.validate(); err != nil {
+		return nil, fmt.Errorf("invalid composition: %w", err)
+	}
+
+	preparedWMs := prepareLayers(composition.Layers)
+
+	return renderComposition(inputImg, composition, preparedWMs), nil
+}
+
+// BatchApplyComposition renders the composition onto a batch of input images concurrently.
+//
+// Every layer watermark is preprocessed once, and only resized, rotated, decorated and faded per input image.
+//
+// Parameters:
+//   - inputImgs: A slice of input images to which the layers will be applied.
+//   - composition: Composition struct containing the ordered layers and concurrency settings.
+//
+// Returns:
+//   - A slice of image.Image objects containing the final images with all layers applied.
+//   - An error if the composition is invalid or an input image exceeds MaxInputPixels.
+func BatchApplyComposition(inputImgs []image.Image, composition Composition) ([]image.Image, error) {
+	if err := composition.validate(); err != nil {
+		return nil, fmt.Errorf("invalid composition: %w", err)
+	}
+
+	if err := checkInputPixels(inputImgs, composition.MaxInputPixels); err != nil {
+		return nil, err
+	}
+
+	preparedWMs := prepareLayers(composition.Layers)
+	results := make([]image.Image, len(inputImgs))
+
+	processBatch(len(inputImgs), composition.MaxWorkers, func(index int) {
+		results[index] = renderComposition(inputImgs[index], composition, preparedWMs)
+	})
+
+	return results, nil
+}
+
+// prepareLayers applies the image-independent preprocessing to the watermark of each layer.
+//
+// Parameters:
+//   - layers: The layers whose watermarks should be preprocessed.
+//
+// Returns:
+//   - A slice with the preprocessed watermark of each layer, in the same order.
+func prepareLayers(layers []Layer) []preparedWatermark {
+	preparedWMs := make([]preparedWatermark, len(layers))
+
+	for i, layer := range layers {
+		preparedWMs[i] = prepareWatermark(layer.Watermark, layer.generalConfig())
+	}
+
+	return preparedWMs
+}
+
+// renderComposition draws all layers onto a single canvas created from the input image.
+//
+// Parameters:
+//   - inputImg: The input image used as the base canvas and as reference for sizing and positioning.
+//   - composition: The composition whose layers are rendered, in drawing order.
+//   - preparedWMs: The preprocessed watermark of each layer, as returned by prepareLayers.
+//
+// Returns:
+//   - An image.Image containing the input image with all layers applied, in the pixel format of the input
+//     unless ForceRGBA is set.
+func renderComposition(inputImg image.Image, composition Composition, preparedWMs []preparedWatermark) image.Image {
+	canvas := generateBaseCanvas(inputImg)
+
+	var base *image.RGBA
+	if !composition.ForceRGBA && keepsInputFormat(inputImg) {
+		base = cloneRGBA(canvas)
+	}
+
+	for i, layer := range composition.Layers {
+		drawLayer(canvas, inputImg, preparedWMs[i], layer.generalConfig(), layer.placer(), layer.Blend)
+	}
+
+	var result image.Image = canvas
+	if base != nil {
+		result = matchInputFormat(canvas, base, inputImg)
+	}
+
+	return result
+}
@@ -0,0 +1,184 @@
+package imagewatermark
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"strings"
+	"testing"
+)
+
+// uniformImage returns an image of the given size filled with a single color.
+func uniformImage(width, height int, c color.NRGBA) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for i := 0; i < len(img.Pix); i += 4 {
+		img.Pix[i], img.Pix[i+1], img.Pix[i+2], img.Pix[i+3] = c.R, c.G, c.B, c.A
+	}
+	return img
+}
+
+// checkValidateError fails the test unless err matches wantErr: nil when wantErr is empty, or an error
+// containing wantErr otherwise.
+func checkValidateError(t *testing.T, err error, wantErr string) {
+	t.Helper()
+
+	if wantErr == "" {
+		if err != nil {
+			t.Fatalf("validate() = %v, want nil", err)
+		}
+		return
+	}
+	if err == nil || !strings.Contains(err.Error(), wantErr) {
+		t.Fatalf("validate() = %v, want error containing %q", err, wantErr)
+	}
+}
+
+// nrgbaAt returns the non-premultiplied 8-bit color of a pixel of any image.
+func nrgbaAt(img image.Image, x, y int) color.NRGBA {
+	return color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+}
+
+// cornerLayer returns an opaque single layer in the top left corner, spacing pixels away from both edges.
+func cornerLayer(watermark image.Image, widthPercent float64, spacing int) Layer {
+	return Layer{
+		Watermark: watermark,
+		Single: &SingleConfig{
+			GeneralConfig: GeneralConfig{OpacityAlpha: 1, WatermarkWidthPercent: widthPercent},
+			Spacing:       spacing,
+		},
+	}
+}
+
+func TestApplyCompositionLayers(t *testing.T) {
+	white := color.NRGBA{R: 255, G: 255, B: 255, A: 255}
+	red := color.NRGBA{R: 255, A: 255}
+	blue := color.NRGBA{B: 255, A: 255}
+
+	redWM := uniformImage(20, 20, red)
+	blueWM := uniformImage(20, 20, blue)
+
+	tests := []struct {
+		name   string
+		layers []Layer
+		want   map[image.Point]color.NRGBA
+	}{
+		{
+			name:   "last layer on top",
+			layers: []Layer{cornerLayer(redWM, 20, 10), cornerLayer(blueWM, 20, 20)},
+			want: map[image.Point]color.NRGBA{
+				{15, 15}: red,
+				{25, 25}: blue,
+				{35, 35}: blue,
+				{5, 5}:   white,
+			},
+		},
+		{
+			name:   "first layer at the bottom",
+			layers: []Layer{cornerLayer(blueWM, 20, 20), cornerLayer(redWM, 20, 10)},
+			want: map[image.Point]color.NRGBA{
+				{15, 15}: red,
+				{25, 25}: red,
+				{35, 35}: blue,
+			},
+		},
+		{
+			name: "independent placement",
+			layers: []Layer{
+				cornerLayer(redWM, 10, 0),
+				{
+					Watermark: blueWM,
+					Single: &SingleConfig{
+						GeneralConfig:   GeneralConfig{OpacityAlpha: 1, WatermarkWidthPercent: 10},
+						VerticalAlign:   VerticalBottom,
+						HorizontalAlign: HorizontalRight,
+					},
+				},
+			},
+			want: map[image.Point]color.NRGBA{
+				{5, 5}:   red,
+				{95, 95}: blue,
+				{50, 50}: white,
+			},
+		},
+		{
+			name: "grid and single",
+			layers: []Layer{
+				{Watermark: redWM, Grid: &GridConfig{GeneralConfig: GeneralConfig{OpacityAlpha: 1, WatermarkWidthPercent: 10}}},
+				cornerLayer(blueWM, 20, 40),
+			},
+			want: map[image.Point]color.NRGBA{
+				{45, 45}: blue,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := ApplyComposition(uniformImage(100, 100, white), Composition{Layers: tt.layers})
+			if err != nil {
+				t.Fatalf("ApplyComposition: %v", err)
+			}
+
+			for p, want := range tt.want {
+				if got := nrgbaAt(result, p.X, p.Y); got != want {
+					t.Errorf("pixel %v = %v, want %v", p, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestCompositionValidate(t *testing.T) {
+	wm := uniformImage(10, 10, color.NRGBA{A: 255})
+	single := &SingleConfig{GeneralConfig: GeneralConfig{OpacityAlpha: 0.5, WatermarkWidthPercent: 10}}
+	grid := &GridConfig{GeneralConfig: GeneralConfig{OpacityAlpha: 0.5, WatermarkWidthPercent: 10}}
+
+	tests := []struct {
+		name        string
+		composition Composition
+		wantErr     string
+	}{
+		{"valid", Composition{Layers: []Layer{{Watermark: wm, Single: single}, {Watermark: wm, Grid: grid}}}, ""},
+		{"no layers", Composition{}, "at least one layer"},
+		{"missing watermark", Composition{Layers: []Layer{{Single: single}}}, "layer 0: watermark image is required"},
+		{"single and grid", Composition{Layers: []Layer{{Watermark: wm, Single: single, Grid: grid}}}, "only one of"},
+		{"no placement", Composition{Layers: []Layer{{Watermark: wm, Single: single}, {Watermark: wm}}}, "layer 1: either single or grid"},
+		{"invalid layer config", Composition{Layers: []Layer{{Watermark: wm, Single: &SingleConfig{}}}}, "invalid single watermark configuration"},
+		{"negative workers", Composition{Layers: []Layer{{Watermark: wm, Single: single}}, MaxWorkers: -1}, "max workers"},
+		{"negative input pixels", Composition{Layers: []Layer{{Watermark: wm, Single: single}}, MaxInputPixels: -1}, "max input pixels"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checkValidateError(t, tt.composition.validate(), tt.wantErr)
+		})
+	}
+}
+
+func TestBatchApplyComposition(t *testing.T) {
+	white := color.NRGBA{R: 255, G: 255, B: 255, A: 255}
+	red := color.NRGBA{R: 255, A: 255}
+	composition := Composition{Layers: []Layer{cornerLayer(uniformImage(10, 10, red), 10, 0)}}
+
+	inputs := []image.Image{uniformImage(100, 100, white), uniformImage(200, 50, white), uniformImage(50, 300, white)}
+	results, err := BatchApplyComposition(inputs, composition)
+	if err != nil {
+		t.Fatalf("BatchApplyComposition: %v", err)
+	}
+
+	for i, result := range results {
+		if result.Bounds() != inputs[i].Bounds() {
+			t.Errorf("result %d bounds = %v, want %v", i, result.Bounds(), inputs[i].Bounds())
+		}
+		if got := nrgbaAt(result, 1, 1); got != red {
+			t.Errorf("result %d pixel (1, 1) = %v, want %v", i, got, red)
+		}
+	}
+
+	composition.MaxInputPixels = 10_000
+	_, err = BatchApplyComposition(inputs, composition)
+	var limitErr *LimitError
+	if !errors.Is(err, ErrTooManyPixels) || !errors.As(err, &limitErr) || limitErr.Value != 50*300 {
+		t.Fatalf("BatchApplyComposition with MaxInputPixels = %v, want a LimitError for 15000 pixels", err)
+	}
+}
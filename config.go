@@ -2,6 +2,8 @@ package imagewatermark
 
 import (
 	"fmt"
+	"image"
+	"math"
 
 	"github.com/disintegration/imaging"
 )
@@ -24,6 +26,27 @@ type VerticalAlign int
 //   - HorizontalRandom: Places the watermark at a random horizontal position.
 type HorizontalAlign int
 
+// SizeMode defines which reference the watermark size is computed from.
+//
+// Supported values:
+//   - SizeWidth: Watermark width is a percentage of the input image width (default).
+//   - SizeHeight: Watermark height is a percentage of the input image height.
+//   - SizeShorterSide: Watermark width is a percentage of the shorter side of the input image.
+//   - SizeLongerSide: Watermark width is a percentage of the longer side of the input image.
+//   - SizeArea: Watermark area is a percentage of the input image area.
+//   - SizeDiagonal: Watermark diagonal is a percentage of the input image diagonal.
+//   - SizeFit: Watermark fits inside a box of WatermarkWidthPercent x WatermarkHeightPercent of the input image,
+//     respecting both watermark dimensions.
+type SizeMode int
+
+// PlacementMode defines how the position of a single watermark is computed.
+//
+// Supported values:
+//   - PlacementAlign: Uses VerticalAlign, HorizontalAlign and margins (default).
+//   - PlacementAnchor: Uses the normalized coordinates of Anchor.
+//   - PlacementAbsolute: Uses the exact pixel coordinates of Position.
+type PlacementMode int
+
 const (
 	VerticalTop VerticalAlign = iota
 	VerticalMiddle
@@ -38,37 +61,171 @@ const (
 	HorizontalRandom
 )
 
+const (
+	SizeWidth SizeMode = iota
+	SizeHeight
+	SizeShorterSide
+	SizeLongerSide
+	SizeArea
+	SizeDiagonal
+	SizeFit
+)
+
+const (
+	PlacementAlign PlacementMode = iota
+	PlacementAnchor
+	PlacementAbsolute
+)
+
+// Anchor defines a placement by matching a reference point of the watermark to a reference point of the input image.
+//
+// All coordinates are normalized to the [0, 1] range, where (0, 0) is the top-left corner and (1, 1)
+// the bottom-right corner. For example, ImageX 0.3, ImageY 0.8 with WatermarkX 0.5, WatermarkY 0.5
+// centers the watermark 30% from the left and 80% down the input image.
+//
+// Fields:
+//   - ImageX: Horizontal reference point on the input image.
+//   - ImageY: Vertical reference point on the input image.
+//   - WatermarkX: Horizontal reference point on the watermark.
+//   - WatermarkY: Vertical reference point on the watermark.
+type Anchor struct {
+	ImageX     float64
+	ImageY     float64
+	WatermarkX float64
+	WatermarkY float64
+}
+
+// validate checks if all Anchor coordinates are within the [0, 1] range.
+//
+// Returns:
+//   - An error describing the first invalid coordinate found, or nil if all coordinates are valid.
+func (a Anchor) validate() error {
+	coordinates := []struct {
+		name  string
+		value float64
+	}{
+		{"image x", a.ImageX},
+		{"image y", a.ImageY},
+		{"watermark x", a.WatermarkX},
+		{"watermark y", a.WatermarkY},
+	}
+
+	for _, c := range coordinates {
+		if c.value < 0 || c.value > 1 {
+			return fmt.Errorf("anchor %s must be between 0 and 1: %f", c.name, c.value)
+		}
+	}
+
+	return nil
+}
+
+// position calculates the top-left corner of the watermark for the anchor.
+//
+// Parameters:
+//   - watermarkBounds: The bounds of the watermark image.
+//   - inputBounds: The bounds of the input image.
+//
+// Returns:
+//   - An image.Point containing the calculated X and Y coordinates for the watermark placement.
+func (a Anchor) position(watermarkBounds, inputBounds image.Rectangle) image.Point {
+	x := a.ImageX*float64(inputBounds.Dx()) - a.WatermarkX*float64(watermarkBounds.Dx())
+	y := a.ImageY*float64(inputBounds.Dy()) - a.WatermarkY*float64(watermarkBounds.Dy())
+
+	return image.Point{X: int(math.Round(x)), Y: int(math.Round(y))}
+}
+
 // GeneralConfig holds common configuration settings for all watermarking operations.
 //
 // This struct contains the paths to the input image and watermark, along with general
 // appearance settings that apply to any watermarking method (single or grid).
 //
 // Fields:
-//   - OpacityAlpha: Transparency level of the watermark (0.0 to 1.0, where 1.0 is fully opaque).
-//   - WatermarkWidthPercent: Desired watermark width as a percentage of the input image width (0-100).
+//   - OpacityAlpha: Transparency level of the watermark (0.0 to 1.0, where 1.0 is fully opaque). Ignored when AutoOpacity is set.
+//   - WatermarkWidthPercent: Desired watermark size as a percentage of the reference selected by SizeMode (0-100).
+//     With the default SizeMode this is the percentage of the input image width.
+//   - WatermarkHeightPercent: Height of the fitting box as a percentage of the input image height, used only by SizeFit
+//     (0-100, Default is WatermarkWidthPercent).
+//   - SizeMode: Reference used to compute the watermark size (Default is SizeWidth).
+//   - MinPixels: Optional minimum length in pixels of the longer side of the resized watermark (0 disables it).
+//   - MaxPixels: Optional maximum length in pixels of the longer side of the resized watermark (0 disables it).
 //   - RotationDegrees: Rotation angle for the watermark in degrees (0-360).
 //   - ResampleFilter: Resampling filter to use when resizing the watermark. (Default is CatmullRom)
 //   - MaxWorkers: Maximum number of concurrent workers for batch processing (Default is number of CPU cores).
+//   - MaxInputPixels: Optional maximum number of pixels (width × height) of each input image passed to the batch
+//     functions, which reject the whole batch with a LimitError wrapping ErrTooManyPixels, and of each input
+//     frame passed to the animation functions (0 disables it).
+//   - DPI: Resolution of the input image, used to convert millimeters and inches to pixels (Default is DefaultDPI).
+//   - Effects: Optional drop shadow, outline and glow rendered around the watermark.
+//   - Adaptive: Optional automatic selection, per position, of the watermark variant with the best contrast.
+//   - AutoOpacity: Optional automatic opacity tuning, per image, to reach a target visibility score.
+//   - Tint: Optional solid, duotone or gradient recoloring of the watermark, preserving its alpha.
+//   - OpacityMask: Optional image or gradient that modulates the watermark alpha per pixel.
+//   - Vignette: Optional fading of the watermarks toward the center of the input image.
+//   - Exclusions: Optional areas of the input image where watermarks must never be drawn.
+//   - Detection: Optional detection of faces (or other objects) that watermarks must avoid or fade over.
+//   - Underlay: Optional document mode that draws the watermark only on background (paper) pixels, behind the ink.
+//   - ClipToInputAlpha: Masks the watermark by the alpha channel of the input image, so transparent areas
+//     (for example around a product cut-out) stay transparent.
+//   - LinearLight: Resizes and composites the watermark in linear light instead of on sRGB-encoded values,
+//     avoiding dark fringes around antialiased edges and muddy semi-transparent colors.
+//   - ForceRGBA: Always returns an *image.RGBA. By default, Gray, Gray16, NRGBA, RGBA64, NRGBA64, YCbCr and CMYK
+//     inputs return an image of the same type, keeping the exact values of the pixels outside the watermark.
+//     The watermark itself is composited at 8 bits, so on 16-bit inputs its change is added in 8-bit steps.
 type GeneralConfig struct {
-	OpacityAlpha          float64
-	WatermarkWidthPercent float64
-	RotationDegrees       float64
-	ResampleFilter        imaging.ResampleFilter
-	MaxWorkers            int
+	OpacityAlpha           float64
+	WatermarkWidthPercent  float64
+	WatermarkHeightPercent float64
+	SizeMode               SizeMode
+	MinPixels              int
+	MaxPixels              int
+	RotationDegrees        float64
+	ResampleFilter         imaging.ResampleFilter
+	MaxWorkers             int
+	MaxInputPixels         int
+	DPI                    float64
+	Effects                Effects
+	Adaptive               *AdaptiveConfig
+	AutoOpacity            *AutoOpacityConfig
+	Tint                   *Tint
+	OpacityMask            *OpacityMask
+	Vignette               *VignetteConfig
+	Exclusions             *Exclusions
+	Detection              *DetectionConfig
+	Underlay               *UnderlayConfig
+	ClipToInputAlpha       bool
+	LinearLight            bool
+	ForceRGBA              bool
 }
 
 // validate checks if the GeneralConfig has valid values for all fields.
 //
 // It performs the following validations:
-//   - OpacityAlpha must be greater than 0 and less than or equal to 1.
+//   - OpacityAlpha must be greater than 0 and less than or equal to 1, unless AutoOpacity is set.
 //   - WatermarkWidthPercent must be greater than 0 and at most 100.
+//   - WatermarkHeightPercent must be between 0 and 100.
+//   - SizeMode must be one of the supported values.
+//   - MinPixels and MaxPixels must be non-negative, and MinPixels must not exceed MaxPixels when both are set.
 //   - RotationDegrees must be between 0 and less than 360.
-//   - MaxWorkers must be a non-negative integer.
+//   - MaxWorkers and MaxInputPixels must be non-negative integers.
+//   - DPI must be non-negative.
+//   - Effects must have valid values (see Effects.validate).
+//   - Adaptive, when set, must have at least one variant or tint color.
+//   - AutoOpacity, when set, must have a valid opacity range and a positive target.
+//   - Tint, when set, must have a supported mode and the number of colors it requires.
+//   - OpacityMask, when set, must have a supported shape and valid factors.
+//   - Vignette, when set, must satisfy 0 <= Inner < Outer <= 1.
+//   - Exclusions, when set, must have a supported mode and at least one non-empty rectangle or a mask.
+//   - Detection, when set, must have a supported action and valid padding and fade values.
+//   - Underlay, when set, must have a supported mode and values within range.
 //
 // Returns:
 //   - An error describing the first invalid value found, or nil if all fields are valid.
 func (c GeneralConfig) validate() error {
-	if c.OpacityAlpha <= 0 || c.OpacityAlpha > 1 {
+	if c.AutoOpacity != nil {
+		if err := c.AutoOpacity.validate(); err != nil {
+			return fmt.Errorf("invalid auto opacity configuration: %w", err)
+		}
+	} else if c.OpacityAlpha <= 0 || c.OpacityAlpha > 1 {
 		return fmt.Errorf("opacity must be greater than 0 and less than or equal to 1: %f", c.OpacityAlpha)
 	}
 
@@ -76,6 +233,22 @@ func (c GeneralConfig) validate() error {
 		return fmt.Errorf("watermark width percent must be greater than 0 and at most 100: %f", c.WatermarkWidthPercent)
 	}
 
+	if c.WatermarkHeightPercent < 0 || c.WatermarkHeightPercent > 100 {
+		return fmt.Errorf("watermark height percent must be between 0 and 100: %f", c.WatermarkHeightPercent)
+	}
+
+	if c.SizeMode < SizeWidth || c.SizeMode > SizeFit {
+		return fmt.Errorf("unknown size mode: %d", c.SizeMode)
+	}
+
+	if c.MinPixels < 0 || c.MaxPixels < 0 {
+		return fmt.Errorf("min and max pixels must be non-negative integers: %d, %d", c.MinPixels, c.MaxPixels)
+	}
+
+	if c.MaxPixels > 0 && c.MinPixels > c.MaxPixels {
+		return fmt.Errorf("min pixels must not exceed max pixels: %d > %d", c.MinPixels, c.MaxPixels)
+	}
+
 	if c.RotationDegrees < 0 || c.RotationDegrees > 360 {
 		return fmt.Errorf("rotation degrees must be between 0 and 360 (360 result in no rotation): %f", c.RotationDegrees)
 	}
@@ -84,30 +257,98 @@ func (c GeneralConfig) validate() error {
 		return fmt.Errorf("max workers must be a non-negative integer: %d", c.MaxWorkers)
 	}
 
+	if c.MaxInputPixels < 0 {
+		return fmt.Errorf("max input pixels must be a non-negative integer: %d", c.MaxInputPixels)
+	}
+
+	if c.DPI < 0 {
+		return fmt.Errorf("dpi must be non-negative: %f", c.DPI)
+	}
+
+	if err := c.Effects.validate(); err != nil {
+		return fmt.Errorf("invalid effects: %w", err)
+	}
+
+	if c.Adaptive != nil {
+		if err := c.Adaptive.validate(); err != nil {
+			return fmt.Errorf("invalid adaptive configuration: %w", err)
+		}
+	}
+
+	if c.Tint != nil {
+		if err := c.Tint.validate(); err != nil {
+			return fmt.Errorf("invalid tint: %w", err)
+		}
+	}
+
+	if c.OpacityMask != nil {
+		if err := c.OpacityMask.validate(); err != nil {
+			return fmt.Errorf("invalid opacity mask: %w", err)
+		}
+	}
+
+	if c.Vignette != nil {
+		if err := c.Vignette.validate(); err != nil {
+			return fmt.Errorf("invalid vignette: %w", err)
+		}
+	}
+
+	if c.Exclusions != nil {
+		if err := c.Exclusions.validate(); err != nil {
+			return fmt.Errorf("invalid exclusions: %w", err)
+		}
+	}
+
+	if c.Detection != nil {
+		if err := c.Detection.validate(); err != nil {
+			return fmt.Errorf("invalid detection configuration: %w", err)
+		}
+	}
+
+	if c.Underlay != nil {
+		if err := c.Underlay.validate(); err != nil {
+			return fmt.Errorf("invalid underlay configuration: %w", err)
+		}
+	}
+
 	return nil
 }
 
 // SingleConfig holds all configuration settings for applying a single watermark to an image.
 //
 // This struct extends GeneralConfig with alignment and spacing options specific to
-// single watermark placement.
+// single watermark placement. Alignment fields are used by the default PlacementAlign mode;
+// PlacementAnchor and PlacementAbsolute use Anchor and Position instead.
 //
 // Fields:
 //   - GeneralConfig: Embedded struct containing common watermarking settings.
+//   - Placement: How the watermark position is computed (Default is PlacementAlign).
 //   - VerticalAlign: Vertical alignment of the watermark (top, middle, bottom, or random).
 //   - HorizontalAlign: Horizontal alignment of the watermark (left, middle, right, or random).
 //   - Spacing: Distance in pixels between the watermark and the aligned edge.
+//   - MarginX: Optional horizontal distance between the watermark and the aligned edge. Overrides Spacing horizontally.
+//   - MarginY: Optional vertical distance between the watermark and the aligned edge. Overrides Spacing vertically.
+//   - Anchor: Normalized image and watermark reference points, used by PlacementAnchor.
+//   - Position: Top-left corner of the watermark in pixels, used by PlacementAbsolute.
 type SingleConfig struct {
 	GeneralConfig
+	Placement       PlacementMode
 	VerticalAlign   VerticalAlign
 	HorizontalAlign HorizontalAlign
 	Spacing         int
+	MarginX         *Length
+	MarginY         *Length
+	Anchor          Anchor
+	Position        image.Point
 }
 
 // validate checks if the SingleConfig has valid values for all fields.
 //
 // It first validates the embedded GeneralConfig, then checks:
 //   - Spacing must be a non-negative integer.
+//   - MarginX and MarginY, when set, must be non-negative and use a supported unit.
+//   - Placement must be one of the supported values.
+//   - Anchor coordinates must be between 0 and 1 when Placement is PlacementAnchor.
 //
 // Returns:
 //   - An error describing the first invalid value found, or nil if all fields are valid.
@@ -120,9 +361,41 @@ func (c SingleConfig) validate() error {
 		return fmt.Errorf("spacing must be a non-negative integer: %d", c.Spacing)
 	}
 
+	if err := validateLength("margin x", c.MarginX, false); err != nil {
+		return err
+	}
+
+	if err := validateLength("margin y", c.MarginY, false); err != nil {
+		return err
+	}
+
+	if c.Placement < PlacementAlign || c.Placement > PlacementAbsolute {
+		return fmt.Errorf("unknown placement mode: %d", c.Placement)
+	}
+
+	if c.Placement == PlacementAnchor {
+		if err := c.Anchor.validate(); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
+// margins resolves the horizontal and vertical distances to the aligned edges in pixels.
+//
+// Parameters:
+//   - inputBounds: The bounds of the input image, used by percentage margins.
+//
+// Returns:
+//   - Two ints representing the horizontal and vertical margins in pixels.
+func (c SingleConfig) margins(inputBounds image.Rectangle) (int, int) {
+	marginX := resolveLength(c.MarginX, c.Spacing, inputBounds.Dx(), c.DPI)
+	marginY := resolveLength(c.MarginY, c.Spacing, inputBounds.Dy(), c.DPI)
+
+	return marginX, marginY
+}
+
 // GridConfig holds all configuration settings for applying a grid pattern of watermarks to an image.
 //
 // This struct extends GeneralConfig with grid-specific options for spacing and positioning.
@@ -135,25 +408,69 @@ func (c SingleConfig) validate() error {
 //   - GridSpacingY: Vertical spacing between watermarks in the grid (in pixels). Can be negative for overlapping.
 //   - OffsetX: Initial horizontal offset for the grid starting position (in pixels).
 //   - OffsetY: Initial vertical offset for the grid starting position (in pixels).
+//   - GridSpacingXLength: Optional unit-aware value that overrides GridSpacingX.
+//   - GridSpacingYLength: Optional unit-aware value that overrides GridSpacingY.
+//   - OffsetXLength: Optional unit-aware value that overrides OffsetX.
+//   - OffsetYLength: Optional unit-aware value that overrides OffsetY.
 type GridConfig struct {
 	GeneralConfig
-	GridSpacingX int
-	GridSpacingY int
-	OffsetX      int
-	OffsetY      int
+	GridSpacingX       int
+	GridSpacingY       int
+	OffsetX            int
+	OffsetY            int
+	GridSpacingXLength *Length
+	GridSpacingYLength *Length
+	OffsetXLength      *Length
+	OffsetYLength      *Length
 }
 
 // validate checks if the GridConfig has valid values for all fields.
 //
 // It validates the embedded GeneralConfig. GridSpacingX, GridSpacingY, OffsetX, and OffsetY
 // can be any integer value (including negative), so no specific validation is performed on them.
+// Their unit-aware overrides, when set, must use a supported unit.
 //
 // Returns:
-//   - An error if the GeneralConfig validation fails, or nil if all fields are valid.
+//   - An error describing the first invalid value found, or nil if all fields are valid.
 func (c GridConfig) validate() error {
 	if err := c.GeneralConfig.validate(); err != nil {
 		return err
 	}
 
+	lengths := []struct {
+		name   string
+		length *Length
+	}{
+		{"grid spacing x", c.GridSpacingXLength},
+		{"grid spacing y", c.GridSpacingYLength},
+		{"offset x", c.OffsetXLength},
+		{"offset y", c.OffsetYLength},
+	}
+
+	for _, l := range lengths {
+		if err := validateLength(l.name, l.length, true); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
+
+// resolved returns a copy of the GridConfig whose pixel spacing and offset fields are replaced by
+// their unit-aware overrides, resolved against the input image.
+//
+// Parameters:
+//   - inputBounds: The bounds of the input image, used by percentage values.
+//
+// Returns:
+//   - A GridConfig whose GridSpacingX, GridSpacingY, OffsetX and OffsetY are expressed in pixels.
+func (c GridConfig) resolved(inputBounds image.Rectangle) GridConfig {
+	inW, inH := inputBounds.Dx(), inputBounds.Dy()
+
+	c.GridSpacingX = resolveLength(c.GridSpacingXLength, c.GridSpacingX, inW, c.DPI)
+	c.GridSpacingY = resolveLength(c.GridSpacingYLength, c.GridSpacingY, inH, c.DPI)
+	c.OffsetX = resolveLength(c.OffsetXLength, c.OffsetX, inW, c.DPI)
+	c.OffsetY = resolveLength(c.OffsetYLength, c.OffsetY, inH, c.DPI)
+
+	return c
+}
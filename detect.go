@@ -0,0 +1,227 @@
+package imagewatermark
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+
+	"github.com/disintegration/imaging"
+)
+
+// Detector finds regions of the input image that watermarks should not cover, such as faces.
+//
+// Implementations must be safe for concurrent use, since batch functions call Detect from multiple goroutines.
+type Detector interface {
+	Detect(img image.Image) []image.Rectangle
+}
+
+// DetectorFunc is an adapter that allows ordinary functions to be used as a Detector.
+type DetectorFunc func(img image.Image) []image.Rectangle
+
+// Detect calls f(img).
+func (f DetectorFunc) Detect(img image.Image) []image.Rectangle {
+	return f(img)
+}
+
+// DetectionAction defines what happens to a watermark that overlaps a detected region.
+//
+// Supported values:
+//   - DetectionAvoid: Treats detected regions as exclusion zones: a single watermark is relocated to the nearest
+//     valid position and overlapping grid tiles are skipped.
+//   - DetectionFade: Draws overlapping watermarks with their opacity multiplied by FadeOpacity, which must be set.
+type DetectionAction int
+
+const (
+	DetectionAvoid DetectionAction = iota
+	DetectionFade
+)
+
+// DetectionConfig enables detection-aware placement, so watermarks do not cover people or other objects.
+//
+// Fields:
+//   - Detector: The detector to run on each input image (Default is CascadeDetector{}).
+//   - Action: What happens to overlapping watermarks (Default is DetectionAvoid).
+//   - PaddingPercent: Enlarges each detected region by this percentage of its size on every side (0-100).
+//   - FadeOpacity: Opacity multiplier for overlapping watermarks, required by DetectionFade (0.0 to 1.0).
+//     Use DetectionAvoid to hide them completely.
+type DetectionConfig struct {
+	Detector       Detector
+	Action         DetectionAction
+	PaddingPercent float64
+	FadeOpacity    float64
+}
+
+// validate checks if the DetectionConfig has a supported action and valid padding and fade values.
+//
+// FadeOpacity must be greater than 0 and less than 1 with DetectionFade, so that a forgotten value does not
+// silently hide every overlapping tile.
+//
+// Returns:
+//   - An error describing the first invalid value found, or nil if the configuration is valid.
+func (c DetectionConfig) validate() error {
+	if c.Action < DetectionAvoid || c.Action > DetectionFade {
+		return fmt.Errorf("unknown detection action: %d", c.Action)
+	}
+
+	if c.PaddingPercent < 0 || c.PaddingPercent > 100 {
+		return fmt.Errorf("detection padding percent must be between 0 and 100: %f", c.PaddingPercent)
+	}
+
+	if c.Action == DetectionFade && (c.FadeOpacity <= 0 || c.FadeOpacity >= 1) {
+		return fmt.Errorf("detection fade opacity must be set between 0 and 1 (exclusive) with DetectionFade: %f", c.FadeOpacity)
+	}
+
+	return nil
+}
+
+// detect runs the detector on the input image and pads the detected regions.
+//
+// Parameters:
+//   - inputImg: The input image.
+//
+// Returns:
+//   - The padded detected regions, clipped to the input image bounds.
+func (c DetectionConfig) detect(inputImg image.Image) []image.Rectangle {
+	detector := c.Detector
+	if detector == nil {
+		detector = CascadeDetector{}
+	}
+
+	var regions []image.Rectangle
+	for _, region := range detector.Detect(inputImg) {
+		padX := int(float64(region.Dx()) * c.PaddingPercent / 100)
+		padY := int(float64(region.Dy()) * c.PaddingPercent / 100)
+
+		region = image.Rect(region.Min.X-padX, region.Min.Y-padY, region.Max.X+padX, region.Max.Y+padY).Intersect(inputImg.Bounds())
+		if !region.Empty() {
+			regions = append(regions, region)
+		}
+	}
+
+	return regions
+}
+
+// skinDetectionSize is the length of the longer side of the downscaled image analyzed by SkinToneDetector.
+const skinDetectionSize = 160
+
+// SkinToneDetector is a skin-color heuristic that reports skin-colored regions, not a face detector.
+//
+// The image is downscaled, pixels are classified as skin in the YCbCr color space, and connected skin
+// regions whose shape and density resemble a face are returned. It reports hands, arms and skin-colored
+// textures such as wood or sand too, misses skin tones outside its range and finds nothing in grayscale
+// images. It is only useful to keep watermarks off any visible skin; use CascadeDetector (the default)
+// to find faces.
+//
+// Fields:
+//   - MinAreaPercent: Minimum area of a region, as a percentage of the image area (Default is 0.5).
+type SkinToneDetector struct {
+	MinAreaPercent float64
+}
+
+// Detect returns the bounding boxes of the face-like skin regions of the image.
+func (d SkinToneDetector) Detect(img image.Image) []image.Rectangle {
+	bounds := img.Bounds()
+	if bounds.Empty() {
+		return nil
+	}
+
+	small := imaging.Fit(img, skinDetectionSize, skinDetectionSize, imaging.Box)
+	width, height := small.Bounds().Dx(), small.Bounds().Dy()
+
+	skin := make([]bool, width*height)
+	for i := range skin {
+		p := small.Pix[i*4:]
+		if p[3] < 128 {
+			continue
+		}
+		y, cb, cr := color.RGBToYCbCr(p[0], p[1], p[2])
+		skin[i] = y > 40 && cb >= 77 && cb <= 127 && cr >= 133 && cr <= 173
+	}
+
+	minAreaPercent := d.MinAreaPercent
+	if minAreaPercent <= 0 {
+		minAreaPercent = 0.5
+	}
+	minArea := int(float64(width*height) * minAreaPercent / 100)
+
+	scaleX := float64(bounds.Dx()) / float64(width)
+	scaleY := float64(bounds.Dy()) / float64(height)
+
+	var faces []image.Rectangle
+	for _, region := range skinRegions(skin, width, height) {
+		if region.area < max(minArea, 1) {
+			continue
+		}
+
+		box := region.bounds
+		aspect := float64(box.Dy()) / float64(box.Dx())
+		density := float64(region.area) / float64(box.Dx()*box.Dy())
+		if aspect < 0.8 || aspect > 2.2 || density < 0.4 {
+			continue
+		}
+
+		faces = append(faces, image.Rect(
+			bounds.Min.X+int(float64(box.Min.X)*scaleX),
+			bounds.Min.Y+int(float64(box.Min.Y)*scaleY),
+			bounds.Min.X+int(float64(box.Max.X)*scaleX+0.5),
+			bounds.Min.Y+int(float64(box.Max.Y)*scaleY+0.5),
+		))
+	}
+
+	return faces
+}
+
+// skinRegion is a connected component of a binary mask.
+type skinRegion struct {
+	bounds image.Rectangle
+	area   int
+}
+
+// skinRegions labels the 4-connected components of a binary mask.
+//
+// Parameters:
+//   - mask: The binary mask, row by row.
+//   - width: The mask width.
+//   - height: The mask height.
+//
+// Returns:
+//   - The bounding box and pixel count of every component.
+func skinRegions(mask []bool, width, height int) []skinRegion {
+	visited := make([]bool, len(mask))
+	var regions []skinRegion
+	var stack []int
+
+	for start := range mask {
+		if !mask[start] || visited[start] {
+			continue
+		}
+
+		visited[start] = true
+		stack = append(stack[:0], start)
+		region := skinRegion{bounds: image.Rect(start%width, start/width, start%width+1, start/width+1)}
+
+		for len(stack) > 0 {
+			i := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+
+			x, y := i%width, i/width
+			region.area++
+			region.bounds = region.bounds.Union(image.Rect(x, y, x+1, y+1))
+
+			for _, n := range [4]int{i - 1, i + 1, i - width, i + width} {
+				switch {
+				case n < 0 || n >= len(mask), n == i-1 && x == 0, n == i+1 && x == width-1:
+					continue
+				}
+				if mask[n] && !visited[n] {
+					visited[n] = true
+					stack = append(stack, n)
+				}
+			}
+		}
+
+		regions = append(regions, region)
+	}
+
+	return regions
+}
@@ -0,0 +1,239 @@
+package imagewatermark
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/disintegration/imaging"
+)
+
+// drawFace returns a blurred grayscale drawing of a face: a light oval on a dark background with darker eyes,
+// eyebrows, nose and mouth, which is enough for the cascade to recognize it.
+func drawFace(width, height int) *image.NRGBA {
+	img := image.NewGray(image.Rect(0, 0, width, height))
+	cx, cy := float64(width)/2, float64(height)/2
+	rx, ry := float64(width)*0.2, float64(height)*0.25
+
+	inEllipse := func(x, y, ex, ey, ax, ay float64) bool {
+		dx, dy := (x-ex)/(ax*rx), (y-ey)/(ay*ry)
+		return dx*dx+dy*dy <= 1
+	}
+
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			fx, fy := float64(x)+0.5, float64(y)+0.5
+			value := uint8(30)
+			switch {
+			case inEllipse(fx, fy, cx-0.4*rx, cy-0.23*ry, 0.28, 0.12), inEllipse(fx, fy, cx+0.4*rx, cy-0.23*ry, 0.28, 0.12):
+				value = 90
+			case inEllipse(fx, fy, cx-0.4*rx, cy-0.4*ry, 0.3, 0.05), inEllipse(fx, fy, cx+0.4*rx, cy-0.4*ry, 0.3, 0.05):
+				value = 90
+			case inEllipse(fx, fy, cx, cy+0.09*ry, 0.15, 0.14):
+				value = 170
+			case inEllipse(fx, fy, cx, cy+0.43*ry, 0.5, 0.08):
+				value = 90
+			case inEllipse(fx, fy, cx, cy, 1, 1):
+				value = 245
+			}
+			img.Pix[y*img.Stride+x] = value
+		}
+	}
+
+	return imaging.Blur(img, 4)
+}
+
+func TestCascadeDetector(t *testing.T) {
+	portrait := drawFace(320, 400)
+
+	gray := image.NewGray(portrait.Bounds())
+	draw.Draw(gray, gray.Bounds(), portrait, portrait.Bounds().Min, draw.Src)
+
+	shifted := image.NewNRGBA(portrait.Bounds().Add(image.Pt(100, 50)))
+	draw.Draw(shifted, shifted.Bounds(), portrait, portrait.Bounds().Min, draw.Src)
+
+	face := image.Rect(61, 92, 249, 280)
+
+	tests := []struct {
+		name     string
+		img      image.Image
+		detector CascadeDetector
+		want     []image.Rectangle
+	}{
+		{"portrait", portrait, CascadeDetector{}, []image.Rectangle{face}},
+		{"grayscale portrait", gray, CascadeDetector{}, []image.Rectangle{face}},
+		{"offset bounds", shifted, CascadeDetector{}, []image.Rectangle{face.Add(image.Pt(100, 50))}},
+		{"score below the minimum", portrait, CascadeDetector{MinScore: 1e6}, nil},
+		{"blank image", uniformImage(320, 400, color.NRGBA{R: 200, G: 200, B: 200, A: 255}), CascadeDetector{}, nil},
+		{"empty image", image.NewNRGBA(image.Rectangle{}), CascadeDetector{}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.detector.Detect(tt.img)
+			if len(got) != len(tt.want) {
+				t.Fatalf("Detect() = %v, want %v", got, tt.want)
+			}
+			for i, want := range tt.want {
+				// Allow a few pixels of difference caused by grayscale conversion and rounding.
+				if diff := got[i].Min.Sub(want.Min).Add(got[i].Max.Sub(want.Max)); absInt(diff.X) > 8 || absInt(diff.Y) > 8 {
+					t.Errorf("Detect()[%d] = %v, want about %v", i, got[i], want)
+				}
+			}
+		})
+	}
+}
+
+func TestParseCascade(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    []byte
+		wantErr string
+	}{
+		{"bundled", facefinderCascade, ""},
+		{"truncated header", facefinderCascade[:10], "truncated cascade"},
+		{"truncated trees", facefinderCascade[:len(facefinderCascade)-1], "invalid cascade size"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseCascade(tt.data)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("parseCascade() = %v, want nil", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("parseCascade() = %v, want error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestDetectionConfigDetect(t *testing.T) {
+	input := image.NewRGBA(image.Rect(0, 0, 100, 100))
+
+	tests := []struct {
+		name    string
+		regions []image.Rectangle
+		padding float64
+		want    []image.Rectangle
+	}{
+		{"no padding", []image.Rectangle{image.Rect(40, 40, 60, 60)}, 0, []image.Rectangle{image.Rect(40, 40, 60, 60)}},
+		{"padding", []image.Rectangle{image.Rect(40, 40, 60, 60)}, 25, []image.Rectangle{image.Rect(35, 35, 65, 65)}},
+		{"wide region padded per axis", []image.Rectangle{image.Rect(40, 40, 80, 60)}, 10, []image.Rectangle{image.Rect(36, 38, 84, 62)}},
+		{"tall region padded per axis", []image.Rectangle{image.Rect(40, 20, 50, 80)}, 20, []image.Rectangle{image.Rect(38, 8, 52, 92)}},
+		{"clipped to the image", []image.Rectangle{image.Rect(90, -10, 120, 20)}, 0, []image.Rectangle{image.Rect(90, 0, 100, 20)}},
+		{"outside the image", []image.Rectangle{image.Rect(200, 200, 220, 220)}, 0, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := DetectionConfig{
+				Detector:       DetectorFunc(func(image.Image) []image.Rectangle { return tt.regions }),
+				PaddingPercent: tt.padding,
+			}
+			if got := config.detect(input); !slices.Equal(got, tt.want) {
+				t.Errorf("detect() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyWithDetection(t *testing.T) {
+	white := color.NRGBA{R: 255, G: 255, B: 255, A: 255}
+	red := color.NRGBA{R: 255, A: 255}
+	face := DetectorFunc(func(image.Image) []image.Rectangle { return []image.Rectangle{image.Rect(30, 30, 60, 70)} })
+
+	tests := []struct {
+		name          string
+		detection     DetectionConfig
+		positions     []image.Point
+		wantPositions []image.Point
+		wantRejected  int
+		want          map[image.Point]color.NRGBA
+	}{
+		{
+			name:          "single watermark avoids the face",
+			detection:     DetectionConfig{Detector: face},
+			positions:     []image.Point{{45, 45}},
+			wantPositions: []image.Point{{60, 45}},
+			wantRejected:  1,
+			want:          map[image.Point]color.NRGBA{{50, 50}: white, {65, 50}: red},
+		},
+		{
+			name:          "grid tiles over the face are skipped",
+			detection:     DetectionConfig{Detector: face},
+			positions:     []image.Point{{0, 0}, {40, 40}, {80, 80}},
+			wantPositions: []image.Point{{0, 0}, {80, 80}},
+			wantRejected:  1,
+			want:          map[image.Point]color.NRGBA{{5, 5}: red, {45, 45}: white, {85, 85}: red},
+		},
+		{
+			name:          "no detection",
+			detection:     DetectionConfig{Detector: DetectorFunc(func(image.Image) []image.Rectangle { return nil })},
+			positions:     []image.Point{{45, 45}},
+			wantPositions: []image.Point{{45, 45}},
+			want:          map[image.Point]color.NRGBA{{50, 50}: red},
+		},
+		{
+			name:          "fade",
+			detection:     DetectionConfig{Detector: face, Action: DetectionFade, FadeOpacity: 0.5},
+			positions:     []image.Point{{0, 0}, {40, 40}},
+			wantPositions: []image.Point{{0, 0}, {40, 40}},
+			want:          map[image.Point]color.NRGBA{{5, 5}: red, {45, 45}: {R: 255, G: 127, B: 127, A: 255}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			placer := PlacerFunc(func(image.Image, image.Rectangle) []image.Point { return tt.positions })
+			config := GeneralConfig{OpacityAlpha: 1, WatermarkWidthPercent: 20, Detection: &tt.detection}
+
+			result, report, err := ApplyWithReport(uniformImage(100, 100, white), uniformImage(20, 20, red), config, placer)
+			if err != nil {
+				t.Fatalf("ApplyWithReport: %v", err)
+			}
+
+			if !slices.Equal(report.Positions, tt.wantPositions) {
+				t.Errorf("report positions = %v, want %v", report.Positions, tt.wantPositions)
+			}
+			if len(report.Rejections) != tt.wantRejected {
+				t.Errorf("report rejections = %v, want %d", report.Rejections, tt.wantRejected)
+			}
+			for p, want := range tt.want {
+				got := nrgbaAt(result, p.X, p.Y)
+				if absInt(int(got.R)-int(want.R)) > 1 || absInt(int(got.G)-int(want.G)) > 1 || absInt(int(got.B)-int(want.B)) > 1 {
+					t.Errorf("pixel %v = %v, want %v", p, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestDetectionConfigValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  DetectionConfig
+		wantErr string
+	}{
+		{"avoid", DetectionConfig{}, ""},
+		{"fade", DetectionConfig{Action: DetectionFade, FadeOpacity: 0.3}, ""},
+		{"padding", DetectionConfig{PaddingPercent: 100}, ""},
+		{"unknown action", DetectionConfig{Action: DetectionFade + 1}, "unknown detection action"},
+		{"negative padding", DetectionConfig{PaddingPercent: -1}, "padding percent"},
+		{"fade without opacity", DetectionConfig{Action: DetectionFade}, "fade opacity must be set"},
+		{"fade fully opaque", DetectionConfig{Action: DetectionFade, FadeOpacity: 1}, "fade opacity must be set"},
+		{"fade opacity ignored when avoiding", DetectionConfig{FadeOpacity: 5}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checkValidateError(t, tt.config.validate(), tt.wantErr)
+		})
+	}
+}
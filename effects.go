@@ -0,0 +1,384 @@
+package imagewatermark
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+
+	"github.com/disintegration/imaging"
+)
+
+// Effects holds optional visual effects generated from the watermark alpha channel to improve its legibility.
+//
+// Effects are rendered during watermark preparation, after resizing and rotation, so they work the same way
+// for single, grid, composition and batch modes. The decorated watermark (including shadow, outline and glow)
+// is treated as a whole for placement.
+//
+// Fields:
+//   - Shadow: Optional drop shadow drawn below the watermark.
+//   - Outline: Optional outline (stroke) drawn around the watermark.
+//   - Glow: Optional outer glow drawn around the watermark.
+type Effects struct {
+	Shadow  *ShadowEffect
+	Outline *OutlineEffect
+	Glow    *GlowEffect
+}
+
+// ShadowEffect describes a drop shadow cast by the watermark.
+//
+// Fields:
+//   - OffsetX: Horizontal offset of the shadow in pixels. Positive values move it to the right.
+//   - OffsetY: Vertical offset of the shadow in pixels. Positive values move it down.
+//   - BlurRadius: Standard deviation of the Gaussian blur applied to the shadow in pixels (0 for a hard shadow).
+//   - Color: Color of the shadow (Default is black).
+//   - Opacity: Opacity of the shadow (0.0 to 1.0].
+type ShadowEffect struct {
+	OffsetX    int
+	OffsetY    int
+	BlurRadius float64
+	Color      color.Color
+	Opacity    float64
+}
+
+// OutlineEffect describes a solid stroke around the watermark shape.
+//
+// Fields:
+//   - Width: Width of the outline in pixels.
+//   - Color: Color of the outline (Default is black).
+type OutlineEffect struct {
+	Width int
+	Color color.Color
+}
+
+// GlowEffect describes a soft halo around the watermark shape.
+//
+// Fields:
+//   - Radius: Standard deviation of the Gaussian blur used to spread the glow in pixels.
+//   - Color: Color of the glow (Default is white).
+//   - Opacity: Opacity of the glow (0.0 to 1.0].
+type GlowEffect struct {
+	Radius  float64
+	Color   color.Color
+	Opacity float64
+}
+
+// validate checks if all configured effects have valid values.
+//
+// It performs the following validations:
+//   - Shadow BlurRadius must be non-negative and its Opacity greater than 0 and at most 1.
+//   - Outline Width must be a positive integer.
+//   - Glow Radius must be positive and its Opacity greater than 0 and at most 1.
+//
+// Returns:
+//   - An error describing the first invalid value found, or nil if all effects are valid.
+func (e Effects) validate() error {
+	if e.Shadow != nil {
+		if e.Shadow.BlurRadius < 0 {
+			return fmt.Errorf("shadow blur radius must be non-negative: %f", e.Shadow.BlurRadius)
+		}
+
+		if e.Shadow.Opacity <= 0 || e.Shadow.Opacity > 1 {
+			return fmt.Errorf("shadow opacity must be greater than 0 and less than or equal to 1: %f", e.Shadow.Opacity)
+		}
+	}
+
+	if e.Outline != nil && e.Outline.Width <= 0 {
+		return fmt.Errorf("outline width must be a positive integer: %d", e.Outline.Width)
+	}
+
+	if e.Glow != nil {
+		if e.Glow.Radius <= 0 {
+			return errors.New("glow radius must be positive")
+		}
+
+		if e.Glow.Opacity <= 0 || e.Glow.Opacity > 1 {
+			return fmt.Errorf("glow opacity must be greater than 0 and less than or equal to 1: %f", e.Glow.Opacity)
+		}
+	}
+
+	return nil
+}
+
+// enabled reports whether at least one effect is configured.
+func (e Effects) enabled() bool {
+	return e.Shadow != nil || e.Outline != nil || e.Glow != nil
+}
+
+// applyEffects renders the configured effects around the watermark.
+//
+// The watermark is placed on a larger transparent canvas that fits every effect, then the layers are composited
+// from bottom to top: shadow, glow, outline and finally the watermark itself. Each effect is generated from the
+// watermark alpha channel and knocked out under the watermark shape, so it never shows through semi-transparent
+// watermark pixels. Transparent borders are trimmed from the result.
+//
+// Parameters:
+//   - watermarkImg: The resized and rotated watermark image.
+//   - effects: The effects to render.
+//
+// Returns:
+//   - An image.Image containing the watermark decorated with the effects, or the original watermark if no effect is configured.
+func applyEffects(watermarkImg image.Image, effects Effects) image.Image {
+	if !effects.enabled() {
+		return watermarkImg
+	}
+
+	wm := imaging.Clone(watermarkImg)
+	wmW, wmH := wm.Bounds().Dx(), wm.Bounds().Dy()
+
+	pad := 0
+	if effects.Shadow != nil {
+		shadowPad := blurExtent(effects.Shadow.BlurRadius) + max(absInt(effects.Shadow.OffsetX), absInt(effects.Shadow.OffsetY))
+		pad = max(pad, shadowPad)
+	}
+	if effects.Outline != nil {
+		pad = max(pad, effects.Outline.Width+1)
+	}
+	if effects.Glow != nil {
+		pad = max(pad, blurExtent(effects.Glow.Radius))
+	}
+
+	w, h := wmW+2*pad, wmH+2*pad
+	alpha := make([]float32, w*h)
+	for y := 0; y < wmH; y++ {
+		for x := 0; x < wmW; x++ {
+			alpha[(y+pad)*w+x+pad] = float32(wm.Pix[y*wm.Stride+x*4+3]) / 255
+		}
+	}
+
+	out := make([]float32, w*h*4)
+
+	if effects.Shadow != nil {
+		shadow := shiftMask(alpha, w, h, effects.Shadow.OffsetX, effects.Shadow.OffsetY)
+		shadow = knockOutMask(blurMask(shadow, w, h, effects.Shadow.BlurRadius), alpha)
+		compositeMask(out, shadow, effectColor(effects.Shadow.Color, color.Black), effects.Shadow.Opacity)
+	}
+
+	if effects.Glow != nil {
+		glow := blurMask(alpha, w, h, effects.Glow.Radius)
+		for i := range glow {
+			glow[i] = min(glow[i]*2, 1) * (1 - alpha[i])
+		}
+		compositeMask(out, glow, effectColor(effects.Glow.Color, color.White), effects.Glow.Opacity)
+	}
+
+	if effects.Outline != nil {
+		outline := knockOutMask(dilateMask(alpha, w, h, effects.Outline.Width), alpha)
+		compositeMask(out, outline, effectColor(effects.Outline.Color, color.Black), 1)
+	}
+
+	for y := 0; y < wmH; y++ {
+		for x := 0; x < wmW; x++ {
+			si := y*wm.Stride + x*4
+			a := float32(wm.Pix[si+3]) / 255
+			if a == 0 {
+				continue
+			}
+
+			di := ((y+pad)*w + x + pad) * 4
+			for c := 0; c < 3; c++ {
+				out[di+c] = float32(wm.Pix[si+c])/255*a + out[di+c]*(1-a)
+			}
+			out[di+3] = a + out[di+3]*(1-a)
+		}
+	}
+
+	result := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for i := 0; i < w*h; i++ {
+		a := out[i*4+3]
+		if a <= 0 {
+			continue
+		}
+
+		for c := 0; c < 3; c++ {
+			result.Pix[i*4+c] = uint8(math.Round(float64(min(out[i*4+c]/a, 1) * 255)))
+		}
+		result.Pix[i*4+3] = uint8(math.Round(float64(min(a, 1) * 255)))
+	}
+
+	return trimTransparent(result)
+}
+
+// effectColor converts an optional effect color to non-premultiplied RGBA, using a fallback when it is nil.
+func effectColor(c, fallback color.Color) color.NRGBA {
+	if c == nil {
+		c = fallback
+	}
+
+	return color.NRGBAModel.Convert(c).(color.NRGBA)
+}
+
+// compositeMask draws a solid color through an alpha mask onto a premultiplied float buffer using the "Over" operator.
+//
+// Parameters:
+//   - out: The premultiplied RGBA buffer (4 values per pixel) to draw onto.
+//   - mask: The alpha mask (1 value per pixel) in the [0, 1] range.
+//   - c: The color to draw.
+//   - opacity: Opacity multiplier applied to the mask and the color alpha.
+func compositeMask(out, mask []float32, c color.NRGBA, opacity float64) {
+	colorAlpha := float32(c.A) / 255 * float32(opacity)
+	r, g, b := float32(c.R)/255, float32(c.G)/255, float32(c.B)/255
+
+	for i, m := range mask {
+		a := m * colorAlpha
+		if a <= 0 {
+			continue
+		}
+
+		j := i * 4
+		out[j] = r*a + out[j]*(1-a)
+		out[j+1] = g*a + out[j+1]*(1-a)
+		out[j+2] = b*a + out[j+2]*(1-a)
+		out[j+3] = a + out[j+3]*(1-a)
+	}
+}
+
+// knockOutMask removes the parts of an effect mask covered by the watermark shape, modifying it in place.
+//
+// Parameters:
+//   - mask: The effect mask to modify.
+//   - shape: The watermark alpha mask.
+//
+// Returns:
+//   - The modified effect mask.
+func knockOutMask(mask, shape []float32) []float32 {
+	for i := range mask {
+		mask[i] *= 1 - shape[i]
+	}
+
+	return mask
+}
+
+// shiftMask returns a copy of the mask moved by the given offset, filling uncovered areas with zero.
+func shiftMask(mask []float32, w, h, dx, dy int) []float32 {
+	shifted := make([]float32, len(mask))
+
+	for y := 0; y < h; y++ {
+		sy := y - dy
+		if sy < 0 || sy >= h {
+			continue
+		}
+
+		for x := 0; x < w; x++ {
+			sx := x - dx
+			if sx < 0 || sx >= w {
+				continue
+			}
+			shifted[y*w+x] = mask[sy*w+sx]
+		}
+	}
+
+	return shifted
+}
+
+// blurExtent returns how many pixels a Gaussian blur with the given standard deviation spreads.
+func blurExtent(sigma float64) int {
+	return int(math.Ceil(sigma * 3))
+}
+
+// blurMask applies a separable Gaussian blur to the mask.
+//
+// Parameters:
+//   - mask: The alpha mask (1 value per pixel) to blur.
+//   - w, h: The dimensions of the mask.
+//   - sigma: The standard deviation of the Gaussian kernel in pixels. Values <= 0 return the mask unchanged.
+//
+// Returns:
+//   - A new mask with the blur applied.
+func blurMask(mask []float32, w, h int, sigma float64) []float32 {
+	radius := blurExtent(sigma)
+	if radius <= 0 {
+		return append([]float32(nil), mask...)
+	}
+
+	kernel := make([]float32, 2*radius+1)
+	var sum float32
+	for i := range kernel {
+		d := float64(i - radius)
+		kernel[i] = float32(math.Exp(-d * d / (2 * sigma * sigma)))
+		sum += kernel[i]
+	}
+	for i := range kernel {
+		kernel[i] /= sum
+	}
+
+	tmp := make([]float32, len(mask))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var v float32
+			for k, weight := range kernel {
+				sx := x + k - radius
+				if sx >= 0 && sx < w {
+					v += mask[y*w+sx] * weight
+				}
+			}
+			tmp[y*w+x] = v
+		}
+	}
+
+	blurred := make([]float32, len(mask))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var v float32
+			for k, weight := range kernel {
+				sy := y + k - radius
+				if sy >= 0 && sy < h {
+					v += tmp[sy*w+x] * weight
+				}
+			}
+			blurred[y*w+x] = v
+		}
+	}
+
+	return blurred
+}
+
+// dilateMask grows the mask by the given radius using a circular structuring element.
+//
+// Parameters:
+//   - mask: The alpha mask (1 value per pixel) to dilate.
+//   - w, h: The dimensions of the mask.
+//   - radius: The dilation radius in pixels.
+//
+// Returns:
+//   - A new mask where each pixel holds the maximum value found within the radius.
+func dilateMask(mask []float32, w, h, radius int) []float32 {
+	var offsets []image.Point
+	for dy := -radius; dy <= radius; dy++ {
+		for dx := -radius; dx <= radius; dx++ {
+			if dx*dx+dy*dy <= radius*radius {
+				offsets = append(offsets, image.Point{X: dx, Y: dy})
+			}
+		}
+	}
+
+	dilated := make([]float32, len(mask))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var v float32
+			for _, o := range offsets {
+				sx, sy := x+o.X, y+o.Y
+				if sx < 0 || sx >= w || sy < 0 || sy >= h {
+					continue
+				}
+				v = max(v, mask[sy*w+sx])
+				if v >= 1 {
+					break
+				}
+			}
+			dilated[y*w+x] = v
+		}
+	}
+
+	return dilated
+}
+
+// absInt returns the absolute value of an integer.
+func absInt(v int) int {
+	if v < 0 {
+		return -v
+	}
+
+	return v
+}
@@ -0,0 +1,108 @@
+package imagewatermark
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestApplyEffects(t *testing.T) {
+	red := color.NRGBA{R: 255, A: 255}
+	blue := color.NRGBA{B: 255, A: 255}
+	black := color.NRGBA{A: 255}
+	transparent := color.NRGBA{}
+
+	tests := []struct {
+		name     string
+		effects  Effects
+		wantSize image.Point
+		want     map[image.Point]color.NRGBA
+	}{
+		{
+			name:     "no effects",
+			effects:  Effects{},
+			wantSize: image.Pt(10, 10),
+			want:     map[image.Point]color.NRGBA{{0, 0}: red, {9, 9}: red},
+		},
+		{
+			name:     "outline",
+			effects:  Effects{Outline: &OutlineEffect{Width: 2, Color: blue}},
+			wantSize: image.Pt(14, 14),
+			want:     map[image.Point]color.NRGBA{{0, 7}: blue, {1, 7}: blue, {2, 7}: red, {7, 13}: blue, {7, 7}: red},
+		},
+		{
+			name:     "hard shadow",
+			effects:  Effects{Shadow: &ShadowEffect{OffsetX: 3, OffsetY: 3, Opacity: 1}},
+			wantSize: image.Pt(13, 13),
+			want:     map[image.Point]color.NRGBA{{5, 5}: red, {9, 9}: red, {11, 11}: black, {12, 3}: black, {11, 1}: transparent, {1, 11}: transparent},
+		},
+		{
+			name:     "shadow up and left",
+			effects:  Effects{Shadow: &ShadowEffect{OffsetX: -2, OffsetY: -4, Opacity: 1}},
+			wantSize: image.Pt(12, 14),
+			want:     map[image.Point]color.NRGBA{{0, 0}: black, {2, 4}: red, {11, 13}: red, {11, 0}: transparent},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := applyEffects(uniformImage(10, 10, red), tt.effects)
+			if size := result.Bounds().Size(); size != tt.wantSize {
+				t.Fatalf("applyEffects() size = %v, want %v", size, tt.wantSize)
+			}
+			for p, want := range tt.want {
+				if got := nrgbaAt(result, p.X, p.Y); got != want {
+					t.Errorf("pixel %v = %v, want %v", p, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestApplyEffectsGlow(t *testing.T) {
+	red := color.NRGBA{R: 255, A: 255}
+	result := applyEffects(uniformImage(10, 10, red), Effects{Glow: &GlowEffect{Radius: 2, Opacity: 1}})
+
+	bounds := result.Bounds()
+	if bounds.Dx() <= 10 || bounds.Dy() <= 10 {
+		t.Fatalf("applyEffects() with glow size = %v, want larger than 10x10", bounds.Size())
+	}
+
+	center := image.Pt(bounds.Dx()/2, bounds.Dy()/2)
+	if got := nrgbaAt(result, center.X, center.Y); got != red {
+		t.Errorf("center pixel = %v, want %v", got, red)
+	}
+
+	inner := (bounds.Dx() - 10) / 2
+	near := nrgbaAt(result, inner-1, center.Y)
+	far := nrgbaAt(result, 0, center.Y)
+	if near.R != 255 || near.G != 255 || near.B != 255 || near.A == 0 || near.A == 255 {
+		t.Errorf("pixel next to the watermark = %v, want partially transparent white", near)
+	}
+	if far.A >= near.A {
+		t.Errorf("glow alpha at the edge %d, want it fading below %d", far.A, near.A)
+	}
+}
+
+func TestEffectsValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		effects Effects
+		wantErr string
+	}{
+		{"none", Effects{}, ""},
+		{"all", Effects{Shadow: &ShadowEffect{BlurRadius: 2, Opacity: 0.5}, Outline: &OutlineEffect{Width: 1}, Glow: &GlowEffect{Radius: 3, Opacity: 1}}, ""},
+		{"negative shadow blur", Effects{Shadow: &ShadowEffect{BlurRadius: -1, Opacity: 0.5}}, "shadow blur radius"},
+		{"zero shadow opacity", Effects{Shadow: &ShadowEffect{}}, "shadow opacity"},
+		{"shadow opacity above 1", Effects{Shadow: &ShadowEffect{Opacity: 1.5}}, "shadow opacity"},
+		{"zero outline width", Effects{Outline: &OutlineEffect{}}, "outline width"},
+		{"zero glow radius", Effects{Glow: &GlowEffect{Opacity: 0.5}}, "glow radius"},
+		{"negative glow opacity", Effects{Glow: &GlowEffect{Radius: 1, Opacity: -0.5}}, "glow opacity"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checkValidateError(t, tt.effects.validate(), tt.wantErr)
+		})
+	}
+}
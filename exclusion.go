@@ -0,0 +1,345 @@
+package imagewatermark
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"math"
+
+	"github.com/disintegration/imaging"
+)
+
+// ExclusionMode defines what happens to a watermark that overlaps an excluded area.
+//
+// Supported values:
+//   - ExclusionAuto: Relocates the watermark when the placer returned a single position, and skips it otherwise (grids).
+//   - ExclusionSkip: Does not draw the watermark.
+//   - ExclusionClip: Draws the watermark except for the pixels inside the excluded area.
+//   - ExclusionRelocate: Moves the watermark to the nearest position that is fully inside the image and outside
+//     the excluded area, or skips it when there is none.
+type ExclusionMode int
+
+const (
+	ExclusionAuto ExclusionMode = iota
+	ExclusionSkip
+	ExclusionClip
+	ExclusionRelocate
+)
+
+// Exclusions reserves areas of the input image where watermarks must never be drawn,
+// such as product labels or the price badge of a template.
+//
+// A watermark overlaps the excluded area when its bounding box covers at least one excluded pixel.
+//
+// Fields:
+//   - Rects: Excluded rectangles, in input image coordinates.
+//   - Mask: Optional inclusion mask stretched over the input image. Pixels whose luminance (multiplied by the alpha)
+//     is below 50% are excluded, so white marks where watermarks are allowed and black or transparent where they are not.
+//   - Mode: What happens to overlapping watermarks (Default is ExclusionAuto).
+type Exclusions struct {
+	Rects []image.Rectangle
+	Mask  image.Image
+	Mode  ExclusionMode
+}
+
+// Rejection describes a watermark position that overlapped an excluded area.
+//
+// Fields:
+//   - Position: The top-left corner returned by the placer.
+//   - Zone: The index of the first overlapping rectangle in Exclusions.Rects followed by the avoided detections
+//     (Report.Detections), or -1 when only the mask was overlapped.
+//   - Reason: A human-readable description of the action taken (skipped, clipped or relocated).
+type Rejection struct {
+	Position image.Point
+	Zone     int
+	Reason   string
+}
+
+// validate checks if the Exclusions have a supported mode and at least one excluded area.
+//
+// Returns:
+//   - An error describing the first invalid value found, or nil if the exclusions are valid.
+func (e Exclusions) validate() error {
+	if len(e.Rects) == 0 && e.Mask == nil {
+		return errors.New("at least one rectangle or a mask is required")
+	}
+
+	if e.Mode < ExclusionAuto || e.Mode > ExclusionRelocate {
+		return fmt.Errorf("unknown exclusion mode: %d", e.Mode)
+	}
+
+	for i, rect := range e.Rects {
+		if rect.Empty() {
+			return fmt.Errorf("exclusion rectangle %d is empty: %v", i, rect)
+		}
+	}
+
+	return nil
+}
+
+// exclusionMap is a per-pixel map of the excluded area of an input image, with a summed-area table
+// to count the excluded pixels under any rectangle in constant time.
+type exclusionMap struct {
+	exclusions Exclusions
+	bounds     image.Rectangle
+	excluded   []bool
+	sums       []int32
+}
+
+// newExclusionMap rasterizes the excluded rectangles and mask over the input image bounds.
+//
+// Parameters:
+//   - exclusions: The validated exclusion settings.
+//   - inputBounds: The bounds of the input image.
+//
+// Returns:
+//   - A pointer to the exclusionMap.
+func newExclusionMap(exclusions Exclusions, inputBounds image.Rectangle) *exclusionMap {
+	width, height := inputBounds.Dx(), inputBounds.Dy()
+	m := &exclusionMap{
+		exclusions: exclusions,
+		bounds:     inputBounds,
+		excluded:   make([]bool, width*height),
+		sums:       make([]int32, (width+1)*(height+1)),
+	}
+
+	for _, rect := range exclusions.Rects {
+		rect = rect.Intersect(inputBounds)
+		for y := rect.Min.Y; y < rect.Max.Y; y++ {
+			for x := rect.Min.X; x < rect.Max.X; x++ {
+				m.excluded[(y-inputBounds.Min.Y)*width+x-inputBounds.Min.X] = true
+			}
+		}
+	}
+
+	if exclusions.Mask != nil && width > 0 && height > 0 {
+		mask := imaging.Resize(exclusions.Mask, width, height, imaging.Linear)
+		for i := range m.excluded {
+			p := mask.Pix[i*4:]
+			coverage := (0.2126*float64(p[0]) + 0.7152*float64(p[1]) + 0.0722*float64(p[2])) * float64(p[3]) / 255
+			if coverage < 127.5 {
+				m.excluded[i] = true
+			}
+		}
+	}
+
+	for y := 0; y < height; y++ {
+		var rowSum int32
+		for x := 0; x < width; x++ {
+			if m.excluded[y*width+x] {
+				rowSum++
+			}
+			m.sums[(y+1)*(width+1)+x+1] = m.sums[y*(width+1)+x+1] + rowSum
+		}
+	}
+
+	return m
+}
+
+// overlaps reports whether the rectangle covers at least one excluded pixel.
+func (m *exclusionMap) overlaps(rect image.Rectangle) bool {
+	rect = rect.Intersect(m.bounds).Sub(m.bounds.Min)
+	if rect.Empty() {
+		return false
+	}
+
+	stride := m.bounds.Dx() + 1
+	count := m.sums[rect.Max.Y*stride+rect.Max.X] - m.sums[rect.Min.Y*stride+rect.Max.X] -
+		m.sums[rect.Max.Y*stride+rect.Min.X] + m.sums[rect.Min.Y*stride+rect.Min.X]
+
+	return count > 0
+}
+
+// zone returns the index of the first excluded rectangle overlapping the given rectangle, or -1 if only the mask does.
+func (m *exclusionMap) zone(rect image.Rectangle) int {
+	for i, zone := range m.exclusions.Rects {
+		if zone.Overlaps(rect) {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// relocate finds the position closest to pos where a watermark of the given size is fully inside the image
+// and does not overlap the excluded area.
+//
+// Candidate coordinates are the edges of the image and of every excluded rectangle, plus a regular grid
+// whose step is a fraction of the watermark size, so masks are searched too.
+//
+// Parameters:
+//   - pos: The requested top-left corner.
+//   - size: The size of the watermark.
+//
+// Returns:
+//   - The nearest valid top-left corner.
+//   - A bool that is false when no valid position exists.
+func (m *exclusionMap) relocate(pos image.Point, size image.Point) (image.Point, bool) {
+	xs := m.candidateCoordinates(pos.X, size.X, m.bounds.Min.X, m.bounds.Max.X, func(r image.Rectangle) (int, int) {
+		return r.Min.X, r.Max.X
+	})
+	ys := m.candidateCoordinates(pos.Y, size.Y, m.bounds.Min.Y, m.bounds.Max.Y, func(r image.Rectangle) (int, int) {
+		return r.Min.Y, r.Max.Y
+	})
+
+	best, bestDistance := image.Point{}, math.Inf(1)
+
+	for _, y := range ys {
+		for _, x := range xs {
+			distance := math.Hypot(float64(x-pos.X), float64(y-pos.Y))
+			if distance >= bestDistance {
+				continue
+			}
+
+			candidate := image.Pt(x, y)
+			if !m.overlaps(image.Rectangle{Min: candidate, Max: candidate.Add(size)}) {
+				best, bestDistance = candidate, distance
+			}
+		}
+	}
+
+	return best, !math.IsInf(bestDistance, 1)
+}
+
+// candidateCoordinates lists the coordinates along one axis where a relocated watermark may start.
+//
+// Parameters:
+//   - requested: The requested coordinate.
+//   - size: The watermark size along the axis.
+//   - lower: The lower image bound along the axis.
+//   - upper: The upper image bound along the axis.
+//   - edges: Returns the lower and upper edges of an excluded rectangle along the axis.
+//
+// Returns:
+//   - The candidate coordinates keeping the watermark fully inside the image, or nil if it does not fit.
+func (m *exclusionMap) candidateCoordinates(requested, size, lower, upper int, edges func(image.Rectangle) (int, int)) []int {
+	maximum := upper - size
+	if maximum < lower {
+		return nil
+	}
+
+	clamp := func(v int) int {
+		return min(max(v, lower), maximum)
+	}
+
+	seen := make(map[int]bool)
+	var coordinates []int
+	add := func(v int) {
+		v = clamp(v)
+		if !seen[v] {
+			seen[v] = true
+			coordinates = append(coordinates, v)
+		}
+	}
+
+	add(requested)
+	add(lower)
+	add(maximum)
+
+	for _, rect := range m.exclusions.Rects {
+		start, end := edges(rect)
+		add(start - size)
+		add(end)
+	}
+
+	step := max(size/8, 1)
+	for v := lower; v <= maximum; v += step {
+		add(v)
+	}
+
+	return coordinates
+}
+
+// clip returns a copy of the watermark without the pixels that fall inside the excluded area.
+//
+// Parameters:
+//   - wm: The watermark to draw, with bounds starting at the origin.
+//   - pos: The top-left corner where it will be drawn.
+//
+// Returns:
+//   - A pointer to a new image.NRGBA containing the clipped watermark.
+func (m *exclusionMap) clip(wm *image.NRGBA, pos image.Point) *image.NRGBA {
+	return clipToMask(wm, pos, m.bounds, m.excluded)
+}
+
+// clipToMask returns a copy of the watermark without the pixels that fall on masked pixels of the input image.
+//
+// Parameters:
+//   - wm: The watermark to draw, with bounds starting at the origin.
+//   - pos: The top-left corner where it will be drawn.
+//   - bounds: The bounds of the input image covered by the mask.
+//   - masked: One value per input pixel, row by row, that is true where the watermark must not be drawn.
+//
+// Returns:
+//   - A pointer to a new image.NRGBA containing the clipped watermark.
+func clipToMask(wm *image.NRGBA, pos image.Point, bounds image.Rectangle, masked []bool) *image.NRGBA {
+	result := imaging.Clone(wm)
+	dr := image.Rectangle{Min: pos, Max: pos.Add(wm.Bounds().Size())}.Intersect(bounds)
+	width := bounds.Dx()
+
+	for y := dr.Min.Y; y < dr.Max.Y; y++ {
+		for x := dr.Min.X; x < dr.Max.X; x++ {
+			if masked[(y-bounds.Min.Y)*width+x-bounds.Min.X] {
+				result.Pix[(y-pos.Y)*result.Stride+(x-pos.X)*4+3] = 0
+			}
+		}
+	}
+
+	return result
+}
+
+// filter applies the exclusion mode to the positions returned by the placer.
+//
+// Parameters:
+//   - positions: The top-left corners returned by the placer.
+//   - size: The size of the watermark footprint.
+//
+// Returns:
+//   - The positions to draw, with relocated positions replaced and skipped ones removed.
+//   - A slice with one entry per returned position that is true when the watermark must be clipped.
+//   - The rejections, one per position that overlapped the excluded area.
+func (m *exclusionMap) filter(positions []image.Point, size image.Point) ([]image.Point, []bool, []Rejection) {
+	mode := m.exclusions.Mode
+	if mode == ExclusionAuto {
+		mode = ExclusionSkip
+		if len(positions) == 1 {
+			mode = ExclusionRelocate
+		}
+	}
+
+	var rejections []Rejection
+	kept := make([]image.Point, 0, len(positions))
+	clipped := make([]bool, 0, len(positions))
+
+	for _, pos := range positions {
+		rect := image.Rectangle{Min: pos, Max: pos.Add(size)}
+		if !m.overlaps(rect) {
+			kept = append(kept, pos)
+			clipped = append(clipped, false)
+			continue
+		}
+
+		rejection := Rejection{Position: pos, Zone: m.zone(rect)}
+
+		switch mode {
+		case ExclusionClip:
+			rejection.Reason = "clipped by the excluded area"
+			kept = append(kept, pos)
+			clipped = append(clipped, true)
+		case ExclusionRelocate:
+			if relocated, ok := m.relocate(pos, size); ok {
+				rejection.Reason = fmt.Sprintf("relocated to %v", relocated)
+				kept = append(kept, relocated)
+				clipped = append(clipped, false)
+			} else {
+				rejection.Reason = "skipped: no valid position outside the excluded area"
+			}
+		default:
+			rejection.Reason = "skipped: overlaps the excluded area"
+		}
+
+		rejections = append(rejections, rejection)
+	}
+
+	return kept, clipped, rejections
+}
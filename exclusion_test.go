@@ -0,0 +1,149 @@
+package imagewatermark
+
+import (
+	"image"
+	"image/color"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestExclusionMapRelocate(t *testing.T) {
+	bounds := image.Rect(0, 0, 100, 100)
+	size := image.Pt(20, 20)
+	white := color.NRGBA{R: 255, G: 255, B: 255, A: 255}
+	black := color.NRGBA{A: 255}
+
+	tests := []struct {
+		name       string
+		exclusions Exclusions
+		pos        image.Point
+		want       image.Point
+		wantOK     bool
+	}{
+		{"already valid", Exclusions{Rects: []image.Rectangle{image.Rect(30, 30, 70, 70)}}, image.Pt(5, 5), image.Pt(5, 5), true},
+		{"nearest edge", Exclusions{Rects: []image.Rectangle{image.Rect(30, 30, 60, 70)}}, image.Pt(45, 45), image.Pt(60, 45), true},
+		{"above", Exclusions{Rects: []image.Rectangle{image.Rect(0, 40, 100, 100)}}, image.Pt(50, 60), image.Pt(50, 20), true},
+		{"between two rectangles", Exclusions{Rects: []image.Rectangle{image.Rect(0, 0, 40, 100), image.Rect(60, 0, 100, 100)}}, image.Pt(30, 50), image.Pt(40, 50), true},
+		{"kept inside the image", Exclusions{Rects: []image.Rectangle{image.Rect(0, 0, 100, 50)}}, image.Pt(90, 40), image.Pt(80, 50), true},
+		{"gap too small", Exclusions{Rects: []image.Rectangle{image.Rect(0, 0, 45, 100), image.Rect(55, 0, 100, 100)}}, image.Pt(40, 40), image.Point{}, false},
+		{"mask", Exclusions{Mask: rowImage(black, black, white, white)}, image.Pt(10, 40), image.Pt(50, 40), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := newExclusionMap(tt.exclusions, bounds).relocate(tt.pos, size)
+			if ok != tt.wantOK || got != tt.want {
+				t.Errorf("relocate(%v) = %v, %v, want %v, %v", tt.pos, got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestApplyWithExclusions(t *testing.T) {
+	white := color.NRGBA{R: 255, G: 255, B: 255, A: 255}
+	red := color.NRGBA{R: 255, A: 255}
+	rects := []image.Rectangle{image.Rect(80, 80, 100, 100), image.Rect(30, 30, 60, 70)}
+
+	tests := []struct {
+		name          string
+		mode          ExclusionMode
+		positions     []image.Point
+		wantPositions []image.Point
+		wantReasons   []string
+		wantZones     []int
+		want          map[image.Point]color.NRGBA
+	}{
+		{
+			name:          "single position is relocated",
+			positions:     []image.Point{{45, 45}},
+			wantPositions: []image.Point{{60, 45}},
+			wantReasons:   []string{"relocated to (60,45)"},
+			wantZones:     []int{1},
+			want:          map[image.Point]color.NRGBA{{65, 50}: red, {50, 50}: white},
+		},
+		{
+			name:          "several positions are skipped",
+			positions:     []image.Point{{0, 0}, {45, 45}, {75, 75}},
+			wantPositions: []image.Point{{0, 0}},
+			wantReasons:   []string{"skipped", "skipped"},
+			wantZones:     []int{1, 0},
+			want:          map[image.Point]color.NRGBA{{5, 5}: red, {50, 50}: white, {78, 78}: white},
+		},
+		{
+			name:          "clipped",
+			mode:          ExclusionClip,
+			positions:     []image.Point{{20, 20}},
+			wantPositions: []image.Point{{20, 20}},
+			wantReasons:   []string{"clipped"},
+			wantZones:     []int{1},
+			want:          map[image.Point]color.NRGBA{{25, 25}: red, {29, 39}: red, {30, 30}: white, {39, 39}: white},
+		},
+		{
+			name:          "forced skip",
+			mode:          ExclusionSkip,
+			positions:     []image.Point{{45, 45}},
+			wantPositions: []image.Point{},
+			wantReasons:   []string{"skipped"},
+			wantZones:     []int{1},
+			want:          map[image.Point]color.NRGBA{{50, 50}: white, {65, 50}: white},
+		},
+		{
+			name:          "forced relocation in a grid",
+			mode:          ExclusionRelocate,
+			positions:     []image.Point{{0, 0}, {45, 45}},
+			wantPositions: []image.Point{{0, 0}, {60, 45}},
+			wantReasons:   []string{"relocated"},
+			wantZones:     []int{1},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			placer := PlacerFunc(func(image.Image, image.Rectangle) []image.Point { return tt.positions })
+			config := GeneralConfig{OpacityAlpha: 1, WatermarkWidthPercent: 20, Exclusions: &Exclusions{Rects: rects, Mode: tt.mode}}
+
+			result, report, err := ApplyWithReport(uniformImage(100, 100, white), uniformImage(20, 20, red), config, placer)
+			if err != nil {
+				t.Fatalf("ApplyWithReport: %v", err)
+			}
+
+			if !slices.Equal(report.Positions, tt.wantPositions) {
+				t.Errorf("report positions = %v, want %v", report.Positions, tt.wantPositions)
+			}
+			if len(report.Rejections) != len(tt.wantReasons) {
+				t.Fatalf("report rejections = %v, want %d", report.Rejections, len(tt.wantReasons))
+			}
+			for i, rejection := range report.Rejections {
+				if !strings.Contains(rejection.Reason, tt.wantReasons[i]) || rejection.Zone != tt.wantZones[i] {
+					t.Errorf("rejection %d = %+v, want zone %d and reason containing %q", i, rejection, tt.wantZones[i], tt.wantReasons[i])
+				}
+			}
+			for p, want := range tt.want {
+				if got := nrgbaAt(result, p.X, p.Y); got != want {
+					t.Errorf("pixel %v = %v, want %v", p, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestExclusionsValidate(t *testing.T) {
+	tests := []struct {
+		name       string
+		exclusions Exclusions
+		wantErr    string
+	}{
+		{"rectangles", Exclusions{Rects: []image.Rectangle{image.Rect(0, 0, 10, 10)}}, ""},
+		{"mask", Exclusions{Mask: image.NewGray(image.Rect(0, 0, 1, 1)), Mode: ExclusionClip}, ""},
+		{"empty", Exclusions{}, "at least one rectangle or a mask"},
+		{"empty rectangle", Exclusions{Rects: []image.Rectangle{image.Rect(0, 0, 10, 10), image.Rect(5, 5, 5, 10)}}, "exclusion rectangle 1 is empty"},
+		{"unknown mode", Exclusions{Rects: []image.Rectangle{image.Rect(0, 0, 10, 10)}, Mode: ExclusionRelocate + 1}, "unknown exclusion mode"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checkValidateError(t, tt.exclusions.validate(), tt.wantErr)
+		})
+	}
+}
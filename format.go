@@ -0,0 +1,234 @@
+package imagewatermark
+
+import (
+	"image"
+	"image/color"
+)
+
+// keepsInputFormat reports whether matchInputFormat converts results back to the pixel format of the image.
+//
+// Parameters:
+//   - img: The input image.
+//
+// Returns:
+//   - A bool that is true for Gray, Gray16, NRGBA, RGBA64, NRGBA64, YCbCr and CMYK images.
+func keepsInputFormat(img image.Image) bool {
+	switch img.(type) {
+	case *image.Gray, *image.Gray16, *image.NRGBA, *image.RGBA64, *image.NRGBA64, *image.YCbCr, *image.CMYK:
+		return true
+	default:
+		return false
+	}
+}
+
+// matchInputFormat converts a watermarked canvas back to the pixel format of the input image.
+//
+// Watermarks are always composited on an 8-bit RGBA canvas. Pixels that the watermark did not change are
+// copied from the input, so they keep their exact values (including 16-bit precision and the original
+// YCbCr or CMYK samples). For 16-bit formats, changed pixels get the 8-bit change made by the watermark
+// added to their original value, so gradients under the watermark stay smooth, but the change itself
+// is quantized to steps of 257 (one 8-bit level). Grayscale outputs keep only the luminance of colored watermarks.
+//
+// Parameters:
+//   - canvas: The watermarked canvas.
+//   - base: A copy of the canvas taken before any watermark was drawn.
+//   - inputImg: The input image, with the same bounds as the canvas.
+//
+// Returns:
+//   - An image of the same type as the input, or the canvas itself when the type is not supported.
+func matchInputFormat(canvas, base *image.RGBA, inputImg image.Image) image.Image {
+	bounds := canvas.Bounds()
+
+	// forEach calls fn for every pixel, with the offset of the pixel in the canvas and base,
+	// and whether the watermark changed it.
+	forEach := func(fn func(x, y, offset int, changed bool)) {
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+			offset := canvas.PixOffset(bounds.Min.X, y)
+			for x := bounds.Min.X; x < bounds.Max.X; x, offset = x+1, offset+4 {
+				c, b := canvas.Pix[offset:offset+4:offset+4], base.Pix[offset:offset+4:offset+4]
+				fn(x, y, offset, c[0] != b[0] || c[1] != b[1] || c[2] != b[2] || c[3] != b[3])
+			}
+		}
+	}
+
+	switch input := inputImg.(type) {
+	case *image.Gray:
+		result := image.NewGray(bounds)
+		forEach(func(x, y, offset int, changed bool) {
+			i := result.PixOffset(x, y)
+			if !changed {
+				result.Pix[i] = input.Pix[input.PixOffset(x, y)]
+				return
+			}
+			result.Pix[i] = uint8(luma16(canvas.Pix[offset:]) >> 8)
+		})
+		return result
+
+	case *image.Gray16:
+		result := image.NewGray16(bounds)
+		forEach(func(x, y, offset int, changed bool) {
+			i, j := result.PixOffset(x, y), input.PixOffset(x, y)
+			if !changed {
+				copy(result.Pix[i:i+2], input.Pix[j:j+2])
+				return
+			}
+			value := uint16At(input.Pix[j:])
+			value += luma16(canvas.Pix[offset:]) - luma16(base.Pix[offset:])
+			putUint16(result.Pix[i:], value)
+		})
+		return result
+
+	case *image.NRGBA:
+		result := image.NewNRGBA(bounds)
+		forEach(func(x, y, offset int, changed bool) {
+			i := result.PixOffset(x, y)
+			if !changed {
+				j := input.PixOffset(x, y)
+				copy(result.Pix[i:i+4], input.Pix[j:j+4])
+				return
+			}
+			c := color.NRGBAModel.Convert(rgbaAt(canvas.Pix[offset:])).(color.NRGBA)
+			result.Pix[i], result.Pix[i+1], result.Pix[i+2], result.Pix[i+3] = c.R, c.G, c.B, c.A
+		})
+		return result
+
+	case *image.RGBA64:
+		result := image.NewRGBA64(bounds)
+		forEach(func(x, y, offset int, changed bool) {
+			i, j := result.PixOffset(x, y), input.PixOffset(x, y)
+			copy(result.Pix[i:i+8], input.Pix[j:j+8])
+			if !changed {
+				return
+			}
+
+			alpha := uint16At(input.Pix[j+6:]) + 257*(int32(canvas.Pix[offset+3])-int32(base.Pix[offset+3]))
+			alpha = min(max(alpha, 0), 0xffff)
+			putUint16(result.Pix[i+6:], alpha)
+			for c := 0; c < 3; c++ {
+				value := uint16At(input.Pix[j+2*c:]) + 257*(int32(canvas.Pix[offset+c])-int32(base.Pix[offset+c]))
+				// Premultiplied channels cannot exceed the alpha.
+				putUint16(result.Pix[i+2*c:], min(value, alpha))
+			}
+		})
+		return result
+
+	case *image.NRGBA64:
+		result := image.NewNRGBA64(bounds)
+		forEach(func(x, y, offset int, changed bool) {
+			i, j := result.PixOffset(x, y), input.PixOffset(x, y)
+			copy(result.Pix[i:i+8], input.Pix[j:j+8])
+			if !changed {
+				return
+			}
+
+			c := color.NRGBA64Model.Convert(rgbaAt(canvas.Pix[offset:])).(color.NRGBA64)
+			b := color.NRGBA64Model.Convert(rgbaAt(base.Pix[offset:])).(color.NRGBA64)
+			deltas := [4]int32{
+				int32(c.R) - int32(b.R), int32(c.G) - int32(b.G), int32(c.B) - int32(b.B), int32(c.A) - int32(b.A),
+			}
+			for k, delta := range deltas {
+				putUint16(result.Pix[i+2*k:], uint16At(input.Pix[j+2*k:])+delta)
+			}
+		})
+		return result
+
+	case *image.YCbCr:
+		return matchYCbCr(canvas, input, forEach)
+
+	case *image.CMYK:
+		result := image.NewCMYK(bounds)
+		forEach(func(x, y, offset int, changed bool) {
+			i, j := result.PixOffset(x, y), input.PixOffset(x, y)
+			if !changed {
+				copy(result.Pix[i:i+4], input.Pix[j:j+4])
+				return
+			}
+			p := canvas.Pix[offset:]
+			cc, mm, yy, kk := color.RGBToCMYK(p[0], p[1], p[2])
+			result.Pix[i], result.Pix[i+1], result.Pix[i+2], result.Pix[i+3] = cc, mm, yy, kk
+		})
+		return result
+
+	default:
+		return canvas
+	}
+}
+
+// matchYCbCr converts a watermarked canvas to a YCbCr image with the subsampling of the input.
+//
+// Each chroma sample is copied from the input when none of the pixels it covers changed, and is
+// otherwise the average chroma of all the canvas pixels it covers.
+//
+// Parameters:
+//   - canvas: The watermarked canvas.
+//   - input: The input image.
+//   - forEach: Iterates over the canvas pixels, reporting whether the watermark changed them.
+//
+// Returns:
+//   - A pointer to an image.YCbCr with the bounds and subsample ratio of the input.
+func matchYCbCr(canvas *image.RGBA, input *image.YCbCr, forEach func(fn func(x, y, offset int, changed bool))) *image.YCbCr {
+	result := image.NewYCbCr(canvas.Bounds(), input.SubsampleRatio)
+
+	type chromaSum struct {
+		cb, cr, count int
+		changed       bool
+	}
+	sums := make([]chromaSum, len(result.Cb))
+
+	forEach(func(x, y, offset int, changed bool) {
+		yi, ci := result.YOffset(x, y), result.COffset(x, y)
+		p := canvas.Pix[offset:]
+		luma, cb, cr := color.RGBToYCbCr(p[0], p[1], p[2])
+
+		if changed {
+			result.Y[yi] = luma
+		} else {
+			result.Y[yi] = input.Y[input.YOffset(x, y)]
+		}
+
+		s := &sums[ci]
+		s.cb += int(cb)
+		s.cr += int(cr)
+		s.count++
+		s.changed = s.changed || changed
+	})
+
+	// Chroma samples shared by several pixels are visited once per pixel and always get the same value.
+	bounds := canvas.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			ci := result.COffset(x, y)
+			if s := sums[ci]; s.changed {
+				result.Cb[ci] = uint8((s.cb + s.count/2) / s.count)
+				result.Cr[ci] = uint8((s.cr + s.count/2) / s.count)
+			} else {
+				ij := input.COffset(x, y)
+				result.Cb[ci], result.Cr[ci] = input.Cb[ij], input.Cr[ij]
+			}
+		}
+	}
+
+	return result
+}
+
+// luma16 returns the 16-bit luminance of an RGBA pixel, using the weights of color.GrayModel.
+func luma16(p []uint8) int32 {
+	r, g, b := uint32(p[0])*257, uint32(p[1])*257, uint32(p[2])*257
+	return int32((19595*r + 38470*g + 7471*b + 1<<15) >> 16)
+}
+
+// rgbaAt returns the color of an RGBA pixel.
+func rgbaAt(p []uint8) color.RGBA {
+	return color.RGBA{R: p[0], G: p[1], B: p[2], A: p[3]}
+}
+
+// uint16At reads a big-endian 16-bit sample.
+func uint16At(p []uint8) int32 {
+	return int32(p[0])<<8 | int32(p[1])
+}
+
+// putUint16 writes a big-endian 16-bit sample, clamping the value to [0, 65535].
+func putUint16(p []uint8, value int32) {
+	value = min(max(value, 0), 0xffff)
+	p[0], p[1] = uint8(value>>8), uint8(value)
+}
@@ -0,0 +1,172 @@
+package imagewatermark
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"testing"
+)
+
+func TestApplyKeepsInputFormat(t *testing.T) {
+	rect := image.Rect(0, 0, 40, 40)
+	// fill paints every pixel of an image with a slightly different color, so copied pixels can be told apart.
+	fill := func(img draw.Image) image.Image {
+		for y := 0; y < 40; y++ {
+			for x := 0; x < 40; x++ {
+				img.Set(x, y, color.NRGBA64{R: uint16(0x4000 + x*301), G: uint16(0x8000 + y*257 + 3), B: 0x2345, A: 0xffff})
+			}
+		}
+		return img
+	}
+	ycbcr := image.NewYCbCr(rect, image.YCbCrSubsampleRatio420)
+	for i := range ycbcr.Y {
+		ycbcr.Y[i] = uint8(i)
+	}
+	for i := range ycbcr.Cb {
+		ycbcr.Cb[i], ycbcr.Cr[i] = uint8(100+i%50), uint8(150-i%50)
+	}
+
+	tests := []struct {
+		name      string
+		input     image.Image
+		forceRGBA bool
+		want      string
+	}{
+		{"gray", fill(image.NewGray(rect)), false, "*image.Gray"},
+		{"gray16", fill(image.NewGray16(rect)), false, "*image.Gray16"},
+		{"nrgba", fill(image.NewNRGBA(rect)), false, "*image.NRGBA"},
+		{"rgba64", fill(image.NewRGBA64(rect)), false, "*image.RGBA64"},
+		{"nrgba64", fill(image.NewNRGBA64(rect)), false, "*image.NRGBA64"},
+		{"ycbcr", ycbcr, false, "*image.YCbCr"},
+		{"cmyk", fill(image.NewCMYK(rect)), false, "*image.CMYK"},
+		{"rgba", fill(image.NewRGBA(rect)), false, "*image.RGBA"},
+		{"paletted", image.NewPaletted(rect, color.Palette{color.White, color.Black}), false, "*image.RGBA"},
+		{"forced rgba", fill(image.NewGray16(rect)), true, "*image.RGBA"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := SingleConfig{
+				GeneralConfig: GeneralConfig{OpacityAlpha: 0.5, WatermarkWidthPercent: 25, ForceRGBA: tt.forceRGBA},
+				Placement:     PlacementAbsolute,
+				Position:      image.Pt(10, 10),
+			}
+			result, err := ApplySingle(tt.input, uniformImage(10, 10, color.NRGBA{R: 255, A: 255}), config)
+			if err != nil {
+				t.Fatalf("ApplySingle: %v", err)
+			}
+
+			if got := fmt.Sprintf("%T", result); got != tt.want {
+				t.Fatalf("ApplySingle() returned %s, want %s", got, tt.want)
+			}
+			if result.Bounds() != tt.input.Bounds() {
+				t.Errorf("result bounds = %v, want %v", result.Bounds(), tt.input.Bounds())
+			}
+
+			// Pixels away from the watermark keep their exact value, including 16-bit precision.
+			for _, p := range []image.Point{{0, 0}, {35, 5}, {39, 39}} {
+				got := color.RGBA64Model.Convert(result.At(p.X, p.Y))
+				want := color.RGBA64Model.Convert(tt.input.At(p.X, p.Y))
+				if tt.forceRGBA {
+					want = color.RGBA64Model.Convert(color.RGBAModel.Convert(want))
+				}
+				if got != want {
+					t.Errorf("pixel %v = %v, want %v", p, got, want)
+				}
+			}
+
+			// Pixels under the watermark changed.
+			if got, before := nrgbaAt(result, 15, 15), nrgbaAt(tt.input, 15, 15); got == before {
+				t.Errorf("pixel under the watermark = %v, want it changed", got)
+			}
+		})
+	}
+}
+
+func TestMatchInputFormat16BitDelta(t *testing.T) {
+	rect := image.Rect(0, 0, 4, 1)
+
+	tests := []struct {
+		name  string
+		input draw.Image
+	}{
+		{"gray16", image.NewGray16(rect)},
+		{"nrgba64", image.NewNRGBA64(rect)},
+		{"rgba64", image.NewRGBA64(rect)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// A smooth 16-bit ramp that 8 bits cannot represent.
+			for x := 0; x < 4; x++ {
+				tt.input.Set(x, 0, color.Gray16{Y: uint16(0x8000 + x*17)})
+			}
+
+			canvas := generateBaseCanvas(tt.input)
+			base := cloneRGBA(canvas)
+			// Brighten the last two pixels by 16 levels, as a light watermark would.
+			for _, x := range []int{2, 3} {
+				p := canvas.Pix[canvas.PixOffset(x, 0):]
+				p[0], p[1], p[2] = p[0]+16, p[1]+16, p[2]+16
+			}
+
+			result := matchInputFormat(canvas, base, tt.input)
+			for x := 0; x < 4; x++ {
+				want := uint32(0x8000 + x*17)
+				if x >= 2 {
+					want += 16 * 257
+				}
+				r, _, _, _ := result.At(x, 0).RGBA()
+				if diff := int(r) - int(want); diff < -1 || diff > 1 {
+					t.Errorf("pixel %d = %#x, want %#x", x, r, want)
+				}
+			}
+		})
+	}
+}
+
+func TestApply16BitWatermarkIn8BitSteps(t *testing.T) {
+	rect := image.Rect(0, 0, 40, 40)
+	watermark := uniformImage(10, 10, color.NRGBA{R: 255, G: 255, B: 255, A: 255})
+	config := SingleConfig{GeneralConfig: GeneralConfig{OpacityAlpha: 0.5, WatermarkWidthPercent: 50}}
+
+	tests := []struct {
+		name  string
+		input draw.Image
+	}{
+		{"gray16", image.NewGray16(rect)},
+		{"nrgba64", image.NewNRGBA64(rect)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// 0x8040 is not a whole 8-bit level, so the input keeps 16-bit precision.
+			draw.Draw(tt.input, rect, image.NewUniform(color.Gray16{Y: 0x8040}), image.Point{}, draw.Src)
+
+			result, err := ApplySingle(tt.input, watermark, config)
+			if err != nil {
+				t.Fatalf("ApplySingle: %v", err)
+			}
+
+			changed := 0
+			for y := rect.Min.Y; y < rect.Max.Y; y++ {
+				for x := rect.Min.X; x < rect.Max.X; x++ {
+					r, _, _, _ := result.At(x, y).RGBA()
+					delta := int(r) - 0x8040
+					if delta == 0 {
+						continue
+					}
+					changed++
+					// The watermark is composited at 8 bits, so it changes pixels by whole 8-bit levels.
+					if delta%257 != 0 {
+						t.Fatalf("pixel (%d, %d) changed by %d, want a multiple of 257", x, y, delta)
+					}
+				}
+			}
+			if changed == 0 {
+				t.Error("no pixel changed, want the watermark drawn")
+			}
+		})
+	}
+}
@@ -0,0 +1,845 @@
+package imagewatermark
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"math"
+	"sort"
+)
+
+// iccD50 is the D50 white point of the ICC profile connection space.
+var iccD50 = [3]float64{0.9642, 1.0, 0.8249}
+
+// iccXYZToLinearSRGB converts D50 XYZ to linear sRGB, with the Bradford adaptation to D65 included.
+var iccXYZToLinearSRGB = [3][3]float64{
+	{3.1338561, -1.6168667, -0.4906146},
+	{-0.9787684, 1.9161415, 0.0334540},
+	{0.0719453, -0.2289914, 1.4052427},
+}
+
+// iccProfile is the part of an embedded ICC color profile needed to convert device colors to sRGB.
+//
+// Fields:
+//   - colorSpace: The device color space signature, such as "RGB " or "CMYK".
+//   - toXYZ: Converts device values in [0, 1] (ink amounts for CMYK) to D50 XYZ, where white has Y = 1.
+//   - curves: The tone curves of a matrix/TRC profile, or nil entries for a lookup table profile.
+//   - matrix: The colorant matrix of a matrix/TRC profile, converting linear device values to D50 XYZ.
+type iccProfile struct {
+	colorSpace string
+	toXYZ      func(device []float64) [3]float64
+	curves     [3]iccCurve
+	matrix     [3][3]float64
+}
+
+// iccMaxChannels is the largest number of device channels of a supported profile (CMYK).
+const iccMaxChannels = 4
+
+// iccCurve maps a value in [0, 1] to a value in [0, 1].
+type iccCurve func(float64) float64
+
+// parseICCProfile parses an ICC profile, using its A2B0 (or A2B1) lookup table when present
+// and its matrix and tone curves for RGB profiles otherwise.
+//
+// Parameters:
+//   - data: The complete profile.
+//
+// Returns:
+//   - A pointer to the iccProfile.
+//   - An error if the profile is malformed or uses features that are not supported.
+func parseICCProfile(data []byte) (*iccProfile, error) {
+	if len(data) < 132 || string(data[36:40]) != "acsp" {
+		return nil, errors.New("not an icc profile")
+	}
+
+	colorSpace, pcs := string(data[16:20]), string(data[20:24])
+	if pcs != "XYZ " && pcs != "Lab " {
+		return nil, fmt.Errorf("unsupported icc connection space: %q", pcs)
+	}
+
+	tags := make(map[string][]byte)
+	count := int(binary.BigEndian.Uint32(data[128:132]))
+	for i := 0; i < count; i++ {
+		entry := 132 + i*12
+		if entry+12 > len(data) {
+			return nil, errors.New("truncated icc tag table")
+		}
+		offset := int(binary.BigEndian.Uint32(data[entry+4 : entry+8]))
+		size := int(binary.BigEndian.Uint32(data[entry+8 : entry+12]))
+		if offset < 0 || size < 0 || offset+size > len(data) {
+			return nil, errors.New("icc tag out of bounds")
+		}
+		tags[string(data[entry:entry+4])] = data[offset : offset+size]
+	}
+
+	channels := map[string]int{"RGB ": 3, "CMYK": 4, "GRAY": 1}[colorSpace]
+	if channels == 0 {
+		return nil, fmt.Errorf("unsupported icc color space: %q", colorSpace)
+	}
+
+	for _, name := range []string{"A2B0", "A2B1"} {
+		if tag, ok := tags[name]; ok {
+			lut, pcsEncoding, err := parseICCLut(tag, channels)
+			if err != nil {
+				return nil, err
+			}
+
+			return &iccProfile{colorSpace: colorSpace, toXYZ: func(device []float64) [3]float64 {
+				return decodePCS(lut(device), pcs == "Lab ", pcsEncoding)
+			}}, nil
+		}
+	}
+
+	if colorSpace != "RGB " {
+		return nil, errors.New("icc profile has no lookup table")
+	}
+
+	var matrix [3][3]float64
+	var curves [3]iccCurve
+	for c, name := range []string{"r", "g", "b"} {
+		column, ok := tags[name+"XYZ"]
+		if !ok || len(column) < 20 {
+			return nil, errors.New("icc profile has no colorant matrix")
+		}
+		for row := 0; row < 3; row++ {
+			matrix[row][c] = s15Fixed16(column[8+4*row:])
+		}
+
+		curve, _, err := parseICCCurve(tags[name+"TRC"])
+		if err != nil {
+			return nil, err
+		}
+		curves[c] = curve
+	}
+
+	return &iccProfile{colorSpace: colorSpace, curves: curves, matrix: matrix, toXYZ: func(device []float64) [3]float64 {
+		var xyz [3]float64
+		for c, curve := range curves {
+			v := curve(device[c])
+			for row := range xyz {
+				xyz[row] += matrix[row][c] * v
+			}
+		}
+		return xyz
+	}}, nil
+}
+
+// s15Fixed16 decodes an ICC signed 15.16 fixed-point number.
+func s15Fixed16(p []byte) float64 {
+	return float64(int32(binary.BigEndian.Uint32(p))) / 65536
+}
+
+// parseICCCurve parses a "curv" or "para" tone curve.
+//
+// Parameters:
+//   - data: The curve, starting with its type signature.
+//
+// Returns:
+//   - The curve.
+//   - The size of the curve in bytes, before padding.
+//   - An error if the curve is malformed or of another type.
+func parseICCCurve(data []byte) (iccCurve, int, error) {
+	if len(data) < 12 {
+		return nil, 0, errors.New("truncated icc curve")
+	}
+
+	switch string(data[:4]) {
+	case "curv":
+		n := int(binary.BigEndian.Uint32(data[8:12]))
+		size := 12 + 2*n
+		if size > len(data) {
+			return nil, 0, errors.New("truncated icc curve")
+		}
+
+		switch n {
+		case 0:
+			return func(x float64) float64 { return x }, size, nil
+		case 1:
+			gamma := float64(binary.BigEndian.Uint16(data[12:14])) / 256
+			return func(x float64) float64 { return math.Pow(x, gamma) }, size, nil
+		}
+
+		table := make([]float64, n)
+		for i := range table {
+			table[i] = float64(binary.BigEndian.Uint16(data[12+2*i:])) / 0xffff
+		}
+		return func(x float64) float64 { return interpolateTable(table, x) }, size, nil
+
+	case "para":
+		counts := []int{1, 3, 4, 5, 7}
+		kind := int(binary.BigEndian.Uint16(data[8:10]))
+		if kind >= len(counts) || 12+4*counts[kind] > len(data) {
+			return nil, 0, errors.New("invalid icc parametric curve")
+		}
+
+		var p [7]float64
+		for i := 0; i < counts[kind]; i++ {
+			p[i] = s15Fixed16(data[12+4*i:])
+		}
+		g, a, b, c, d, e, f := p[0], p[1], p[2], p[3], p[4], p[5], p[6]
+
+		power := func(x float64) float64 {
+			if v := a*x + b; v > 0 {
+				return math.Pow(v, g)
+			}
+			return 0
+		}
+
+		curve := func(x float64) float64 {
+			switch kind {
+			case 0:
+				return math.Pow(x, g)
+			case 1:
+				if x >= -b/a {
+					return power(x)
+				}
+				return 0
+			case 2:
+				if x >= -b/a {
+					return power(x) + c
+				}
+				return c
+			case 3:
+				if x >= d {
+					return power(x)
+				}
+				return c * x
+			default:
+				if x >= d {
+					return power(x) + e
+				}
+				return c*x + f
+			}
+		}
+		return curve, 12 + 4*counts[kind], nil
+
+	default:
+		return nil, 0, fmt.Errorf("unsupported icc curve type: %q", data[:4])
+	}
+}
+
+// interpolateTable evaluates a uniformly sampled table at x in [0, 1] with linear interpolation.
+func interpolateTable(table []float64, x float64) float64 {
+	if len(table) == 1 {
+		return table[0]
+	}
+
+	position := min(max(x, 0), 1) * float64(len(table)-1)
+	i := min(int(position), len(table)-2)
+	fraction := position - float64(i)
+
+	return table[i]*(1-fraction) + table[i+1]*fraction
+}
+
+// pcsEncoding identifies how a lookup table encodes its connection space values.
+type pcsEncoding int
+
+const (
+	// pcsLegacy16 is the 16-bit encoding of ICC version 2 (lut16Type), where 0xFF00 is the maximum.
+	pcsLegacy16 pcsEncoding = iota
+	// pcsFull is the encoding of lut8Type and lutAtoBType, where the full range is used.
+	pcsFull
+)
+
+// decodePCS converts the normalized output of a lookup table to D50 XYZ.
+//
+// Parameters:
+//   - v: The normalized output values.
+//   - lab: Whether the connection space is CIELAB (otherwise XYZ).
+//   - encoding: The encoding of the lookup table.
+//
+// Returns:
+//   - The D50 XYZ values.
+func decodePCS(v [3]float64, lab bool, encoding pcsEncoding) [3]float64 {
+	if !lab {
+		// XYZ is encoded as u1Fixed15 numbers, where 0x8000 is 1.0.
+		for i := range v {
+			v[i] *= 65535.0 / 32768
+		}
+		return v
+	}
+
+	scale := 1.0
+	if encoding == pcsLegacy16 {
+		scale = 65535.0 / 65280
+	}
+	l, a, b := v[0]*scale*100, v[1]*scale*255-128, v[2]*scale*255-128
+
+	fy := (l + 16) / 116
+	f := [3]float64{fy + a/500, fy, fy - b/200}
+	var xyz [3]float64
+	for i, t := range f {
+		if t > 6.0/29 {
+			xyz[i] = t * t * t
+		} else {
+			xyz[i] = 3 * (6.0 / 29) * (6.0 / 29) * (t - 4.0/29)
+		}
+		xyz[i] *= iccD50[i]
+	}
+
+	return xyz
+}
+
+// parseICCLut parses an A2B lookup table of type "mft1", "mft2" or "mAB ".
+//
+// Parameters:
+//   - data: The tag, starting with its type signature.
+//   - channels: The number of device channels.
+//
+// Returns:
+//   - A function converting normalized device values to normalized connection space values.
+//   - The connection space encoding of the table.
+//   - An error if the table is malformed or does not match the channels.
+func parseICCLut(data []byte, channels int) (func([]float64) [3]float64, pcsEncoding, error) {
+	if len(data) < 32 {
+		return nil, 0, errors.New("truncated icc lookup table")
+	}
+
+	inputs, outputs := int(data[8]), int(data[9])
+	if inputs != channels || outputs != 3 {
+		return nil, 0, fmt.Errorf("unsupported icc lookup table with %d inputs and %d outputs", inputs, outputs)
+	}
+
+	switch string(data[:4]) {
+	case "mft1", "mft2":
+		return parseICCLut16(data, inputs)
+	case "mAB ":
+		lut, err := parseICCLutAtoB(data, inputs)
+		return lut, pcsFull, err
+	default:
+		return nil, 0, fmt.Errorf("unsupported icc lookup table type: %q", data[:4])
+	}
+}
+
+// parseICCLut16 parses a "mft1" (8-bit) or "mft2" (16-bit) lookup table.
+func parseICCLut16(data []byte, inputs int) (func([]float64) [3]float64, pcsEncoding, error) {
+	grid := int(data[10])
+	sample, inEntries, outEntries, offset, encoding := 1, 256, 256, 48, pcsFull
+	if string(data[:4]) == "mft2" {
+		if len(data) < 52 {
+			return nil, 0, errors.New("truncated icc lookup table")
+		}
+		sample, encoding = 2, pcsLegacy16
+		inEntries = int(binary.BigEndian.Uint16(data[48:50]))
+		outEntries = int(binary.BigEndian.Uint16(data[50:52]))
+		offset = 52
+	}
+
+	clutSize := 3
+	for i := 0; i < inputs; i++ {
+		clutSize *= grid
+	}
+
+	if grid < 2 || inEntries < 2 || outEntries < 2 ||
+		offset+sample*(inputs*inEntries+clutSize+3*outEntries) > len(data) {
+		return nil, 0, errors.New("invalid icc lookup table size")
+	}
+
+	read := func(count int) []float64 {
+		values := make([]float64, count)
+		for i := range values {
+			if sample == 2 {
+				values[i] = float64(binary.BigEndian.Uint16(data[offset+2*i:])) / 0xffff
+			} else {
+				values[i] = float64(data[offset+i]) / 0xff
+			}
+		}
+		offset += sample * count
+		return values
+	}
+
+	inTables := make([][]float64, inputs)
+	for i := range inTables {
+		inTables[i] = read(inEntries)
+	}
+	grids := make([]int, inputs)
+	for i := range grids {
+		grids[i] = grid
+	}
+	clut := iccCLUT{grid: grids, values: read(clutSize)}
+	var outTables [3][]float64
+	for i := range outTables {
+		outTables[i] = read(outEntries)
+	}
+
+	return func(device []float64) [3]float64 {
+		var in [iccMaxChannels]float64
+		for i := 0; i < inputs; i++ {
+			in[i] = interpolateTable(inTables[i], device[i])
+		}
+		out := clut.lookup(in[:inputs])
+		for i := range out {
+			out[i] = interpolateTable(outTables[i], out[i])
+		}
+		return out
+	}, encoding, nil
+}
+
+// parseICCLutAtoB parses a "mAB " lookup table, applied as A curves, CLUT, M curves, matrix and B curves.
+func parseICCLutAtoB(data []byte, inputs int) (func([]float64) [3]float64, error) {
+	offsetAt := func(i int) int { return int(binary.BigEndian.Uint32(data[12+4*i:])) }
+	bOffset, matrixOffset, mOffset, clutOffset, aOffset := offsetAt(0), offsetAt(1), offsetAt(2), offsetAt(3), offsetAt(4)
+
+	readCurves := func(offset, count int) ([]iccCurve, error) {
+		if offset == 0 {
+			return nil, nil
+		}
+		curves := make([]iccCurve, count)
+		for i := range curves {
+			if offset >= len(data) {
+				return nil, errors.New("truncated icc curves")
+			}
+			curve, size, err := parseICCCurve(data[offset:])
+			if err != nil {
+				return nil, err
+			}
+			curves[i] = curve
+			offset += (size + 3) &^ 3
+		}
+		return curves, nil
+	}
+
+	aCurves, err := readCurves(aOffset, inputs)
+	if err != nil {
+		return nil, err
+	}
+	mCurves, err := readCurves(mOffset, 3)
+	if err != nil {
+		return nil, err
+	}
+	bCurves, err := readCurves(bOffset, 3)
+	if err != nil {
+		return nil, err
+	}
+
+	var clut *iccCLUT
+	if clutOffset != 0 {
+		if clutOffset+20 > len(data) {
+			return nil, errors.New("truncated icc clut")
+		}
+		grids := make([]int, inputs)
+		size := 3
+		for i := range grids {
+			grids[i] = int(data[clutOffset+i])
+			size *= grids[i]
+		}
+		precision := int(data[clutOffset+16])
+		if precision != 1 && precision != 2 || clutOffset+20+precision*size > len(data) || size == 0 {
+			return nil, errors.New("invalid icc clut")
+		}
+
+		values := make([]float64, size)
+		for i := range values {
+			if precision == 2 {
+				values[i] = float64(binary.BigEndian.Uint16(data[clutOffset+20+2*i:])) / 0xffff
+			} else {
+				values[i] = float64(data[clutOffset+20+i]) / 0xff
+			}
+		}
+		clut = &iccCLUT{grid: grids, values: values}
+	} else if inputs != 3 {
+		return nil, errors.New("icc lookup table without clut")
+	}
+
+	var matrix []float64
+	if matrixOffset != 0 {
+		if matrixOffset+48 > len(data) {
+			return nil, errors.New("truncated icc matrix")
+		}
+		matrix = make([]float64, 12)
+		for i := range matrix {
+			matrix[i] = s15Fixed16(data[matrixOffset+4*i:])
+		}
+	}
+
+	return func(device []float64) [3]float64 {
+		var in [iccMaxChannels]float64
+		copy(in[:inputs], device)
+		for i, curve := range aCurves {
+			in[i] = curve(in[i])
+		}
+
+		var out [3]float64
+		if clut != nil {
+			out = clut.lookup(in[:inputs])
+		} else {
+			copy(out[:], in[:inputs])
+		}
+
+		for i, curve := range mCurves {
+			out[i] = curve(out[i])
+		}
+		if matrix != nil {
+			var mixed [3]float64
+			for row := range mixed {
+				mixed[row] = matrix[3*row]*out[0] + matrix[3*row+1]*out[1] + matrix[3*row+2]*out[2] + matrix[9+row]
+			}
+			out = mixed
+		}
+		for i, curve := range bCurves {
+			out[i] = curve(out[i])
+		}
+
+		return out
+	}, nil
+}
+
+// iccCLUT is a multi-dimensional color lookup table with three outputs.
+//
+// Fields:
+//   - grid: The number of grid points along each input dimension.
+//   - values: The output values, with the last input varying fastest.
+type iccCLUT struct {
+	grid   []int
+	values []float64
+}
+
+// lookup evaluates the table at the given normalized inputs with multilinear interpolation.
+func (t iccCLUT) lookup(in []float64) [3]float64 {
+	n := len(t.grid)
+	var base, strides [iccMaxChannels]int
+	var fraction [iccMaxChannels]float64
+
+	stride := 3
+	for i := n - 1; i >= 0; i-- {
+		strides[i] = stride
+		stride *= t.grid[i]
+
+		position := min(max(in[i], 0), 1) * float64(t.grid[i]-1)
+		base[i] = min(int(position), max(t.grid[i]-2, 0))
+		fraction[i] = position - float64(base[i])
+	}
+
+	var out [3]float64
+	for corner := 0; corner < 1<<n; corner++ {
+		weight, index := 1.0, 0
+		for i := 0; i < n; i++ {
+			if corner&(1<<i) != 0 {
+				if t.grid[i] < 2 {
+					weight = 0
+					break
+				}
+				weight *= fraction[i]
+				index += (base[i] + 1) * strides[i]
+			} else {
+				weight *= 1 - fraction[i]
+				index += base[i] * strides[i]
+			}
+		}
+
+		if weight == 0 {
+			continue
+		}
+		for c := range out {
+			out[c] += weight * t.values[index+c]
+		}
+	}
+
+	return out
+}
+
+// toSRGB converts device values to 8-bit sRGB through the profile.
+func (p *iccProfile) toSRGB(device []float64) (uint8, uint8, uint8) {
+	xyz := p.toXYZ(device)
+	luts := linearLUTs()
+
+	var rgb [3]uint8
+	for row, coefficients := range iccXYZToLinearSRGB {
+		rgb[row] = luts.toSRGB(coefficients[0]*xyz[0] + coefficients[1]*xyz[1] + coefficients[2]*xyz[2])
+	}
+
+	return rgb[0], rgb[1], rgb[2]
+}
+
+// convert converts an image whose colors are described by the profile to sRGB.
+//
+// CMYK images require a CMYK profile, and other color images an RGB profile. 16-bit RGB images (image.RGBA64
+// and image.NRGBA64) are converted at 16 bits, and other images at 8 bits.
+//
+// Parameters:
+//   - img: The image to convert.
+//
+// Returns:
+//   - A new image.NRGBA64 for 16-bit images, or a new image.NRGBA otherwise, with the same bounds; or nil when
+//     the profile does not apply to the image or leaves every color unchanged.
+func (p *iccProfile) convert(img image.Image) image.Image {
+	bounds := img.Bounds()
+	luts := linearLUTs()
+
+	if cmyk, ok := img.(*image.CMYK); ok {
+		if p.colorSpace != "CMYK" {
+			return nil
+		}
+
+		transform := p.newTransform(0xff)
+		result := image.NewNRGBA(bounds)
+
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+			for x := bounds.Min.X; x < bounds.Max.X; x++ {
+				s := cmyk.Pix[cmyk.PixOffset(x, y):]
+				rgb := transform.linear([iccMaxChannels]uint16{uint16(s[0]), uint16(s[1]), uint16(s[2]), uint16(s[3])})
+
+				d := result.Pix[result.PixOffset(x, y):]
+				d[0], d[1], d[2], d[3] = luts.toSRGB(rgb[0]), luts.toSRGB(rgb[1]), luts.toSRGB(rgb[2]), 255
+			}
+		}
+
+		return result
+	}
+
+	if p.colorSpace != "RGB " || img.ColorModel() == color.GrayModel || img.ColorModel() == color.Gray16Model {
+		return nil
+	}
+
+	// Images tagged with an sRGB profile are already in the output color space.
+	if p.isSRGB() {
+		return nil
+	}
+
+	switch img.(type) {
+	case *image.RGBA64, *image.NRGBA64:
+		transform := p.newTransform(0xffff)
+		result := image.NewNRGBA64(bounds)
+
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+			dstRow := result.Pix[(y-bounds.Min.Y)*result.Stride:]
+			for x := bounds.Min.X; x < bounds.Max.X; x++ {
+				c := nrgba64At(img, x, y)
+				rgb := transform.linear([iccMaxChannels]uint16{c.R, c.G, c.B})
+
+				d := dstRow[(x-bounds.Min.X)*8 : (x-bounds.Min.X)*8+8 : (x-bounds.Min.X)*8+8]
+				for i, v := range [4]uint16{linearToSRGB16(rgb[0]), linearToSRGB16(rgb[1]), linearToSRGB16(rgb[2]), c.A} {
+					d[2*i], d[2*i+1] = uint8(v>>8), uint8(v)
+				}
+			}
+		}
+
+		return result
+	}
+
+	src := toNRGBA(img)
+	transform := p.newTransform(0xff)
+	result := image.NewNRGBA(bounds)
+
+	for y := 0; y < bounds.Dy(); y++ {
+		srcRow := src.Pix[y*src.Stride:]
+		dstRow := result.Pix[y*result.Stride:]
+
+		for x := 0; x < bounds.Dx(); x++ {
+			s := srcRow[x*4 : x*4+4 : x*4+4]
+			rgb := transform.linear([iccMaxChannels]uint16{uint16(s[0]), uint16(s[1]), uint16(s[2])})
+
+			d := dstRow[x*4 : x*4+4 : x*4+4]
+			d[0], d[1], d[2], d[3] = luts.toSRGB(rgb[0]), luts.toSRGB(rgb[1]), luts.toSRGB(rgb[2]), s[3]
+		}
+	}
+
+	return result
+}
+
+// iccCacheBits is the base-2 logarithm of the number of entries of iccTransform.cache.
+const iccCacheBits = 12
+
+// iccCacheEntry is a converted device color in iccTransform.cache.
+//
+// Fields:
+//   - key: The packed device samples with the top bit set, or zero for an empty entry.
+//   - rgb: The linear sRGB values of the color.
+type iccCacheEntry struct {
+	key uint64
+	rgb [3]float64
+}
+
+// iccTransform converts the device colors of one image to linear sRGB without allocating per pixel.
+//
+// Matrix/TRC profiles use a table per channel with the linear value of every sample, followed by a single
+// matrix from linear device values to linear sRGB. Lookup table profiles, which are costly to evaluate,
+// keep recent colors in a fixed-size direct-mapped cache, so graphics and flat areas are converted once
+// per color while the memory does not grow with the number of distinct colors.
+//
+// Fields:
+//   - profile: The profile describing the device colors.
+//   - maxSample: The largest device sample, 0xff or 0xffff.
+//   - trc: The linear value of each sample per channel, for matrix/TRC profiles.
+//   - matrix: The conversion from linear device values to linear sRGB, for matrix/TRC profiles.
+//   - device: The normalized device values passed to the profile.
+//   - cache: The recently converted colors, for lookup table profiles.
+type iccTransform struct {
+	profile   *iccProfile
+	maxSample int
+	trc       [3][]float64
+	matrix    [3][3]float64
+	device    []float64
+	cache     []iccCacheEntry
+}
+
+// newTransform prepares the conversion of device samples from 0 to maxSample.
+func (p *iccProfile) newTransform(maxSample int) *iccTransform {
+	t := &iccTransform{profile: p, maxSample: maxSample}
+
+	if p.curves[0] == nil {
+		t.device = make([]float64, iccMaxChannels)
+		t.cache = make([]iccCacheEntry, 1<<iccCacheBits)
+		return t
+	}
+
+	for c, curve := range p.curves {
+		t.trc[c] = make([]float64, maxSample+1)
+		for v := range t.trc[c] {
+			t.trc[c][v] = curve(float64(v) / float64(maxSample))
+		}
+	}
+
+	for row, coefficients := range iccXYZToLinearSRGB {
+		for col := range t.matrix[row] {
+			for i, coefficient := range coefficients {
+				t.matrix[row][col] += coefficient * p.matrix[i][col]
+			}
+		}
+	}
+
+	return t
+}
+
+// linear converts device samples to linear sRGB.
+//
+// Parameters:
+//   - samples: The device samples, from 0 to maxSample, of the channels of the profile.
+//
+// Returns:
+//   - The linear sRGB values, which may fall outside [0, 1] for colors out of the sRGB gamut.
+func (t *iccTransform) linear(samples [iccMaxChannels]uint16) [3]float64 {
+	var rgb [3]float64
+
+	if t.trc[0] != nil {
+		r, g, b := t.trc[0][samples[0]], t.trc[1][samples[1]], t.trc[2][samples[2]]
+		for row, coefficients := range t.matrix {
+			rgb[row] = coefficients[0]*r + coefficients[1]*g + coefficients[2]*b
+		}
+		return rgb
+	}
+
+	key := 1<<63 | uint64(samples[0])<<48 | uint64(samples[1])<<32 | uint64(samples[2])<<16 | uint64(samples[3])
+	entry := &t.cache[(key*0x9e3779b97f4a7c15)>>(64-iccCacheBits)]
+	if entry.key == key {
+		return entry.rgb
+	}
+
+	for c := range t.device {
+		t.device[c] = float64(samples[c]) / float64(t.maxSample)
+	}
+	xyz := t.profile.toXYZ(t.device)
+	for row, coefficients := range iccXYZToLinearSRGB {
+		rgb[row] = coefficients[0]*xyz[0] + coefficients[1]*xyz[1] + coefficients[2]*xyz[2]
+	}
+
+	entry.key, entry.rgb = key, rgb
+	return rgb
+}
+
+// isSRGB reports whether an RGB profile maps every primary and gray ramp value to itself in sRGB,
+// so that images tagged with an sRGB profile are left untouched.
+func (p *iccProfile) isSRGB() bool {
+	device := make([]float64, 3)
+
+	for v := 0; v < 256; v++ {
+		for _, mask := range [][3]bool{{true, false, false}, {false, true, false}, {false, false, true}, {true, true, true}} {
+			var want [3]uint8
+			for c := range device {
+				device[c] = 0
+				if mask[c] {
+					device[c] = float64(v) / 255
+					want[c] = uint8(v)
+				}
+			}
+
+			r, g, b := p.toSRGB(device)
+			if absDiff(r, want[0]) > 1 || absDiff(g, want[1]) > 1 || absDiff(b, want[2]) > 1 {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// absDiff returns the absolute difference of two bytes.
+func absDiff(a, b uint8) uint8 {
+	if a > b {
+		return a - b
+	}
+	return b - a
+}
+
+// readJPEGICCProfile reassembles the ICC profile stored in the APP2 segments of a JPEG file.
+//
+// Parameters:
+//   - r: A reader positioned at the beginning of the JPEG file.
+//
+// Returns:
+//   - The profile, or nil if the file has none.
+//   - An error if the segments cannot be read.
+func readJPEGICCProfile(r io.Reader) ([]byte, error) {
+	type chunk struct {
+		sequence int
+		data     []byte
+	}
+
+	var chunks []chunk
+	err := readJPEGSegments(r, func(marker byte, data []byte) bool {
+		if marker == 0xE2 && bytes.HasPrefix(data, []byte("ICC_PROFILE\x00")) && len(data) >= 14 {
+			chunks = append(chunks, chunk{sequence: int(data[12]), data: data[14:]})
+		}
+		return true
+	})
+	if err != nil || len(chunks) == 0 {
+		return nil, err
+	}
+
+	sort.Slice(chunks, func(a, b int) bool { return chunks[a].sequence < chunks[b].sequence })
+
+	var profile []byte
+	for _, c := range chunks {
+		profile = append(profile, c.data...)
+	}
+
+	return profile, nil
+}
+
+// readPNGICCProfile decompresses the ICC profile stored in the iCCP chunk of a PNG file.
+//
+// Parameters:
+//   - r: A reader positioned at the beginning of the PNG file.
+//
+// Returns:
+//   - The profile, or nil if the file has none.
+//   - An error if the chunks cannot be read or the profile cannot be decompressed.
+func readPNGICCProfile(r io.Reader) ([]byte, error) {
+	var compressed []byte
+
+	err := readPNGChunks(r, func(chunkType string, data []byte) bool {
+		if chunkType == "iCCP" {
+			if i := bytes.IndexByte(data, 0); i >= 0 && i+2 <= len(data) && data[i+1] == 0 {
+				compressed = data[i+2:]
+			}
+			return false
+		}
+		return true
+	})
+	if err != nil || compressed == nil {
+		return nil, err
+	}
+
+	reader, err := zlib.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	return io.ReadAll(io.LimitReader(reader, 1<<24))
+}
@@ -0,0 +1,329 @@
+package imagewatermark
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"testing"
+)
+
+// iccTestProfile builds a profile for a color space ("RGB " or "CMYK") from its tags, with an XYZ connection space.
+func iccTestProfile(colorSpace string, tags map[string][]byte) []byte {
+	names := []string{"rXYZ", "gXYZ", "bXYZ", "rTRC", "gTRC", "bTRC", "A2B0"}
+
+	var table, data []byte
+	count := 0
+	offset := 132 + 12*len(tags)
+	for _, name := range names {
+		tag, ok := tags[name]
+		if !ok {
+			continue
+		}
+		count++
+		table = binary.BigEndian.AppendUint32(append(table, name...), uint32(offset+len(data)))
+		table = binary.BigEndian.AppendUint32(table, uint32(len(tag)))
+		data = append(data, tag...)
+		for len(data)%4 != 0 {
+			data = append(data, 0)
+		}
+	}
+
+	header := make([]byte, 128)
+	copy(header[16:], colorSpace+"XYZ ")
+	copy(header[36:], "acsp")
+	profile := binary.BigEndian.AppendUint32(header, uint32(count))
+	profile = append(append(profile, table...), data...)
+	binary.BigEndian.PutUint32(profile, uint32(len(profile)))
+	return profile
+}
+
+// iccTestMatrixProfile builds a matrix/TRC profile with the sRGB primaries and a pure gamma tone curve.
+func iccTestMatrixProfile(gamma float64) []byte {
+	xyz := func(x, y, z float64) []byte {
+		tag := append([]byte("XYZ "), 0, 0, 0, 0)
+		for _, v := range []float64{x, y, z} {
+			tag = binary.BigEndian.AppendUint32(tag, uint32(int32(math.Round(v*65536))))
+		}
+		return tag
+	}
+	curve := binary.BigEndian.AppendUint16(append([]byte("curv"), 0, 0, 0, 0, 0, 0, 0, 1), uint16(gamma*256))
+
+	return iccTestProfile("RGB ", map[string][]byte{
+		"rXYZ": xyz(0.4361, 0.2225, 0.0139),
+		"gXYZ": xyz(0.3851, 0.7169, 0.0971),
+		"bXYZ": xyz(0.1431, 0.0606, 0.7141),
+		"rTRC": curve,
+		"gTRC": curve,
+		"bTRC": curve,
+	})
+}
+
+// iccTestLutProfile builds a profile with a 16-bit lookup table mapping every device color to the same gray.
+func iccTestLutProfile() []byte {
+	tag := append([]byte("mft2"), 0, 0, 0, 0, 3, 3, 2, 0)
+	// Identity matrix, in s15Fixed16.
+	for row := 0; row < 3; row++ {
+		for col := 0; col < 3; col++ {
+			v := uint32(0)
+			if row == col {
+				v = 1 << 16
+			}
+			tag = binary.BigEndian.AppendUint32(tag, v)
+		}
+	}
+	tag = binary.BigEndian.AppendUint16(binary.BigEndian.AppendUint16(tag, 2), 2)
+
+	for i := 0; i < 3; i++ {
+		tag = binary.BigEndian.AppendUint16(binary.BigEndian.AppendUint16(tag, 0), 0xffff)
+	}
+	for i := 0; i < 8; i++ {
+		for _, v := range []float64{0.9642, 1, 0.8249} {
+			tag = binary.BigEndian.AppendUint16(tag, uint16(v*0.2*0x8000))
+		}
+	}
+	for i := 0; i < 3; i++ {
+		tag = binary.BigEndian.AppendUint16(binary.BigEndian.AppendUint16(tag, 0), 0xffff)
+	}
+
+	return iccTestProfile("RGB ", map[string][]byte{"A2B0": tag})
+}
+
+// encodeTestPNGWithProfile encodes an image as PNG with an iCCP chunk.
+func encodeTestPNGWithProfile(t *testing.T, img image.Image, profile []byte) []byte {
+	t.Helper()
+
+	var encoded bytes.Buffer
+	if err := png.Encode(&encoded, img); err != nil {
+		t.Fatalf("png.Encode: %v", err)
+	}
+
+	var compressed bytes.Buffer
+	w := zlib.NewWriter(&compressed)
+	w.Write(profile)
+	w.Close()
+
+	chunk := append([]byte("iCCP"), "test\x00\x00"...)
+	chunk = append(chunk, compressed.Bytes()...)
+	iccp := binary.BigEndian.AppendUint32(nil, uint32(len(chunk)-4))
+	iccp = binary.BigEndian.AppendUint32(append(iccp, chunk...), crc32.ChecksumIEEE(chunk))
+
+	// The chunk goes right after the signature and IHDR (8 + 25 bytes).
+	data := encoded.Bytes()
+	return append(append(append([]byte{}, data[:33]...), iccp...), data[33:]...)
+}
+
+// srgbEncode returns the exact sRGB encoding of a linear value.
+func srgbEncode(v float64) float64 {
+	if v <= 0.0031308 {
+		return 12.92 * v
+	}
+	return 1.055*math.Pow(v, 1/2.4) - 0.055
+}
+
+func TestICCConvertBitDepth(t *testing.T) {
+	wide := image.NewNRGBA64(image.Rect(0, 0, 256, 4))
+	narrow := image.NewNRGBA(image.Rect(0, 0, 256, 4))
+	for y := 0; y < 4; y++ {
+		for x := 0; x < 256; x++ {
+			// Dark tones use small steps, where 8 bits would merge neighboring samples. Grays keep the small
+			// differences between the fixed-point colorants of the profile and the sRGB primaries out of the
+			// comparison.
+			v := uint16(x*x + y)
+			wide.SetNRGBA64(x, y, color.NRGBA64{R: v, G: v, B: v, A: uint16(0xffff - y)})
+			narrow.SetNRGBA(x, y, color.NRGBA{R: uint8(x), G: uint8(x / 2), B: uint8(255 - x), A: uint8(255 - y)})
+		}
+	}
+
+	opaque := image.NewRGBA64(image.Rect(0, 0, 64, 1))
+	for x := 0; x < 64; x++ {
+		v := uint16(x * x * 16)
+		opaque.SetRGBA64(x, 0, color.RGBA64{R: v, G: v, B: v, A: 0xffff})
+	}
+
+	tests := []struct {
+		name      string
+		img       image.Image
+		want16Bit bool
+	}{
+		{"nrgba64", wide, true},
+		{"rgba64", opaque, true},
+		{"nrgba", narrow, false},
+	}
+
+	// A linear profile makes the expected output the sRGB encoding of each sample.
+	profile := iccTestMatrixProfile(1)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := encodeTestPNGWithProfile(t, tt.img, profile)
+			decoded, _, err := decodeImage(data, OpenOptions{})
+			if err != nil {
+				t.Fatalf("decodeImage: %v", err)
+			}
+
+			_, is16Bit := decoded.(*image.NRGBA64)
+			if is16Bit != tt.want16Bit {
+				t.Fatalf("decoded %T, want 16-bit %v", decoded, tt.want16Bit)
+			}
+
+			scale, tolerance := 255.0, 1.0
+			if tt.want16Bit {
+				// The fixed-point colorants of the profile add up to a white point slightly off D50.
+				scale, tolerance = 0xffff, 16
+			}
+
+			bounds := tt.img.Bounds()
+			for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+				for x := bounds.Min.X; x < bounds.Max.X; x++ {
+					src := color.NRGBA64Model.Convert(tt.img.At(x, y)).(color.NRGBA64)
+					got := color.NRGBA64Model.Convert(decoded.At(x, y)).(color.NRGBA64)
+					if !tt.want16Bit {
+						n := decoded.(*image.NRGBA).NRGBAAt(x, y)
+						got = color.NRGBA64{R: uint16(n.R), G: uint16(n.G), B: uint16(n.B), A: uint16(n.A) * 0x101}
+					}
+
+					if got.A != src.A {
+						t.Fatalf("pixel (%d, %d) alpha = %d, want %d", x, y, got.A, src.A)
+					}
+
+					for i, pair := range [][2]uint16{{got.R, src.R}, {got.G, src.G}, {got.B, src.B}} {
+						want := srgbEncode(float64(pair[1])/0xffff) * scale
+						if math.Abs(float64(pair[0])-want) > tolerance {
+							t.Fatalf("pixel (%d, %d) channel %d = %d, want %.1f", x, y, i, pair[0], want)
+						}
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestICCTransformDoesNotAllocate(t *testing.T) {
+	tests := []struct {
+		name      string
+		profile   []byte
+		maxSample int
+	}{
+		{"matrix 8-bit", iccTestMatrixProfile(2.2), 0xff},
+		{"matrix 16-bit", iccTestMatrixProfile(2.2), 0xffff},
+		{"lookup table 8-bit", iccTestLutProfile(), 0xff},
+		{"lookup table 16-bit", iccTestLutProfile(), 0xffff},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			profile, err := parseICCProfile(tt.profile)
+			if err != nil {
+				t.Fatalf("parseICCProfile: %v", err)
+			}
+
+			transform := profile.newTransform(tt.maxSample)
+			next := 0
+			allocs := testing.AllocsPerRun(1000, func() {
+				// Distinct colors miss the cache of lookup table profiles.
+				next += 13
+				r, g, b := next%(tt.maxSample+1), next/3%(tt.maxSample+1), tt.maxSample-next%(tt.maxSample+1)
+				transform.linear([iccMaxChannels]uint16{uint16(r), uint16(g), uint16(b)})
+			})
+			if allocs != 0 {
+				t.Fatalf("linear allocated %.1f times per pixel", allocs)
+			}
+
+			if len(transform.cache) > 1<<iccCacheBits {
+				t.Fatalf("cache has %d entries, want at most %d", len(transform.cache), 1<<iccCacheBits)
+			}
+		})
+	}
+}
+
+func TestLinearToSRGB16(t *testing.T) {
+	for _, v := range []float64{-1, 0, 1e-6, 0.001, 0.0031308, 0.01, 0.2, 0.5, 0.99999, 1, 2} {
+		want := math.Round(srgbEncode(min(max(v, 0), 1)) * 0xffff)
+		if got := linearToSRGB16(v); math.Abs(float64(got)-want) > 1 {
+			t.Errorf("linearToSRGB16(%v) = %d, want %.0f", v, got, want)
+		}
+	}
+}
+
+// iccTestCMYKProfile builds a CMYK profile with a 16-bit lookup table whose output only depends on the black channel,
+// from the D50 white without black to pure black with full black.
+func iccTestCMYKProfile() []byte {
+	tag := append([]byte("mft2"), 0, 0, 0, 0, 4, 3, 2, 0)
+	for row := 0; row < 3; row++ {
+		for col := 0; col < 3; col++ {
+			v := uint32(0)
+			if row == col {
+				v = 1 << 16
+			}
+			tag = binary.BigEndian.AppendUint32(tag, v)
+		}
+	}
+	tag = binary.BigEndian.AppendUint16(binary.BigEndian.AppendUint16(tag, 2), 2)
+
+	for i := 0; i < 4; i++ {
+		tag = binary.BigEndian.AppendUint16(binary.BigEndian.AppendUint16(tag, 0), 0xffff)
+	}
+	// The grid is ordered with the last input (black) varying fastest.
+	for i := 0; i < 16; i++ {
+		white := 1.0
+		if i%2 == 1 {
+			white = 0
+		}
+		for _, v := range []float64{0.9642, 1, 0.8249} {
+			tag = binary.BigEndian.AppendUint16(tag, uint16(math.Round(v*white*0x8000)))
+		}
+	}
+	for i := 0; i < 3; i++ {
+		tag = binary.BigEndian.AppendUint16(binary.BigEndian.AppendUint16(tag, 0), 0xffff)
+	}
+
+	return iccTestProfile("CMYK", map[string][]byte{"A2B0": tag})
+}
+
+func TestICCConvertCMYK(t *testing.T) {
+	profile, err := parseICCProfile(iccTestCMYKProfile())
+	if err != nil {
+		t.Fatalf("parseICCProfile: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		cmyk color.CMYK
+		want uint8
+	}{
+		{"paper", color.CMYK{}, 255},
+		{"full black", color.CMYK{K: 255}, 0},
+		{"colorants are ignored by the profile", color.CMYK{C: 255, M: 128, Y: 30}, 255},
+		{"half black", color.CMYK{K: 128}, uint8(math.Round(srgbEncode(127.0/255) * 255))},
+		{"quarter black", color.CMYK{C: 200, K: 64}, uint8(math.Round(srgbEncode(191.0/255) * 255))},
+	}
+
+	img := image.NewCMYK(image.Rect(0, 0, len(tests), 1))
+	for i, tt := range tests {
+		img.SetCMYK(i, 0, tt.cmyk)
+	}
+
+	converted := profile.convert(img)
+	result, ok := converted.(*image.NRGBA)
+	if !ok {
+		t.Fatalf("convert() returned %T, want *image.NRGBA", converted)
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := result.NRGBAAt(i, 0)
+			if got.A != 255 || got.R != got.G || got.G != got.B || absInt(int(got.R)-int(tt.want)) > 2 {
+				t.Errorf("convert(%v) = %v, want opaque gray %d", tt.cmyk, got, tt.want)
+			}
+		})
+	}
+
+	if rgbProfile, _ := parseICCProfile(iccTestMatrixProfile(2.2)); rgbProfile.convert(img) != nil {
+		t.Error("convert() of a CMYK image with an RGB profile is not nil")
+	}
+}
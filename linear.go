@@ -0,0 +1,147 @@
+package imagewatermark
+
+import (
+	"image"
+	"math"
+	"sync"
+
+	"github.com/disintegration/imaging"
+	xdraw "golang.org/x/image/draw"
+)
+
+// linearTables holds the lookup tables that convert between sRGB-encoded and linear-light values.
+//
+// They are the only sRGB transfer curve tables of the package: compositing, adaptive contrast and ICC
+// conversions all go through them.
+//
+// Fields:
+//   - decode: The linear value (0 to 65535) of each 8-bit sRGB value.
+//   - encode: The 16-bit sRGB value of each 16-bit linear value.
+type linearTables struct {
+	decode [256]uint16
+	encode [1 << 16]uint16
+}
+
+// linearLUTs builds the lookup tables on first use, so images that never need them pay nothing.
+var linearLUTs = sync.OnceValue(func() *linearTables {
+	t := &linearTables{}
+
+	for i := range t.decode {
+		v := float64(i) / 255
+		if v <= 0.04045 {
+			v /= 12.92
+		} else {
+			v = math.Pow((v+0.055)/1.055, 2.4)
+		}
+		t.decode[i] = uint16(math.Round(v * 0xffff))
+	}
+
+	for i := range t.encode {
+		v := float64(i) / 0xffff
+		if v <= 0.0031308 {
+			v *= 12.92
+		} else {
+			v = 1.055*math.Pow(v, 1/2.4) - 0.055
+		}
+		t.encode[i] = uint16(math.Round(v * 0xffff))
+	}
+
+	return t
+})
+
+// toLinear converts an 8-bit sRGB value to linear light in [0, 1].
+func (t *linearTables) toLinear(v uint8) float64 {
+	return float64(t.decode[v]) / 0xffff
+}
+
+// toSRGB converts a linear-light value in [0, 1] to an 8-bit sRGB value.
+func (t *linearTables) toSRGB(v float64) uint8 {
+	return t.encode8(uint32(math.Round(min(max(v, 0), 1) * 0xffff)))
+}
+
+// encode8 converts a 16-bit linear value to an 8-bit sRGB value.
+func (t *linearTables) encode8(v uint32) uint8 {
+	return uint8((uint32(t.encode[v]) + 128) / 257)
+}
+
+// linearToSRGB16 converts a linear-light value in [0, 1] to a 16-bit sRGB value.
+//
+// The encode table is interpolated linearly, which keeps the error within one 16-bit step, so dark tones
+// keep the precision of 16-bit images.
+func linearToSRGB16(v float64) uint16 {
+	position := min(max(v, 0), 1) * 0xffff
+	i := int(position)
+	if i == 0xffff {
+		return 0xffff
+	}
+	fraction := position - float64(i)
+
+	t := linearLUTs()
+	return uint16(math.Round(float64(t.encode[i])*(1-fraction) + float64(t.encode[i+1])*fraction))
+}
+
+// resizeLinear resizes an image in linear light with premultiplied alpha, so antialiased edges and
+// semi-transparent colors are filtered without darkening.
+//
+// The image is converted to 16-bit linear values, scaled with the kernel of the resampling filter
+// (stretched when downscaling), and converted back to 8-bit sRGB.
+//
+// Parameters:
+//   - img: The image to resize.
+//   - width: The width of the resized image.
+//   - height: The height of the resized image.
+//   - filter: The resampling filter, with a positive support.
+//
+// Returns:
+//   - A pointer to a new image.NRGBA with bounds starting at the origin.
+func resizeLinear(img image.Image, width, height int, filter imaging.ResampleFilter) *image.NRGBA {
+	luts := linearLUTs()
+	src := toNRGBA(img)
+	srcBounds := src.Bounds()
+
+	linear := image.NewRGBA64(srcBounds)
+	for y := 0; y < srcBounds.Dy(); y++ {
+		srcRow := src.Pix[y*src.Stride:]
+		dstRow := linear.Pix[y*linear.Stride:]
+
+		for x := 0; x < srcBounds.Dx(); x++ {
+			p := srcRow[x*4 : x*4+4 : x*4+4]
+			q := dstRow[x*8 : x*8+8 : x*8+8]
+			alpha := uint32(p[3])
+
+			for c := 0; c < 3; c++ {
+				v := uint32(luts.decode[p[c]]) * alpha / 255
+				q[c*2], q[c*2+1] = uint8(v>>8), uint8(v)
+			}
+			q[6], q[7] = p[3], p[3]
+		}
+	}
+
+	scaled := image.NewRGBA64(image.Rect(0, 0, width, height))
+	kernel := &xdraw.Kernel{Support: filter.Support, At: filter.Kernel}
+	kernel.Scale(scaled, scaled.Bounds(), linear, srcBounds, xdraw.Src, nil)
+
+	result := image.NewNRGBA(scaled.Bounds())
+	for y := 0; y < height; y++ {
+		srcRow := scaled.Pix[y*scaled.Stride:]
+		dstRow := result.Pix[y*result.Stride:]
+
+		for x := 0; x < width; x++ {
+			q := srcRow[x*8 : x*8+8 : x*8+8]
+			p := dstRow[x*4 : x*4+4 : x*4+4]
+
+			alpha := uint32(q[6])<<8 | uint32(q[7])
+			if alpha == 0 {
+				continue
+			}
+
+			for c := 0; c < 3; c++ {
+				v := (uint32(q[c*2])<<8 | uint32(q[c*2+1])) * 0xffff / alpha
+				p[c] = luts.encode8(min(v, 0xffff))
+			}
+			p[3] = uint8((alpha + 128) / 257)
+		}
+	}
+
+	return result
+}
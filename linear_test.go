@@ -0,0 +1,114 @@
+package imagewatermark
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"testing"
+
+	"github.com/disintegration/imaging"
+)
+
+func TestLinearTablesRoundTrip(t *testing.T) {
+	luts := linearLUTs()
+
+	for v := 0; v < 256; v++ {
+		if got := luts.toSRGB(luts.toLinear(uint8(v))); got != uint8(v) {
+			t.Errorf("toSRGB(toLinear(%d)) = %d", v, got)
+		}
+	}
+
+	tests := []struct {
+		srgb   uint8
+		linear float64
+	}{
+		{0, 0},
+		{10, 0.003035},
+		{128, 0.215861},
+		{188, 0.502886},
+		{255, 1},
+	}
+
+	for _, tt := range tests {
+		if got := luts.toLinear(tt.srgb); math.Abs(got-tt.linear) > 1e-4 {
+			t.Errorf("toLinear(%d) = %v, want %v", tt.srgb, got, tt.linear)
+		}
+		if got := luts.toSRGB(tt.linear); got != tt.srgb {
+			t.Errorf("toSRGB(%v) = %d, want %d", tt.linear, got, tt.srgb)
+		}
+	}
+
+	if got := luts.toSRGB(-0.5); got != 0 {
+		t.Errorf("toSRGB(-0.5) = %d, want 0", got)
+	}
+	if got := luts.toSRGB(1.5); got != 255 {
+		t.Errorf("toSRGB(1.5) = %d, want 255", got)
+	}
+}
+
+func TestResizeLinear(t *testing.T) {
+	white := color.NRGBA{R: 255, G: 255, B: 255, A: 255}
+	black := color.NRGBA{A: 255}
+	red := color.NRGBA{R: 255, A: 255}
+
+	tests := []struct {
+		name  string
+		input *image.NRGBA
+		want  color.NRGBA
+	}{
+		{"black and white average in linear light", rowImage(black, white), color.NRGBA{R: 188, G: 188, B: 188, A: 255}},
+		{"edges do not darken", rowImage(red, color.NRGBA{}), color.NRGBA{R: 255, A: 128}},
+		{"uniform color is kept", rowImage(red, red), red},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := resizeLinear(tt.input, 1, 1, imaging.Box).NRGBAAt(0, 0)
+			if absInt(int(got.R)-int(tt.want.R)) > 1 || absInt(int(got.G)-int(tt.want.G)) > 1 ||
+				absInt(int(got.B)-int(tt.want.B)) > 1 || absInt(int(got.A)-int(tt.want.A)) > 1 {
+				t.Errorf("resizeLinear() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyLinearLight(t *testing.T) {
+	white := color.NRGBA{R: 255, G: 255, B: 255, A: 255}
+	black := color.NRGBA{A: 255}
+	red := color.NRGBA{R: 255, A: 255}
+	green := color.NRGBA{G: 255, A: 255}
+
+	tests := []struct {
+		name        string
+		background  color.NRGBA
+		watermark   color.NRGBA
+		linearLight bool
+		want        color.NRGBA
+	}{
+		{"srgb white over black", black, white, false, color.NRGBA{R: 128, G: 128, B: 128, A: 255}},
+		{"linear white over black", black, white, true, color.NRGBA{R: 188, G: 188, B: 188, A: 255}},
+		{"srgb red over green", green, red, false, color.NRGBA{R: 128, G: 127, A: 255}},
+		{"linear red over green", green, red, true, color.NRGBA{R: 188, G: 187, A: 255}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := SingleConfig{
+				GeneralConfig: GeneralConfig{OpacityAlpha: 0.5, WatermarkWidthPercent: 50, LinearLight: tt.linearLight},
+				Placement:     PlacementAbsolute,
+			}
+			result, err := ApplySingle(uniformImage(20, 20, tt.background), uniformImage(10, 10, tt.watermark), config)
+			if err != nil {
+				t.Fatalf("ApplySingle: %v", err)
+			}
+
+			got := nrgbaAt(result, 5, 5)
+			if absInt(int(got.R)-int(tt.want.R)) > 1 || absInt(int(got.G)-int(tt.want.G)) > 1 || absInt(int(got.B)-int(tt.want.B)) > 1 {
+				t.Errorf("pixel under the watermark = %v, want %v", got, tt.want)
+			}
+			if got := nrgbaAt(result, 15, 15); got != tt.background {
+				t.Errorf("pixel outside the watermark = %v, want %v", got, tt.background)
+			}
+		})
+	}
+}
@@ -0,0 +1,180 @@
+package imagewatermark
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"math"
+
+	"github.com/disintegration/imaging"
+)
+
+// MaskShape defines the source of the per-pixel opacity factors of an OpacityMask.
+//
+// Supported values:
+//   - MaskImage: Uses the luminance (multiplied by the alpha) of Image, stretched over the watermark.
+//   - MaskLinear: Generates a linear gradient from Start to End along Angle.
+//   - MaskRadial: Generates a radial gradient from Start at the center to End at the corners.
+type MaskShape int
+
+const (
+	MaskImage MaskShape = iota
+	MaskLinear
+	MaskRadial
+)
+
+// fadeLevels is the number of opacity steps used for per-position fading (vignette and detections),
+// so that positions with similar factors share the same faded watermark.
+const fadeLevels = 32
+
+// OpacityMask modulates the alpha of the watermark per pixel, on top of the uniform opacity.
+//
+// The mask covers the final watermark, including its rotation and effects, so gradients are oriented
+// relative to the output image.
+//
+// Fields:
+//   - Shape: The source of the opacity factors (Default is MaskImage).
+//   - Image: The mask image, used by MaskImage. White opaque pixels keep the watermark, black or transparent ones hide it.
+//   - Angle: Direction of the linear gradient in degrees, counter-clockwise from left to right. Used by MaskLinear.
+//   - Start: Opacity factor at the start of a linear gradient or the center of a radial one (0.0 to 1.0).
+//   - End: Opacity factor at the end of a linear gradient or the corners of a radial one (0.0 to 1.0).
+type OpacityMask struct {
+	Shape MaskShape
+	Image image.Image
+	Angle float64
+	Start float64
+	End   float64
+}
+
+// validate checks if the OpacityMask has a supported shape and valid factors.
+//
+// Returns:
+//   - An error describing the first invalid value found, or nil if the mask is valid.
+func (m OpacityMask) validate() error {
+	switch m.Shape {
+	case MaskImage:
+		if m.Image == nil {
+			return errors.New("mask image is required")
+		}
+	case MaskLinear, MaskRadial:
+		if m.Start < 0 || m.Start > 1 || m.End < 0 || m.End > 1 {
+			return fmt.Errorf("mask start and end must be between 0 and 1: %f, %f", m.Start, m.End)
+		}
+
+		if m.Start == 0 && m.End == 0 {
+			return errors.New("mask start and end cannot both be 0")
+		}
+	default:
+		return fmt.Errorf("unknown mask shape: %d", m.Shape)
+	}
+
+	return nil
+}
+
+// apply multiplies the alpha of every watermark pixel by the mask factor at the same position.
+//
+// Parameters:
+//   - img: The fitted watermark, with bounds starting at the origin.
+//
+// Returns:
+//   - A pointer to a new image.NRGBA containing the masked watermark.
+func (m OpacityMask) apply(img *image.NRGBA) *image.NRGBA {
+	result := imaging.Clone(img)
+	width, height := result.Bounds().Dx(), result.Bounds().Dy()
+
+	var maskImg *image.NRGBA
+	if m.Shape == MaskImage {
+		maskImg = imaging.Resize(m.Image, width, height, imaging.Linear)
+	}
+
+	dirX, dirY := math.Cos(m.Angle*math.Pi/180), -math.Sin(m.Angle*math.Pi/180)
+	extent := math.Abs(dirX)*float64(width) + math.Abs(dirY)*float64(height)
+	centerX, centerY := float64(width)/2, float64(height)/2
+	radius := math.Hypot(centerX, centerY)
+
+	for y := 0; y < height; y++ {
+		row := result.Pix[y*result.Stride:]
+
+		for x := 0; x < width; x++ {
+			i := x * 4
+			if row[i+3] == 0 {
+				continue
+			}
+
+			dx, dy := float64(x)+0.5-centerX, float64(y)+0.5-centerY
+
+			var factor float64
+			switch m.Shape {
+			case MaskImage:
+				p := maskImg.Pix[y*maskImg.Stride+i:]
+				factor = (0.2126*float64(p[0]) + 0.7152*float64(p[1]) + 0.0722*float64(p[2])) / 255 * float64(p[3]) / 255
+			case MaskLinear:
+				t := 0.5
+				if extent > 0 {
+					t = (dx*dirX+dy*dirY)/extent + 0.5
+				}
+				factor = m.Start + (m.End-m.Start)*math.Min(math.Max(t, 0), 1)
+			case MaskRadial:
+				t := 0.0
+				if radius > 0 {
+					t = math.Hypot(dx, dy) / radius
+				}
+				factor = m.Start + (m.End-m.Start)*math.Min(t, 1)
+			}
+
+			row[i+3] = uint8(math.Round(float64(row[i+3]) * factor))
+		}
+	}
+
+	return result
+}
+
+// VignetteConfig fades watermarks out toward the center of the input image, so the subject stays clear.
+//
+// Distances are measured from the image center to the center of each watermark, normalized so that the
+// corners of the image are at 1 (the shape follows the image aspect ratio). Watermarks closer than Inner
+// are hidden, those farther than Outer keep their full opacity, and those in between fade smoothly.
+// It is mostly useful with grids, where every tile is faded independently.
+//
+// Fields:
+//   - Inner: Normalized distance below which watermarks are hidden [0.0 to Outer).
+//   - Outer: Normalized distance above which watermarks are fully visible (Inner to 1.0].
+type VignetteConfig struct {
+	Inner float64
+	Outer float64
+}
+
+// validate checks if the VignetteConfig defines a valid fading range.
+//
+// Returns:
+//   - An error describing the first invalid value found, or nil if the configuration is valid.
+func (c VignetteConfig) validate() error {
+	if c.Inner < 0 || c.Outer > 1 || c.Inner >= c.Outer {
+		return fmt.Errorf("vignette distances must satisfy 0 <= inner < outer <= 1: %f, %f", c.Inner, c.Outer)
+	}
+
+	return nil
+}
+
+// factor computes the opacity factor of a watermark centered at the given point.
+//
+// Parameters:
+//   - inputBounds: The bounds of the input image.
+//   - center: The center of the watermark.
+//
+// Returns:
+//   - The opacity factor, from 0 (hidden) to 1 (unchanged).
+func (c VignetteConfig) factor(inputBounds image.Rectangle, center image.Point) float64 {
+	halfW, halfH := float64(inputBounds.Dx())/2, float64(inputBounds.Dy())/2
+	if halfW == 0 || halfH == 0 {
+		return 1
+	}
+
+	dx := (float64(center.X) - float64(inputBounds.Min.X) - halfW) / halfW
+	dy := (float64(center.Y) - float64(inputBounds.Min.Y) - halfH) / halfH
+	distance := math.Hypot(dx, dy) / math.Sqrt2
+
+	t := math.Min(math.Max((distance-c.Inner)/(c.Outer-c.Inner), 0), 1)
+
+	return t * t * (3 - 2*t)
+}
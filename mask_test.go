@@ -0,0 +1,161 @@
+package imagewatermark
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"testing"
+)
+
+func TestOpacityMaskApply(t *testing.T) {
+	white := color.NRGBA{R: 255, G: 255, B: 255, A: 255}
+
+	tests := []struct {
+		name  string
+		mask  OpacityMask
+		input *image.NRGBA
+		want  map[image.Point]uint8
+	}{
+		{
+			name:  "image",
+			mask:  OpacityMask{Image: rowImage(white, color.NRGBA{A: 255}, color.NRGBA{R: 255, G: 255, B: 255, A: 128}, color.NRGBA{})},
+			input: uniformImage(4, 1, white),
+			want:  map[image.Point]uint8{{0, 0}: 255, {1, 0}: 0, {2, 0}: 128, {3, 0}: 0},
+		},
+		{
+			name:  "image is stretched",
+			mask:  OpacityMask{Image: rowImage(white, white, color.NRGBA{A: 255}, color.NRGBA{A: 255})},
+			input: uniformImage(8, 1, white),
+			want:  map[image.Point]uint8{{0, 0}: 255, {1, 0}: 255, {6, 0}: 0, {7, 0}: 0},
+		},
+		{
+			name:  "linear",
+			mask:  OpacityMask{Shape: MaskLinear, Start: 0, End: 1},
+			input: uniformImage(4, 1, white),
+			want:  map[image.Point]uint8{{0, 0}: 32, {1, 0}: 96, {2, 0}: 159, {3, 0}: 223},
+		},
+		{
+			name:  "linear upward",
+			mask:  OpacityMask{Shape: MaskLinear, Angle: 90, Start: 1, End: 0},
+			input: uniformImage(1, 4, white),
+			want:  map[image.Point]uint8{{0, 0}: 32, {0, 3}: 223},
+		},
+		{
+			name:  "radial",
+			mask:  OpacityMask{Shape: MaskRadial, Start: 1, End: 0},
+			input: uniformImage(3, 3, white),
+			want:  map[image.Point]uint8{{1, 1}: 255, {0, 0}: 85, {2, 2}: 85, {1, 0}: 135},
+		},
+		{
+			name:  "multiplies existing alpha",
+			mask:  OpacityMask{Shape: MaskLinear, Start: 0.5, End: 0.5},
+			input: uniformImage(2, 1, color.NRGBA{R: 255, A: 100}),
+			want:  map[image.Point]uint8{{0, 0}: 50, {1, 0}: 50},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := tt.mask.apply(tt.input)
+			for p, want := range tt.want {
+				if got := result.NRGBAAt(p.X, p.Y).A; got != want {
+					t.Errorf("alpha at %v = %d, want %d", p, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestVignetteFactor(t *testing.T) {
+	bounds := image.Rect(0, 0, 200, 100)
+	vignette := VignetteConfig{Inner: 0.2, Outer: 0.8}
+
+	tests := []struct {
+		name   string
+		center image.Point
+		want   float64
+	}{
+		{"image center", image.Pt(100, 50), 0},
+		{"inside inner", image.Pt(110, 55), 0},
+		{"corner", image.Pt(200, 100), 1},
+		{"outside outer", image.Pt(190, 95), 1},
+		{"halfway", image.Pt(150, 75), 0.5},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := vignette.factor(bounds, tt.center); math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("factor(%v) = %v, want %v", tt.center, got, tt.want)
+			}
+		})
+	}
+
+	if got := vignette.factor(bounds.Add(image.Pt(1000, 1000)), image.Pt(1100, 1050)); got != 0 {
+		t.Errorf("factor at the center of offset bounds = %v, want 0", got)
+	}
+}
+
+func TestApplyGridVignette(t *testing.T) {
+	white := color.NRGBA{R: 255, G: 255, B: 255, A: 255}
+	red := color.NRGBA{R: 255, A: 255}
+
+	config := GridConfig{
+		GeneralConfig: GeneralConfig{OpacityAlpha: 1, WatermarkWidthPercent: 10, Vignette: &VignetteConfig{Inner: 0.3, Outer: 0.6}},
+		GridSpacingX:  10,
+		GridSpacingY:  10,
+	}
+	result, err := ApplyGrid(uniformImage(100, 100, white), uniformImage(10, 10, red), config)
+	if err != nil {
+		t.Fatalf("ApplyGrid: %v", err)
+	}
+
+	if got := nrgbaAt(result, 45, 45); got != white {
+		t.Errorf("tile at the center = %v, want hidden", got)
+	}
+	if got := nrgbaAt(result, 5, 5); got != red {
+		t.Errorf("tile at the corner = %v, want fully visible", got)
+	}
+}
+
+func TestOpacityMaskValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		mask    OpacityMask
+		wantErr string
+	}{
+		{"image", OpacityMask{Image: image.NewGray(image.Rect(0, 0, 1, 1))}, ""},
+		{"linear", OpacityMask{Shape: MaskLinear, Start: 1}, ""},
+		{"radial", OpacityMask{Shape: MaskRadial, Start: 0.2, End: 0.8}, ""},
+		{"missing image", OpacityMask{}, "mask image is required"},
+		{"start above 1", OpacityMask{Shape: MaskLinear, Start: 1.5}, "between 0 and 1"},
+		{"negative end", OpacityMask{Shape: MaskRadial, Start: 1, End: -0.1}, "between 0 and 1"},
+		{"both zero", OpacityMask{Shape: MaskLinear}, "cannot both be 0"},
+		{"unknown shape", OpacityMask{Shape: MaskRadial + 1}, "unknown mask shape"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checkValidateError(t, tt.mask.validate(), tt.wantErr)
+		})
+	}
+}
+
+func TestVignetteConfigValidate(t *testing.T) {
+	tests := []struct {
+		name     string
+		vignette VignetteConfig
+		wantErr  string
+	}{
+		{"valid", VignetteConfig{Inner: 0.2, Outer: 0.8}, ""},
+		{"full range", VignetteConfig{Outer: 1}, ""},
+		{"negative inner", VignetteConfig{Inner: -0.1, Outer: 0.5}, "vignette distances"},
+		{"outer above 1", VignetteConfig{Inner: 0.5, Outer: 1.5}, "vignette distances"},
+		{"inner equal to outer", VignetteConfig{Inner: 0.5, Outer: 0.5}, "vignette distances"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checkValidateError(t, tt.vignette.validate(), tt.wantErr)
+		})
+	}
+}
@@ -0,0 +1,591 @@
+package imagewatermark
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"math"
+	"os"
+)
+
+var (
+	jpegSignature = []byte{0xFF, 0xD8}
+	pngSignature  = []byte{0x89, 'P', 'N', 'G', '\r', '\n', 0x1A, '\n'}
+)
+
+// ReadDPI reads the resolution stored in the metadata of a JPEG (JFIF or EXIF) or PNG (pHYs) file.
+//
+// The returned value can be assigned directly to GeneralConfig.DPI so that millimeter and inch lengths
+// match the physical size of the image. When the file has no resolution information, or is in another
+// format, zero is returned, which makes GeneralConfig fall back to DefaultDPI.
+//
+// Parameters:
+//   - path: The file path to the image whose resolution should be read.
+//
+// Returns:
+//   - A float64 containing the horizontal resolution in dots per inch, or zero if unknown.
+//   - An error if the file cannot be read.
+//
+// Example:
+//
+//	dpi, err := ReadDPI("print.jpg")
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	config.DPI = dpi
+func ReadDPI(path string) (float64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	return readDPI(bufio.NewReader(file))
+}
+
+// readDPI detects the image format from its signature and reads the resolution from its metadata.
+//
+// Parameters:
+//   - r: A buffered reader positioned at the beginning of the image file.
+//
+// Returns:
+//   - A float64 containing the horizontal resolution in dots per inch, or zero if unknown.
+//   - An error if the metadata cannot be read.
+func readDPI(r *bufio.Reader) (float64, error) {
+	header, err := r.Peek(len(pngSignature))
+	if err != nil && !errors.Is(err, io.EOF) {
+		return 0, err
+	}
+
+	switch {
+	case bytes.HasPrefix(header, jpegSignature):
+		return readJPEGDPI(r)
+	case bytes.HasPrefix(header, pngSignature):
+		return readPNGDPI(r)
+	default:
+		return 0, nil
+	}
+}
+
+// readJPEGDPI reads the resolution from the JFIF (APP0) or EXIF (APP1) segments of a JPEG file.
+//
+// The JFIF density is preferred; the EXIF XResolution is used when no JFIF density is present.
+//
+// Parameters:
+//   - r: A reader positioned at the beginning of the JPEG file.
+//
+// Returns:
+//   - A float64 containing the horizontal resolution in dots per inch, or zero if unknown.
+//   - An error if the segments cannot be read.
+func readJPEGDPI(r io.Reader) (float64, error) {
+	var jfifDPI, exifDPI float64
+
+	err := readJPEGSegments(r, func(marker byte, data []byte) bool {
+		switch {
+		case marker == 0xE0 && bytes.HasPrefix(data, []byte("JFIF\x00")) && len(data) >= 12:
+			units := data[7]
+			density := float64(binary.BigEndian.Uint16(data[8:10]))
+			switch units {
+			case 1:
+				jfifDPI = density
+			case 2:
+				jfifDPI = density * 2.54
+			}
+		case marker == 0xE1 && bytes.HasPrefix(data, []byte("Exif\x00\x00")):
+			exifDPI = readEXIFDPI(data[6:])
+		}
+
+		return jfifDPI == 0
+	})
+
+	if jfifDPI > 0 {
+		return jfifDPI, err
+	}
+
+	return exifDPI, err
+}
+
+// readJPEGSegments iterates over the marker segments of a JPEG file until the start of the image data.
+//
+// Parameters:
+//   - r: A reader positioned at the beginning of the JPEG file.
+//   - fn: The function called with the marker and payload of each segment. Returning false stops the iteration.
+//
+// Returns:
+//   - An error if the file is not a valid JPEG or the segments cannot be read.
+func readJPEGSegments(r io.Reader, fn func(marker byte, data []byte) bool) error {
+	var header [4]byte
+
+	if _, err := io.ReadFull(r, header[:2]); err != nil {
+		return err
+	}
+
+	if !bytes.Equal(header[:2], jpegSignature) {
+		return errors.New("not a jpeg file")
+	}
+
+	for {
+		if _, err := io.ReadFull(r, header[:2]); err != nil {
+			return err
+		}
+
+		for header[1] == 0xFF {
+			if _, err := io.ReadFull(r, header[1:2]); err != nil {
+				return err
+			}
+		}
+
+		if header[0] != 0xFF {
+			return errors.New("invalid jpeg marker")
+		}
+
+		marker := header[1]
+		if marker == 0xDA || marker == 0xD9 {
+			return nil
+		}
+
+		if marker >= 0xD0 && marker <= 0xD7 {
+			continue
+		}
+
+		if _, err := io.ReadFull(r, header[2:4]); err != nil {
+			return err
+		}
+
+		length := int(binary.BigEndian.Uint16(header[2:4]))
+		if length < 2 {
+			return errors.New("invalid jpeg segment length")
+		}
+
+		data := make([]byte, length-2)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return err
+		}
+
+		if !fn(marker, data) {
+			return nil
+		}
+	}
+}
+
+// readEXIFDPI reads the XResolution and ResolutionUnit tags from the first IFD of an EXIF (TIFF) block.
+//
+// Parameters:
+//   - tiff: The TIFF structure that follows the "Exif\0\0" header of the APP1 segment.
+//
+// Returns:
+//   - A float64 containing the horizontal resolution in dots per inch, or zero if unknown or malformed.
+func readEXIFDPI(tiff []byte) float64 {
+	order, entries := readEXIFEntries(tiff)
+
+	var resolution float64
+	unit := uint16(2)
+
+	for _, entry := range entries {
+		tag := order.Uint16(entry[0:2])
+		switch tag {
+		case 0x011A:
+			offset := int(order.Uint32(entry[8:12]))
+			if offset+8 > len(tiff) {
+				return 0
+			}
+			numerator := order.Uint32(tiff[offset : offset+4])
+			denominator := order.Uint32(tiff[offset+4 : offset+8])
+			if denominator != 0 {
+				resolution = float64(numerator) / float64(denominator)
+			}
+		case 0x0128:
+			unit = order.Uint16(entry[8:10])
+		}
+	}
+
+	switch unit {
+	case 2:
+		return resolution
+	case 3:
+		return resolution * 2.54
+	default:
+		return 0
+	}
+}
+
+// readEXIFOrientation reads the Orientation tag from the first IFD of an EXIF (TIFF) block.
+//
+// Parameters:
+//   - tiff: The TIFF structure that follows the "Exif\0\0" header of the APP1 segment.
+//
+// Returns:
+//   - An int containing the orientation (1 to 8), or 1 if unknown or malformed.
+func readEXIFOrientation(tiff []byte) int {
+	order, entries := readEXIFEntries(tiff)
+
+	for _, entry := range entries {
+		if order.Uint16(entry[0:2]) == 0x0112 {
+			if orientation := int(order.Uint16(entry[8:10])); orientation >= 1 && orientation <= 8 {
+				return orientation
+			}
+		}
+	}
+
+	return 1
+}
+
+// readEXIFEntries returns the 12-byte entries of the first IFD of an EXIF (TIFF) block.
+//
+// Parameters:
+//   - tiff: The TIFF structure that follows the "Exif\0\0" header of the APP1 segment.
+//
+// Returns:
+//   - The byte order of the block.
+//   - The entries, or nil if the block is malformed.
+func readEXIFEntries(tiff []byte) (binary.ByteOrder, [][]byte) {
+	if len(tiff) < 8 {
+		return nil, nil
+	}
+
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return nil, nil
+	}
+
+	ifd := int(order.Uint32(tiff[4:8]))
+	if ifd+2 > len(tiff) {
+		return nil, nil
+	}
+
+	count := int(order.Uint16(tiff[ifd : ifd+2]))
+	entries := make([][]byte, count)
+	for i := range entries {
+		entry := ifd + 2 + i*12
+		if entry+12 > len(tiff) {
+			return nil, nil
+		}
+		entries[i] = tiff[entry : entry+12]
+	}
+
+	return order, entries
+}
+
+// readJPEGOrientation reads the EXIF orientation of a JPEG file.
+//
+// Parameters:
+//   - r: A reader positioned at the beginning of the JPEG file.
+//
+// Returns:
+//   - An int containing the orientation (1 to 8), or 1 if unknown.
+//   - An error if the segments cannot be read.
+func readJPEGOrientation(r io.Reader) (int, error) {
+	orientation := 1
+
+	err := readJPEGSegments(r, func(marker byte, data []byte) bool {
+		if marker == 0xE1 && bytes.HasPrefix(data, []byte("Exif\x00\x00")) {
+			orientation = readEXIFOrientation(data[6:])
+			return false
+		}
+		return true
+	})
+
+	return orientation, err
+}
+
+// readPNGDPI reads the resolution from the pHYs chunk of a PNG file.
+//
+// Parameters:
+//   - r: A reader positioned at the beginning of the PNG file.
+//
+// Returns:
+//   - A float64 containing the horizontal resolution in dots per inch, or zero if unknown.
+//   - An error if the chunks cannot be read.
+func readPNGDPI(r io.Reader) (float64, error) {
+	var dpi float64
+
+	err := readPNGChunks(r, func(chunkType string, data []byte) bool {
+		if chunkType == "pHYs" && len(data) == 9 && data[8] == 1 {
+			pixelsPerMeter := float64(binary.BigEndian.Uint32(data[0:4]))
+			dpi = pixelsPerMeter * 0.0254
+			return false
+		}
+
+		return true
+	})
+
+	return dpi, err
+}
+
+// readPNGChunks iterates over the chunks of a PNG file until the first IDAT chunk.
+//
+// Parameters:
+//   - r: A reader positioned at the beginning of the PNG file.
+//   - fn: The function called with the type and payload of each chunk. Returning false stops the iteration.
+//
+// Returns:
+//   - An error if the file is not a valid PNG or the chunks cannot be read.
+func readPNGChunks(r io.Reader, fn func(chunkType string, data []byte) bool) error {
+	signature := make([]byte, len(pngSignature))
+	if _, err := io.ReadFull(r, signature); err != nil {
+		return err
+	}
+
+	if !bytes.Equal(signature, pngSignature) {
+		return errors.New("not a png file")
+	}
+
+	var header [8]byte
+
+	for {
+		if _, err := io.ReadFull(r, header[:]); err != nil {
+			return err
+		}
+
+		length := binary.BigEndian.Uint32(header[:4])
+		chunkType := string(header[4:8])
+
+		if chunkType == "IDAT" || chunkType == "IEND" {
+			return nil
+		}
+
+		if length > 1<<24 {
+			return errors.New("png chunk too large")
+		}
+
+		data := make([]byte, length+4)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return err
+		}
+
+		if !fn(chunkType, data[:length]) {
+			return nil
+		}
+	}
+}
+
+// jpegStandardQuant holds the IJG standard luminance and chrominance quantization tables, in zigzag order,
+// which libjpeg and image/jpeg scale to produce the tables for a given quality.
+var jpegStandardQuant = [2][64]int{
+	{
+		16, 11, 12, 14, 12, 10, 16, 14, 13, 14, 18, 17, 16, 19, 24, 40,
+		26, 24, 22, 22, 24, 49, 35, 37, 29, 40, 58, 51, 61, 60, 57, 51,
+		56, 55, 64, 72, 92, 78, 64, 68, 87, 69, 55, 56, 80, 109, 81, 87,
+		95, 98, 103, 104, 103, 62, 77, 113, 121, 112, 100, 120, 92, 101, 103, 99,
+	},
+	{
+		17, 18, 18, 24, 21, 24, 47, 26, 26, 47, 99, 66, 56, 66, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99, 99, 99, 99, 99, 99, 99, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99, 99, 99, 99, 99, 99, 99, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99, 99, 99, 99, 99, 99, 99, 99, 99,
+	},
+}
+
+// ImageInfo describes an image file opened with OpenImageWithInfo.
+//
+// Fields:
+//   - Format: The name of the decoded format, such as "jpeg", "png", "gif" or "webp".
+//   - DPI: The resolution stored in the metadata, as returned by ReadDPI, or zero if unknown.
+//   - JPEGQuality: The quality estimated by EstimateJPEGQuality, or zero when the file is not a JPEG.
+type ImageInfo struct {
+	Format      string
+	DPI         float64
+	JPEGQuality int
+}
+
+// OpenImageWithInfo loads an image like OpenImageWithOptions and also reads its format, resolution and JPEG quality.
+//
+// The metadata is read on a best-effort basis: a resolution or quantization table that cannot be parsed leaves
+// the matching field at zero instead of failing, since broken metadata does not make the pixels unusable.
+//
+// Pass the returned ImageInfo as SaveOptions.MatchSource to re-encode the watermarked result with the
+// quality of the source, and assign DPI to GeneralConfig.DPI for physical units.
+//
+// Parameters:
+//   - path: The file path to the image to be loaded.
+//   - options: OpenOptions controlling the conversion and the limits checked before decoding.
+//
+// Returns:
+//   - An image.Image containing the loaded image data.
+//   - An ImageInfo describing the file.
+//   - An error if the options are invalid, the file cannot be read, the format is not supported or the image
+//     exceeds a limit (a *LimitError).
+//
+// Example:
+//
+//	img, info, err := OpenImageWithInfo("photo.jpg", OpenOptions{})
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	config.DPI = info.DPI
+//	result, err := ApplySingle(img, watermarkImg, config)
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	err = SaveImage(result, "output.jpg", SaveOptions{MatchSource: &info})
+func OpenImageWithInfo(path string, options OpenOptions) (image.Image, ImageInfo, error) {
+	if err := options.validate(); err != nil {
+		return nil, ImageInfo{}, fmt.Errorf("invalid open options: %w", err)
+	}
+
+	return openImage(path, options)
+}
+
+// openImage reads and decodes an image file and reads its metadata.
+//
+// Parameters:
+//   - path: The file path to the image to be loaded.
+//   - options: Validated OpenOptions.
+//
+// Returns:
+//   - The decoded image.
+//   - An ImageInfo describing the file, with zero fields for metadata that cannot be read.
+//   - An error if the file cannot be read or decoded, or exceeds a limit.
+func openImage(path string, options OpenOptions) (image.Image, ImageInfo, error) {
+	data, err := readFileLimited(path, options.MaxFileBytes)
+	if err != nil {
+		return nil, ImageInfo{}, err
+	}
+
+	img, format, err := decodeImage(data, options)
+	if err != nil {
+		return nil, ImageInfo{}, err
+	}
+
+	info := ImageInfo{Format: format}
+
+	if dpi, err := readDPI(bufio.NewReader(bytes.NewReader(data))); err == nil {
+		info.DPI = dpi
+	}
+
+	if format == "jpeg" {
+		if quality, err := estimateJPEGQuality(bytes.NewReader(data)); err == nil {
+			info.JPEGQuality = quality
+		}
+	}
+
+	return img, info, nil
+}
+
+// EstimateJPEGQuality estimates the quality (1-100) a JPEG file was saved with, from its quantization tables.
+//
+// The tables are compared with the IJG standard tables scaled for every quality, which gives the exact value
+// for files written by libjpeg-based encoders and by image/jpeg, and the closest match for custom tables.
+//
+// Parameters:
+//   - path: The file path to the JPEG image.
+//
+// Returns:
+//   - An int containing the estimated quality, or zero if the file is not a JPEG or has no quantization tables.
+//   - An error if the file cannot be read.
+//
+// Example:
+//
+//	quality, err := EstimateJPEGQuality("photo.jpg")
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	err = SaveImage(result, "output.jpg", SaveOptions{JPEGQuality: quality})
+func EstimateJPEGQuality(path string) (int, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	r := bufio.NewReader(file)
+	header, err := r.Peek(len(jpegSignature))
+	if err != nil && !errors.Is(err, io.EOF) {
+		return 0, err
+	}
+
+	if !bytes.HasPrefix(header, jpegSignature) {
+		return 0, nil
+	}
+
+	return estimateJPEGQuality(r)
+}
+
+// estimateJPEGQuality reads the quantization tables (DQT segments) of a JPEG file and finds the quality
+// whose scaled standard tables are closest to them.
+//
+// Parameters:
+//   - r: A reader positioned at the beginning of the JPEG file.
+//
+// Returns:
+//   - An int containing the estimated quality, or zero if the file has no quantization tables.
+//   - An error if the segments cannot be read.
+func estimateJPEGQuality(r io.Reader) (int, error) {
+	var tables [2][]int
+
+	err := readJPEGSegments(r, func(marker byte, data []byte) bool {
+		if marker != 0xDB {
+			return true
+		}
+
+		for len(data) > 0 {
+			precision, id := data[0]>>4, data[0]&0x0F
+			size := 64
+			if precision != 0 {
+				size = 128
+			}
+			if len(data) < 1+size {
+				break
+			}
+
+			table := make([]int, 64)
+			for i := range table {
+				if precision != 0 {
+					table[i] = int(binary.BigEndian.Uint16(data[1+2*i:]))
+				} else {
+					table[i] = int(data[1+i])
+				}
+			}
+			if id < 2 {
+				tables[id] = table
+			}
+
+			data = data[1+size:]
+		}
+
+		return true
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	if tables[0] == nil && tables[1] == nil {
+		return 0, nil
+	}
+
+	best, bestError := 0, math.MaxInt
+	for quality := 1; quality <= 100; quality++ {
+		scale := 200 - 2*quality
+		if quality < 50 {
+			scale = 5000 / quality
+		}
+
+		totalError := 0
+		for id, table := range tables {
+			for i, value := range table {
+				expected := min(max((jpegStandardQuant[id][i]*scale+50)/100, 1), 255)
+				if value > expected {
+					totalError += value - expected
+				} else {
+					totalError += expected - value
+				}
+			}
+		}
+
+		// Ties keep the higher quality, so re-encoding never degrades the image more than the source.
+		if totalError <= bestError {
+			best, bestError = quality, totalError
+		}
+	}
+
+	return best, nil
+}
@@ -0,0 +1,165 @@
+package imagewatermark
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// encodeTestJPEG encodes an image with image/jpeg and inserts extra marker segments right after SOI.
+func encodeTestJPEG(t *testing.T, img image.Image, quality int, segments ...[]byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality}); err != nil {
+		t.Fatalf("jpeg.Encode: %v", err)
+	}
+	data := buf.Bytes()
+	return append(append(append([]byte(nil), data[:2]...), bytes.Join(segments, nil)...), data[2:]...)
+}
+
+// jpegSegment returns a marker segment with the given payload.
+func jpegSegment(marker byte, payload []byte) []byte {
+	segment := []byte{0xFF, marker, 0, 0}
+	binary.BigEndian.PutUint16(segment[2:], uint16(len(payload)+2))
+	return append(segment, payload...)
+}
+
+// writeTestFile writes data to a file in a temporary directory and returns its path.
+func writeTestFile(t *testing.T, name string, data []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, data, 0o666); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestEstimateJPEGQuality(t *testing.T) {
+	img := noiseImage(32, 32)
+
+	// A 16-bit luminance table equal to the standard one, which is what quality 50 produces.
+	table16 := []byte{0x10}
+	for _, v := range jpegStandardQuant[0] {
+		table16 = binary.BigEndian.AppendUint16(table16, uint16(v))
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+		want int
+	}{
+		{"quality 1", encodeTestJPEG(t, img, 1), 1},
+		{"quality 10", encodeTestJPEG(t, img, 10), 10},
+		{"quality 50", encodeTestJPEG(t, img, 50), 50},
+		{"quality 75", encodeTestJPEG(t, img, 75), 75},
+		{"quality 90", encodeTestJPEG(t, img, 90), 90},
+		{"quality 95", encodeTestJPEG(t, img, 95), 95},
+		{"quality 100", encodeTestJPEG(t, img, 100), 100},
+		{"16-bit table", append(append([]byte{0xFF, 0xD8}, jpegSegment(0xDB, table16)...), 0xFF, 0xD9), 50},
+		{"no quantization tables", []byte{0xFF, 0xD8, 0xFF, 0xD9}, 0},
+		{"not a jpeg", []byte("\x89PNG\r\n\x1a\n"), 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := EstimateJPEGQuality(writeTestFile(t, "image.jpg", tt.data))
+			if err != nil {
+				t.Fatalf("EstimateJPEGQuality: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("EstimateJPEGQuality() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := EstimateJPEGQuality(filepath.Join(t.TempDir(), "missing.jpg")); err == nil {
+		t.Error("EstimateJPEGQuality() of a missing file = nil, want an error")
+	}
+}
+
+func TestOpenImageWithInfo(t *testing.T) {
+	img := noiseImage(16, 16)
+
+	// A JFIF header declaring 300 dots per inch.
+	jfif := jpegSegment(0xE0, []byte{'J', 'F', 'I', 'F', 0, 1, 1, 1, 0x01, 0x2C, 0x01, 0x2C, 0, 0})
+	// An EXIF block whose TIFF structure is garbage.
+	brokenEXIF := jpegSegment(0xE1, []byte("Exif\x00\x00garbage"))
+
+	var pngData bytes.Buffer
+	if err := png.Encode(&pngData, img); err != nil {
+		t.Fatalf("png.Encode: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		file string
+		data []byte
+		want ImageInfo
+	}{
+		{"jpeg", "photo.jpg", encodeTestJPEG(t, img, 70), ImageInfo{Format: "jpeg", JPEGQuality: 70}},
+		{"jpeg with dpi", "photo.jpg", encodeTestJPEG(t, img, 85, jfif), ImageInfo{Format: "jpeg", DPI: 300, JPEGQuality: 85}},
+		{"broken exif is ignored", "photo.jpg", encodeTestJPEG(t, img, 60, brokenEXIF), ImageInfo{Format: "jpeg", JPEGQuality: 60}},
+		{"png", "image.png", pngData.Bytes(), ImageInfo{Format: "png"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, info, err := OpenImageWithInfo(writeTestFile(t, tt.file, tt.data), OpenOptions{})
+			if err != nil {
+				t.Fatalf("OpenImageWithInfo: %v", err)
+			}
+			if info != tt.want {
+				t.Errorf("OpenImageWithInfo() info = %+v, want %+v", info, tt.want)
+			}
+		})
+	}
+}
+
+func TestSaveImageMatchSource(t *testing.T) {
+	source := writeTestFile(t, "source.jpg", encodeTestJPEG(t, noiseImage(64, 64), 70))
+	watermark := uniformImage(10, 10, color.NRGBA{R: 255, A: 255})
+	config := SingleConfig{GeneralConfig: GeneralConfig{OpacityAlpha: 0.5, WatermarkWidthPercent: 20}}
+
+	opened, info, err := OpenImageWithInfo(source, OpenOptions{})
+	if err != nil {
+		t.Fatalf("OpenImageWithInfo: %v", err)
+	}
+	result, err := ApplySingle(opened, watermark, config)
+	if err != nil {
+		t.Fatalf("ApplySingle: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		options SaveOptions
+		want    int
+	}{
+		{"default quality", SaveOptions{}, 95},
+		{"source quality", SaveOptions{MatchSource: &info}, 70},
+		{"source quality over JPEGQuality", SaveOptions{MatchSource: &info, JPEGQuality: 90}, 70},
+		{"quality floor", SaveOptions{MatchSource: &info, MinJPEGQuality: 80}, 80},
+		{"source without quality", SaveOptions{MatchSource: &ImageInfo{Format: "png"}, JPEGQuality: 88}, 88},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "output.jpg")
+			saved, err := SaveImageWithResult(result, path, tt.options)
+			if err != nil {
+				t.Fatalf("SaveImageWithResult: %v", err)
+			}
+			if saved.Quality != tt.want {
+				t.Errorf("result quality = %d, want %d", saved.Quality, tt.want)
+			}
+			if estimated, err := EstimateJPEGQuality(path); err != nil || estimated != tt.want {
+				t.Errorf("EstimateJPEGQuality() of the output = %d, %v, want %d", estimated, err, tt.want)
+			}
+		})
+	}
+}
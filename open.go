@@ -0,0 +1,446 @@
+package imagewatermark
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"os"
+	"slices"
+)
+
+// Errors wrapped by LimitError when an image exceeds the limits of OpenOptions or MaxInputPixels.
+var (
+	// ErrFileTooLarge is returned when an image file is larger than OpenOptions.MaxFileBytes.
+	ErrFileTooLarge = errors.New("image file exceeds the maximum size")
+	// ErrTooManyPixels is returned when an image has more pixels than OpenOptions.MaxPixels or MaxInputPixels.
+	ErrTooManyPixels = errors.New("image exceeds the maximum number of pixels")
+	// ErrFormatNotAllowed is returned when an image format is not listed in OpenOptions.AllowedFormats.
+	ErrFormatNotAllowed = errors.New("image format is not allowed")
+)
+
+// LimitError describes an image rejected by a resource limit. It wraps ErrFileTooLarge, ErrTooManyPixels
+// or ErrFormatNotAllowed, so it can be checked with errors.Is and inspected with errors.As.
+//
+// Fields:
+//   - Err: The error of the limit that was exceeded.
+//   - Format: The format of the image, when known (e.g. "png").
+//   - Value: The file size in bytes or the number of pixels of the image (0 for ErrFormatNotAllowed).
+//   - Limit: The maximum allowed by the limit (0 for ErrFormatNotAllowed).
+type LimitError struct {
+	Err    error
+	Format string
+	Value  int64
+	Limit  int64
+}
+
+// Error returns the limit that was exceeded, with the value of the image.
+func (e *LimitError) Error() string {
+	if errors.Is(e.Err, ErrFormatNotAllowed) {
+		return fmt.Sprintf("%v: %q", e.Err, e.Format)
+	}
+	return fmt.Sprintf("%v: %d > %d", e.Err, e.Value, e.Limit)
+}
+
+// Unwrap returns the error of the limit that was exceeded.
+func (e *LimitError) Unwrap() error {
+	return e.Err
+}
+
+// OpenOptions controls how OpenImageWithOptions decodes an image.
+//
+// The limits protect services that open untrusted files: they are checked against the file size and the
+// header of the image (through image.DecodeConfig) before any pixel is decoded, so a small file declaring
+// huge dimensions (a decompression bomb) is rejected without allocating its pixels.
+//
+// Fields:
+//   - KeepCMYK: Returns CMYK JPEGs as an *image.CMYK instead of converting them to sRGB, so the Apply
+//     functions watermark them and return them as CMYK (unless ForceRGBA is set). Their embedded profile
+//     is ignored. Default is false.
+//   - MaxPixels: Optional maximum number of pixels (width × height) of the image (0 disables it). For GIF
+//     animations, it applies to the logical screen that every frame is drawn on.
+//   - MaxFileBytes: Optional maximum size of the file in bytes (0 disables it).
+//   - MaxAnimationPixels: Optional maximum number of pixels of all frames of an animation opened with
+//     OpenGIFWithOptions or DecodeGIFWithOptions (width × height × frames, 0 disables it). Frames are counted
+//     from the file before any of them is decoded.
+//   - AllowedFormats: Optional list of accepted format names, as registered with the image package
+//     ("jpeg", "png", "gif", "webp", "bmp" or "tiff"). Default is every registered format.
+type OpenOptions struct {
+	KeepCMYK           bool
+	MaxPixels          int
+	MaxFileBytes       int64
+	MaxAnimationPixels int64
+	AllowedFormats     []string
+}
+
+// validate checks if the OpenOptions have non-negative limits.
+//
+// Returns:
+//   - An error describing the first invalid value found, or nil if all fields are valid.
+func (o OpenOptions) validate() error {
+	if o.MaxPixels < 0 {
+		return fmt.Errorf("max pixels must be a non-negative integer: %d", o.MaxPixels)
+	}
+
+	if o.MaxFileBytes < 0 {
+		return fmt.Errorf("max file bytes must be a non-negative integer: %d", o.MaxFileBytes)
+	}
+
+	if o.MaxAnimationPixels < 0 {
+		return fmt.Errorf("max animation pixels must be a non-negative integer: %d", o.MaxAnimationPixels)
+	}
+
+	return nil
+}
+
+// checkHeader checks the format and dimensions declared in the header of an image against the limits.
+//
+// Parameters:
+//   - data: The contents of the image file.
+//
+// Returns:
+//   - A LimitError if the format is not allowed or the image has too many pixels, the error of
+//     image.DecodeConfig if the header cannot be read, or nil.
+func (o OpenOptions) checkHeader(data []byte) error {
+	if o.MaxPixels == 0 && len(o.AllowedFormats) == 0 {
+		return nil
+	}
+
+	config, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+
+	if len(o.AllowedFormats) > 0 && !slices.Contains(o.AllowedFormats, format) {
+		return &LimitError{Err: ErrFormatNotAllowed, Format: format}
+	}
+
+	if pixels := int64(config.Width) * int64(config.Height); o.MaxPixels > 0 && pixels > int64(o.MaxPixels) {
+		return &LimitError{Err: ErrTooManyPixels, Format: format, Value: pixels, Limit: int64(o.MaxPixels)}
+	}
+
+	return nil
+}
+
+// OpenImageWithOptions loads an image from the specified path, converts it to sRGB using its embedded
+// color profile and corrects its orientation based on EXIF metadata.
+//
+// Color management covers the cases where decoding the pixels as they are gives wrong colors:
+//   - CMYK and YCCK JPEGs (including Adobe files with inverted samples) are converted with their embedded
+//     ICC profile, or with the naive CMYK formula of image/color when they have none.
+//   - RGB JPEGs and PNGs with an embedded ICC profile other than sRGB (such as Adobe RGB or Display P3)
+//     are converted to sRGB.
+//
+// Profiles that cannot be parsed are ignored and the pixels are used as they are. Grayscale images are
+// never converted. Converted images are returned as an *image.NRGBA64 for 16-bit PNGs and
+// as an *image.NRGBA otherwise.
+//
+// The file size, format and dimensions are checked against the limits of the options before decoding.
+//
+// Parameters:
+//   - path: The file path to the image to be loaded.
+//   - options: OpenOptions controlling the conversion.
+//
+// Returns:
+//   - An image.Image containing the loaded image data.
+//   - An error if the options are invalid, the file cannot be read, the format is not supported or the image
+//     exceeds a limit (a *LimitError wrapping ErrFileTooLarge, ErrTooManyPixels or ErrFormatNotAllowed).
+func OpenImageWithOptions(path string, options OpenOptions) (image.Image, error) {
+	if err := options.validate(); err != nil {
+		return nil, fmt.Errorf("invalid open options: %w", err)
+	}
+
+	img, _, err := openImage(path, options)
+	return img, err
+}
+
+// decodeImage decodes an image file, applies color management and corrects its EXIF orientation.
+//
+// Parameters:
+//   - data: The contents of the image file.
+//   - options: OpenOptions controlling the conversion.
+//
+// Returns:
+//   - The decoded image.
+//   - A string containing the format name registered with the image package (e.g. "jpeg").
+//   - An error if the format is not supported, the image exceeds a limit or cannot be decoded.
+func decodeImage(data []byte, options OpenOptions) (image.Image, string, error) {
+	if err := options.checkHeader(data); err != nil {
+		return nil, "", err
+	}
+
+	img, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", err
+	}
+
+	// Broken metadata does not make the pixels unusable, so read errors only skip the step they affect.
+	orientation := 1
+	var profileData []byte
+	switch format {
+	case "jpeg":
+		orientation, _ = readJPEGOrientation(bytes.NewReader(data))
+		profileData, _ = readJPEGICCProfile(bytes.NewReader(data))
+	case "png":
+		profileData, _ = readPNGICCProfile(bytes.NewReader(data))
+	}
+
+	cmyk, isCMYK := img.(*image.CMYK)
+	if isCMYK && options.KeepCMYK {
+		return orientImage(cmyk, orientation), format, nil
+	}
+
+	converted := false
+	if profileData != nil {
+		if profile, err := parseICCProfile(profileData); err == nil {
+			if rgb := profile.convert(img); rgb != nil {
+				img, converted = rgb, true
+			}
+		}
+	}
+	if isCMYK && !converted {
+		img = toNRGBA(img)
+	}
+
+	return orientImage(img, orientation), format, nil
+}
+
+// readFileLimited reads a file, failing without reading it past the limit when it is too large.
+//
+// Parameters:
+//   - path: The file path.
+//   - maxBytes: The maximum size of the file in bytes, or 0 for no limit.
+//
+// Returns:
+//   - The contents of the file.
+//   - A LimitError wrapping ErrFileTooLarge if the file is larger than maxBytes, or an error if it cannot be read.
+func readFileLimited(path string, maxBytes int64) ([]byte, error) {
+	if maxBytes == 0 {
+		return os.ReadFile(path)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if info.Size() > maxBytes {
+		return nil, &LimitError{Err: ErrFileTooLarge, Value: info.Size(), Limit: maxBytes}
+	}
+
+	// The size is checked again while reading, for files that grow or report no size (such as pipes).
+	return readLimited(file, maxBytes)
+}
+
+// readLimited reads a stream, failing once it is larger than the limit.
+//
+// Parameters:
+//   - r: The reader.
+//   - maxBytes: The maximum size of the stream in bytes, or 0 for no limit.
+//
+// Returns:
+//   - The contents of the stream.
+//   - A LimitError wrapping ErrFileTooLarge if the stream is larger than maxBytes, or the read error.
+func readLimited(r io.Reader, maxBytes int64) ([]byte, error) {
+	if maxBytes == 0 {
+		return io.ReadAll(r)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(r, maxBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > maxBytes {
+		return nil, &LimitError{Err: ErrFileTooLarge, Value: int64(len(data)), Limit: maxBytes}
+	}
+
+	return data, nil
+}
+
+// checkInputPixels checks the number of pixels of the input images passed to a batch function.
+//
+// Parameters:
+//   - inputImgs: The input images.
+//   - maxPixels: The maximum number of pixels of each image, or 0 for no limit.
+//
+// Returns:
+//   - A LimitError wrapping ErrTooManyPixels, prefixed with the index of the first image that is too large, or nil.
+func checkInputPixels(inputImgs []image.Image, maxPixels int) error {
+	if maxPixels == 0 {
+		return nil
+	}
+
+	for i, img := range inputImgs {
+		bounds := img.Bounds()
+		if pixels := int64(bounds.Dx()) * int64(bounds.Dy()); pixels > int64(maxPixels) {
+			return fmt.Errorf("input image %d: %w", i, &LimitError{Err: ErrTooManyPixels, Value: pixels, Limit: int64(maxPixels)})
+		}
+	}
+
+	return nil
+}
+
+// orientImage transforms an image so it displays upright according to its EXIF orientation.
+//
+// Gray, Gray16, RGBA, NRGBA, RGBA64, NRGBA64, CMYK, Paletted and YCbCr images are reoriented by moving
+// their samples, so they keep their type and precision. YCbCr images keep their chroma subsampling,
+// swapped for the orientations that transpose the image (4:1:1 and 4:1:0 become 4:4:4).
+//
+// Parameters:
+//   - img: The decoded image.
+//   - orientation: The EXIF orientation, from 1 to 8.
+//
+// Returns:
+//   - The image itself for orientation 1, or a new image of the same type otherwise
+//     (an *image.NRGBA for other types).
+func orientImage(img image.Image, orientation int) image.Image {
+	if orientation < 2 || orientation > 8 {
+		return img
+	}
+
+	bounds := img.Bounds()
+	rect := image.Rect(0, 0, bounds.Dx(), bounds.Dy())
+	if orientation >= 5 {
+		rect = image.Rect(0, 0, bounds.Dy(), bounds.Dx())
+	}
+
+	switch src := img.(type) {
+	case *image.Gray:
+		dst := image.NewGray(rect)
+		orientPix(dst.Pix, dst.Stride, src.Pix[src.PixOffset(bounds.Min.X, bounds.Min.Y):], src.Stride, 1, bounds, orientation)
+		return dst
+	case *image.Gray16:
+		dst := image.NewGray16(rect)
+		orientPix(dst.Pix, dst.Stride, src.Pix[src.PixOffset(bounds.Min.X, bounds.Min.Y):], src.Stride, 2, bounds, orientation)
+		return dst
+	case *image.RGBA:
+		dst := image.NewRGBA(rect)
+		orientPix(dst.Pix, dst.Stride, src.Pix[src.PixOffset(bounds.Min.X, bounds.Min.Y):], src.Stride, 4, bounds, orientation)
+		return dst
+	case *image.NRGBA:
+		dst := image.NewNRGBA(rect)
+		orientPix(dst.Pix, dst.Stride, src.Pix[src.PixOffset(bounds.Min.X, bounds.Min.Y):], src.Stride, 4, bounds, orientation)
+		return dst
+	case *image.RGBA64:
+		dst := image.NewRGBA64(rect)
+		orientPix(dst.Pix, dst.Stride, src.Pix[src.PixOffset(bounds.Min.X, bounds.Min.Y):], src.Stride, 8, bounds, orientation)
+		return dst
+	case *image.NRGBA64:
+		dst := image.NewNRGBA64(rect)
+		orientPix(dst.Pix, dst.Stride, src.Pix[src.PixOffset(bounds.Min.X, bounds.Min.Y):], src.Stride, 8, bounds, orientation)
+		return dst
+	case *image.CMYK:
+		dst := image.NewCMYK(rect)
+		orientPix(dst.Pix, dst.Stride, src.Pix[src.PixOffset(bounds.Min.X, bounds.Min.Y):], src.Stride, 4, bounds, orientation)
+		return dst
+	case *image.Paletted:
+		dst := image.NewPaletted(rect, append(color.Palette(nil), src.Palette...))
+		orientPix(dst.Pix, dst.Stride, src.Pix[src.PixOffset(bounds.Min.X, bounds.Min.Y):], src.Stride, 1, bounds, orientation)
+		return dst
+	case *image.YCbCr:
+		return orientYCbCr(src, rect, orientation)
+	default:
+		return orientImage(toNRGBA(img), orientation)
+	}
+}
+
+// orientedSource returns the source pixel shown at (x, y) once the EXIF orientation is applied.
+//
+// Parameters:
+//   - x, y: The destination pixel, relative to the origin of the oriented image.
+//   - w, h: The size of the source image.
+//   - orientation: The EXIF orientation, from 2 to 8.
+//
+// Returns:
+//   - The source pixel, relative to the minimum point of the source bounds.
+func orientedSource(x, y, w, h, orientation int) (int, int) {
+	switch orientation {
+	case 2:
+		return w - 1 - x, y
+	case 3:
+		return w - 1 - x, h - 1 - y
+	case 4:
+		return x, h - 1 - y
+	case 5:
+		return y, x
+	case 6:
+		return y, h - 1 - x
+	case 7:
+		return w - 1 - y, h - 1 - x
+	default:
+		return w - 1 - y, x
+	}
+}
+
+// orientPix copies the samples of an interleaved pixel buffer into an oriented buffer.
+//
+// Parameters:
+//   - dst: The pixels of the oriented image, starting at its first pixel.
+//   - dstStride: The stride of dst.
+//   - src: The pixels of the source image, starting at its first pixel.
+//   - srcStride: The stride of src.
+//   - size: The number of bytes per pixel.
+//   - bounds: The bounds of the source image.
+//   - orientation: The EXIF orientation, from 2 to 8.
+func orientPix(dst []uint8, dstStride int, src []uint8, srcStride, size int, bounds image.Rectangle, orientation int) {
+	w, h := bounds.Dx(), bounds.Dy()
+	dstW, dstH := w, h
+	if orientation >= 5 {
+		dstW, dstH = h, w
+	}
+
+	for y := 0; y < dstH; y++ {
+		for x := 0; x < dstW; x++ {
+			sx, sy := orientedSource(x, y, w, h, orientation)
+			i, j := y*dstStride+x*size, sy*srcStride+sx*size
+			copy(dst[i:i+size], src[j:j+size])
+		}
+	}
+}
+
+// orientYCbCr is orientImage for YCbCr images, which keeps the luma samples and the chroma subsampling.
+//
+// Each chroma sample of the result is taken from the source chroma sample of one of the pixels it covers.
+//
+// Parameters:
+//   - src: The decoded image.
+//   - rect: The bounds of the oriented image.
+//   - orientation: The EXIF orientation, from 2 to 8.
+//
+// Returns:
+//   - A pointer to a new image.YCbCr.
+func orientYCbCr(src *image.YCbCr, rect image.Rectangle, orientation int) *image.YCbCr {
+	ratio := src.SubsampleRatio
+	if orientation >= 5 {
+		switch ratio {
+		case image.YCbCrSubsampleRatio422:
+			ratio = image.YCbCrSubsampleRatio440
+		case image.YCbCrSubsampleRatio440:
+			ratio = image.YCbCrSubsampleRatio422
+		case image.YCbCrSubsampleRatio411, image.YCbCrSubsampleRatio410:
+			ratio = image.YCbCrSubsampleRatio444
+		}
+	}
+
+	bounds := src.Bounds()
+	dst := image.NewYCbCr(rect, ratio)
+	orientPix(dst.Y, dst.YStride, src.Y[src.YOffset(bounds.Min.X, bounds.Min.Y):], src.YStride, 1, bounds, orientation)
+
+	w, h := bounds.Dx(), bounds.Dy()
+	for y := 0; y < rect.Dy(); y++ {
+		for x := 0; x < rect.Dx(); x++ {
+			sx, sy := orientedSource(x, y, w, h, orientation)
+			i, j := dst.COffset(x, y), src.COffset(bounds.Min.X+sx, bounds.Min.Y+sy)
+			dst.Cb[i], dst.Cr[i] = src.Cb[j], src.Cr[j]
+		}
+	}
+
+	return dst
+}
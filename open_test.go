@@ -0,0 +1,324 @@
+package imagewatermark
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/draw"
+	"image/gif"
+	"image/png"
+	"strings"
+	"testing"
+
+	"github.com/disintegration/imaging"
+)
+
+func TestOpenImageWithOptionsLimits(t *testing.T) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewNRGBA(image.Rect(0, 0, 20, 10))); err != nil {
+		t.Fatalf("png.Encode: %v", err)
+	}
+	path := writeTestFile(t, "input.png", buf.Bytes())
+	size := int64(buf.Len())
+
+	tests := []struct {
+		name      string
+		options   OpenOptions
+		wantErr   string
+		wantLimit error
+		wantValue int64
+	}{
+		{"no limits", OpenOptions{}, "", nil, 0},
+		{"within limits", OpenOptions{MaxPixels: 200, MaxFileBytes: size, AllowedFormats: []string{"jpeg", "png"}}, "", nil, 0},
+		{"negative max pixels", OpenOptions{MaxPixels: -1}, "invalid open options: max pixels", nil, 0},
+		{"negative max file bytes", OpenOptions{MaxFileBytes: -1}, "invalid open options: max file bytes", nil, 0},
+		{"negative max animation pixels", OpenOptions{MaxAnimationPixels: -1}, "invalid open options: max animation pixels", nil, 0},
+		{"too many pixels", OpenOptions{MaxPixels: 199}, "200 > 199", ErrTooManyPixels, 200},
+		{"file too large", OpenOptions{MaxFileBytes: size - 1}, "exceeds the maximum size", ErrFileTooLarge, size},
+		{"format not allowed", OpenOptions{AllowedFormats: []string{"jpeg"}}, `"png"`, ErrFormatNotAllowed, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			img, err := OpenImageWithOptions(path, tt.options)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("OpenImageWithOptions: %v", err)
+				}
+				if img.Bounds() != image.Rect(0, 0, 20, 10) {
+					t.Errorf("bounds = %v, want 20x10", img.Bounds())
+				}
+				return
+			}
+
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("OpenImageWithOptions() error = %v, want %q", err, tt.wantErr)
+			}
+			if tt.wantLimit == nil {
+				return
+			}
+
+			var limitErr *LimitError
+			if !errors.Is(err, tt.wantLimit) || !errors.As(err, &limitErr) {
+				t.Fatalf("OpenImageWithOptions() error = %v, want a *LimitError wrapping %v", err, tt.wantLimit)
+			}
+			if limitErr.Value != tt.wantValue {
+				t.Errorf("LimitError.Value = %d, want %d", limitErr.Value, tt.wantValue)
+			}
+		})
+	}
+}
+
+func TestDecodeGIFWithOptionsAnimationPixels(t *testing.T) {
+	palette := color.Palette{color.Black, color.White}
+	anim := &gif.GIF{}
+	for i := 0; i < 3; i++ {
+		anim.Image = append(anim.Image, palettedRect(image.Rect(0, 0, 10, 5), palette, uint8(i%2)))
+		anim.Delay = append(anim.Delay, 10)
+	}
+	var buf bytes.Buffer
+	if err := gif.EncodeAll(&buf, anim); err != nil {
+		t.Fatalf("gif.EncodeAll: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		options OpenOptions
+		wantErr bool
+	}{
+		{"no limit", OpenOptions{}, false},
+		{"exactly at the limit", OpenOptions{MaxAnimationPixels: 150}, false},
+		{"frame within max pixels", OpenOptions{MaxPixels: 50, MaxAnimationPixels: 1000}, false},
+		{"over the limit", OpenOptions{MaxAnimationPixels: 149}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			decoded, err := DecodeGIFWithOptions(bytes.NewReader(buf.Bytes()), tt.options)
+			if !tt.wantErr {
+				if err != nil {
+					t.Fatalf("DecodeGIFWithOptions: %v", err)
+				}
+				if len(decoded.Frames) != 3 {
+					t.Errorf("frames = %d, want 3", len(decoded.Frames))
+				}
+				return
+			}
+
+			var limitErr *LimitError
+			if !errors.As(err, &limitErr) || !errors.Is(err, ErrTooManyPixels) {
+				t.Fatalf("DecodeGIFWithOptions() error = %v, want a *LimitError wrapping ErrTooManyPixels", err)
+			}
+			if limitErr.Value != 150 || limitErr.Limit != tt.options.MaxAnimationPixels || limitErr.Format != "gif" {
+				t.Errorf("LimitError = %+v, want 150 pixels of gif over %d", limitErr, tt.options.MaxAnimationPixels)
+			}
+		})
+	}
+}
+
+func TestAnimationMaxInputPixels(t *testing.T) {
+	frame := image.NewNRGBA(image.Rect(0, 0, 10, 10))
+	watermark := uniformImage(4, 4, color.NRGBA{R: 255, A: 255})
+	input := &Animation{Frames: []image.Image{frame, frame}, Delays: []int{10, 10}}
+
+	tests := []struct {
+		name      string
+		maxPixels int
+		apply     func(config SingleConfig) (*Animation, error)
+		wantErr   string
+	}{
+		{"static watermark within limit", 100, func(c SingleConfig) (*Animation, error) { return ApplySingleToAnimation(input, watermark, c) }, ""},
+		{"static watermark", 99, func(c SingleConfig) (*Animation, error) { return ApplySingleToAnimation(input, watermark, c) }, "input animation: frame 0: "},
+		{"animated watermark within limit", 100, func(c SingleConfig) (*Animation, error) {
+			return ApplySingleWithAnimatedWatermark(input, StillAnimation(watermark), c)
+		}, ""},
+		{"animated watermark", 99, func(c SingleConfig) (*Animation, error) {
+			return ApplySingleWithAnimatedWatermark(input, StillAnimation(watermark), c)
+		}, "input animation: frame 0: "},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := SingleConfig{GeneralConfig: GeneralConfig{OpacityAlpha: 1, WatermarkWidthPercent: 10, MaxInputPixels: tt.maxPixels}}
+			_, err := tt.apply(config)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("apply: %v", err)
+				}
+				return
+			}
+
+			if err == nil || !strings.HasPrefix(err.Error(), tt.wantErr) || !errors.Is(err, ErrTooManyPixels) {
+				t.Fatalf("apply() error = %v, want %q wrapping ErrTooManyPixels", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestOrientImageKeepsType(t *testing.T) {
+	noise := noiseImage(5, 3)
+	rect := noise.Bounds()
+	fill := func(img draw.Image) image.Image {
+		draw.Draw(img, rect, noise, image.Point{}, draw.Src)
+		return img
+	}
+	ycbcr := image.NewYCbCr(rect, image.YCbCrSubsampleRatio444)
+	for i := range ycbcr.Y {
+		ycbcr.Y[i], ycbcr.Cb[i], ycbcr.Cr[i] = uint8(40+i*9), uint8(100+i*5), uint8(150-i*4)
+	}
+
+	inputs := []struct {
+		name string
+		img  image.Image
+	}{
+		{"gray", fill(image.NewGray(rect))},
+		{"gray16", fill(image.NewGray16(rect))},
+		{"rgba", fill(image.NewRGBA(rect))},
+		{"nrgba", fill(image.NewNRGBA(rect))},
+		{"rgba64", fill(image.NewRGBA64(rect))},
+		{"nrgba64", fill(image.NewNRGBA64(rect))},
+		{"cmyk", fill(image.NewCMYK(rect))},
+		{"paletted", fill(image.NewPaletted(rect, palette.Plan9))},
+		{"ycbcr", ycbcr},
+		{"offset bounds", fill(image.NewGray(image.Rect(2, 1, 7, 4)))},
+	}
+
+	// The expected result of each orientation, as produced by imaging on an NRGBA copy.
+	references := map[int]func(image.Image) *image.NRGBA{
+		2: imaging.FlipH, 3: imaging.Rotate180, 4: imaging.FlipV, 5: imaging.Transpose,
+		6: imaging.Rotate270, 7: imaging.Transverse, 8: imaging.Rotate90,
+	}
+
+	for _, input := range inputs {
+		for orientation := 2; orientation <= 8; orientation++ {
+			t.Run(fmt.Sprintf("%s/%d", input.name, orientation), func(t *testing.T) {
+				got := orientImage(input.img, orientation)
+				if gotType, wantType := fmt.Sprintf("%T", got), fmt.Sprintf("%T", input.img); gotType != wantType {
+					t.Fatalf("type = %s, want %s", gotType, wantType)
+				}
+
+				want := references[orientation](input.img)
+				if got.Bounds() != want.Bounds() {
+					t.Fatalf("bounds = %v, want %v", got.Bounds(), want.Bounds())
+				}
+				for y := 0; y < want.Bounds().Dy(); y++ {
+					for x := 0; x < want.Bounds().Dx(); x++ {
+						if g, w := nrgbaAt(got, x, y), want.NRGBAAt(x, y); g != w {
+							t.Fatalf("pixel (%d, %d) = %v, want %v", x, y, g, w)
+						}
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestOrientImage16BitPrecision(t *testing.T) {
+	// a b c
+	// d e f
+	src := image.NewGray16(image.Rect(0, 0, 3, 2))
+	for i, v := range []uint16{0x1001, 0x2002, 0x3003, 0x4004, 0x5005, 0x6006} {
+		src.SetGray16(i%3, i/3, color.Gray16{Y: v})
+	}
+
+	tests := []struct {
+		name        string
+		orientation int
+		want        [][]uint16
+	}{
+		{"rotate clockwise", 6, [][]uint16{{0x4004, 0x1001}, {0x5005, 0x2002}, {0x6006, 0x3003}}},
+		{"rotate counterclockwise", 8, [][]uint16{{0x3003, 0x6006}, {0x2002, 0x5005}, {0x1001, 0x4004}}},
+		{"flip horizontal", 2, [][]uint16{{0x3003, 0x2002, 0x1001}, {0x6006, 0x5005, 0x4004}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := orientImage(src, tt.orientation).(*image.Gray16)
+			if !ok {
+				t.Fatalf("type = %T, want *image.Gray16", got)
+			}
+			for y, row := range tt.want {
+				for x, want := range row {
+					if v := got.Gray16At(x, y).Y; v != want {
+						t.Errorf("pixel (%d, %d) = %#x, want %#x", x, y, v, want)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestOrientImageSubsampledYCbCr(t *testing.T) {
+	tests := []struct {
+		ratio       image.YCbCrSubsampleRatio
+		orientation int
+		want        image.YCbCrSubsampleRatio
+	}{
+		{image.YCbCrSubsampleRatio420, 3, image.YCbCrSubsampleRatio420},
+		{image.YCbCrSubsampleRatio420, 6, image.YCbCrSubsampleRatio420},
+		{image.YCbCrSubsampleRatio422, 4, image.YCbCrSubsampleRatio422},
+		{image.YCbCrSubsampleRatio422, 8, image.YCbCrSubsampleRatio440},
+		{image.YCbCrSubsampleRatio440, 5, image.YCbCrSubsampleRatio422},
+		{image.YCbCrSubsampleRatio411, 7, image.YCbCrSubsampleRatio444},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%v/%d", tt.ratio, tt.orientation), func(t *testing.T) {
+			src := image.NewYCbCr(image.Rect(0, 0, 8, 4), tt.ratio)
+			for i := range src.Cb {
+				src.Cb[i], src.Cr[i] = 90, 170
+			}
+			for i := range src.Y {
+				src.Y[i] = uint8(i * 7)
+			}
+
+			got, ok := orientImage(src, tt.orientation).(*image.YCbCr)
+			if !ok {
+				t.Fatalf("type = %T, want *image.YCbCr", got)
+			}
+			if got.SubsampleRatio != tt.want {
+				t.Errorf("subsample ratio = %v, want %v", got.SubsampleRatio, tt.want)
+			}
+			want := imaging.Clone(orientImage(imaging.Clone(src), tt.orientation))
+			for y := 0; y < want.Bounds().Dy(); y++ {
+				for x := 0; x < want.Bounds().Dx(); x++ {
+					if g, w := nrgbaAt(got, x, y), want.NRGBAAt(x, y); g != w {
+						t.Fatalf("pixel (%d, %d) = %v, want %v", x, y, g, w)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestOpenImageOrientedGrayJPEG(t *testing.T) {
+	src := image.NewGray(image.Rect(0, 0, 16, 8))
+	for x := 8; x < 16; x++ {
+		for y := 0; y < 8; y++ {
+			src.SetGray(x, y, color.Gray{Y: 255})
+		}
+	}
+
+	// Big-endian EXIF block with a single orientation entry: 6 (rotate 90 degrees clockwise).
+	exif := []byte("Exif\x00\x00MM\x00\x2a\x00\x00\x00\x08\x00\x01\x01\x12\x00\x03\x00\x00\x00\x01\x00\x06\x00\x00\x00\x00\x00\x00")
+	path := writeTestFile(t, "oriented.jpg", encodeTestJPEG(t, src, 95, jpegSegment(0xE1, exif)))
+
+	img, err := OpenImage(path)
+	if err != nil {
+		t.Fatalf("OpenImage: %v", err)
+	}
+	gray, ok := img.(*image.Gray)
+	if !ok {
+		t.Fatalf("type = %T, want *image.Gray", img)
+	}
+	if size := gray.Bounds().Size(); size != image.Pt(8, 16) {
+		t.Fatalf("size = %v, want (8,16)", size)
+	}
+	// The white right half ends up at the bottom once rotated clockwise.
+	if top, bottom := gray.GrayAt(4, 2).Y, gray.GrayAt(4, 13).Y; top > 10 || bottom < 245 {
+		t.Errorf("top = %d, bottom = %d, want black above white", top, bottom)
+	}
+}
@@ -0,0 +1,422 @@
+package imagewatermark
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"math"
+)
+
+// Placer defines a positioning strategy for watermarks.
+//
+// Given the input image and the bounds of the prepared (resized, rotated) watermark, a Placer returns
+// the top-left corners where the watermark should be drawn. Returning no positions leaves the image untouched.
+//
+// SingleConfig and GridConfig implement Placer with the built-in alignment and grid behaviors, so custom
+// strategies (for example, positioning relative to a detected object) can be used anywhere a built-in one is.
+type Placer interface {
+	Place(inputImg image.Image, watermarkBounds image.Rectangle) []image.Point
+}
+
+// PlacerFunc is an adapter that allows the use of an ordinary function as a Placer.
+//
+// Example:
+//
+//	placer := PlacerFunc(func(inputImg image.Image, wmBounds image.Rectangle) []image.Point {
+//		return []image.Point{{X: 10, Y: 10}}
+//	})
+type PlacerFunc func(inputImg image.Image, watermarkBounds image.Rectangle) []image.Point
+
+// Place calls f(inputImg, watermarkBounds).
+func (f PlacerFunc) Place(inputImg image.Image, watermarkBounds image.Rectangle) []image.Point {
+	return f(inputImg, watermarkBounds)
+}
+
+// Place implements Placer using the placement settings of the SingleConfig.
+//
+// Depending on the Placement mode, the position is computed from the alignment and margins,
+// from the normalized Anchor, or taken verbatim from Position.
+//
+// Parameters:
+//   - inputImg: The input image where the watermark will be placed.
+//   - watermarkBounds: The bounds of the prepared watermark image.
+//
+// Returns:
+//   - A slice containing the single position where the watermark should be placed.
+func (c SingleConfig) Place(inputImg image.Image, watermarkBounds image.Rectangle) []image.Point {
+	switch c.Placement {
+	case PlacementAnchor:
+		return []image.Point{c.Anchor.position(watermarkBounds, inputImg.Bounds())}
+	case PlacementAbsolute:
+		return []image.Point{c.Position}
+	}
+
+	marginX, marginY := c.margins(inputImg.Bounds())
+
+	return []image.Point{
+		getWatermarkPosition(watermarkBounds, inputImg.Bounds(), c.VerticalAlign, c.HorizontalAlign, marginX, marginY),
+	}
+}
+
+// Place implements Placer using the spacing and offset settings of the GridConfig.
+//
+// Parameters:
+//   - inputImg: The input image where the watermarks will be placed.
+//   - watermarkBounds: The bounds of the prepared watermark image.
+//
+// Returns:
+//   - A slice with every grid position where the watermark should be placed.
+func (c GridConfig) Place(inputImg image.Image, watermarkBounds image.Rectangle) []image.Point {
+	return generateGridPositions(inputImg.Bounds(), watermarkBounds, c.resolved(inputImg.Bounds()))
+}
+
+// ApplyWithPlacer applies a watermark to an input image at the positions returned by a custom Placer.
+//
+// The watermark appearance (opacity, size, rotation and resampling) is taken from the GeneralConfig,
+// while positioning is fully delegated to the Placer.
+//
+// Parameters:
+//   - inputImg: The input image to which the watermark will be applied.
+//   - watermarkImg: The watermark image to overlay on the input image.
+//   - config: GeneralConfig containing the watermark appearance settings.
+//   - placer: The Placer that computes where the watermark is drawn.
+//
+// Returns:
+//   - An image.Image containing the final image with the watermark applied.
+//   - An error if the configuration is invalid or the placer is nil.
+//
+// Example:
+//
+//	placer := PlacerFunc(func(inputImg image.Image, wmBounds image.Rectangle) []image.Point {
+//		box := detectProduct(inputImg)
+//		return []image.Point{{X: box.Max.X - wmBounds.Dx(), Y: box.Max.Y}}
+//	})
+//	result, err := ApplyWithPlacer(inputImg, watermarkImg, GeneralConfig{WatermarkWidthPercent: 15, OpacityAlpha: 0.7}, placer)
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+func ApplyWithPlacer(
+	inputImg image.Image,
+	watermarkImg image.Image,
+	config GeneralConfig,
+	placer Placer,
+) (image.Image, error) {
+	if err := validatePlacer(config, placer); err != nil {
+		return nil, fmt.Errorf("invalid watermark configuration: %w", err)
+	}
+
+	result, _ := applyWatermark(inputImg, watermarkImg, config, placer)
+
+	return result, nil
+}
+
+// BatchApplyWithPlacer applies a watermark to a batch of input images concurrently using a custom Placer.
+//
+// Parameters:
+//   - inputImgs: A slice of input images to which the watermark will be applied.
+//   - watermarkImg: The watermark image to overlay on each input image.
+//   - config: GeneralConfig containing the watermark appearance and concurrency settings.
+//   - placer: The Placer that computes where the watermark is drawn on each image. It must be safe for concurrent use.
+//
+// Returns:
+//   - A slice of image.Image objects containing the final images with the watermark applied.
+//   - An error if the configuration is invalid, the placer is nil or an input image exceeds MaxInputPixels.
+func BatchApplyWithPlacer(
+	inputImgs []image.Image,
+	watermarkImg image.Image,
+	config GeneralConfig,
+	placer Placer,
+) ([]image.Image, error) {
+	if err := validatePlacer(config, placer); err != nil {
+		return nil, fmt.Errorf("invalid watermark configuration: %w", err)
+	}
+
+	if err := checkInputPixels(inputImgs, config.MaxInputPixels); err != nil {
+		return nil, err
+	}
+
+	results, _ := batchApplyWatermark(inputImgs, watermarkImg, config, placer)
+
+	return results, nil
+}
+
+// validatePlacer validates the GeneralConfig and makes sure a Placer was provided.
+//
+// Returns:
+//   - An error describing the first invalid value found, or nil if both are valid.
+func validatePlacer(config GeneralConfig, placer Placer) error {
+	if err := config.validate(); err != nil {
+		return err
+	}
+
+	if placer == nil {
+		return errors.New("placer is required")
+	}
+
+	return nil
+}
+
+// applyWatermark runs the watermarking pipeline for a single image with an already validated configuration.
+//
+// Parameters:
+//   - inputImg: The input image to which the watermark will be applied.
+//   - watermarkImg: The original watermark image.
+//   - config: GeneralConfig containing the watermark appearance settings.
+//   - placer: The Placer that computes where the watermark is drawn.
+//
+// Returns:
+//   - An image.Image containing the final image with the watermark applied, in the pixel format of the input
+//     unless ForceRGBA is set.
+//   - A Report with the positions, variants and opacity used.
+func applyWatermark(inputImg, watermarkImg image.Image, config GeneralConfig, placer Placer) (image.Image, Report) {
+	preparedWM := prepareWatermark(watermarkImg, config)
+
+	return renderWatermark(inputImg, preparedWM, config, placer)
+}
+
+// batchApplyWatermark runs the watermarking pipeline for a batch of images with an already validated configuration.
+//
+// The watermark is preprocessed once, and only resized, rotated, decorated and faded per input image.
+//
+// Parameters:
+//   - inputImgs: A slice of input images to which the watermark will be applied.
+//   - watermarkImg: The original watermark image.
+//   - config: GeneralConfig containing the watermark appearance and concurrency settings.
+//   - placer: The Placer that computes where the watermark is drawn.
+//
+// Returns:
+//   - A slice of image.Image objects containing the final images with the watermark applied.
+//   - A slice of Report objects, one per input image, in the same order.
+func batchApplyWatermark(inputImgs []image.Image, watermarkImg image.Image, config GeneralConfig, placer Placer) ([]image.Image, []Report) {
+	preparedWM := prepareWatermark(watermarkImg, config)
+	results := make([]image.Image, len(inputImgs))
+	reports := make([]Report, len(inputImgs))
+
+	processBatch(len(inputImgs), config.MaxWorkers, func(index int) {
+		results[index], reports[index] = renderWatermark(inputImgs[index], preparedWM, config, placer)
+	})
+
+	return results, reports
+}
+
+// renderWatermark resizes and rotates the preprocessed watermark for the input image, places it and draws it onto a new canvas.
+//
+// Parameters:
+//   - inputImg: The input image used as the base canvas and as reference for sizing and positioning.
+//   - preparedWM: The watermark returned by prepareWatermark.
+//   - config: GeneralConfig containing the watermark appearance settings.
+//   - placer: The Placer that computes where the watermark is drawn.
+//
+// Returns:
+//   - An image.Image containing the input image with the watermark applied, in the pixel format of the input
+//     unless ForceRGBA is set.
+//   - A Report with the positions, variants and opacity used.
+func renderWatermark(inputImg image.Image, preparedWM preparedWatermark, config GeneralConfig, placer Placer) (image.Image, Report) {
+	canvas := generateBaseCanvas(inputImg)
+
+	var base *image.RGBA
+	if !config.ForceRGBA && keepsInputFormat(inputImg) {
+		base = cloneRGBA(canvas)
+	}
+
+	report := drawLayer(canvas, inputImg, preparedWM, config, placer, BlendNormal)
+
+	var result image.Image = canvas
+	if base != nil {
+		result = matchInputFormat(canvas, base, inputImg)
+	}
+
+	return result, report
+}
+
+// drawLayer resizes and rotates the preprocessed watermark for the input image, places it and draws it onto an existing canvas.
+//
+// Parameters:
+//   - canvas: The RGBA image onto which the watermarks will be drawn.
+//   - inputImg: The input image used as reference for sizing and positioning.
+//   - preparedWM: The watermark returned by prepareWatermark.
+//   - config: GeneralConfig containing the watermark appearance settings.
+//   - placer: The Placer that computes where the watermark is drawn.
+//   - mode: The blend mode used to combine the watermark with the canvas.
+//
+// Returns:
+//   - A Report with the positions, variants and opacity used.
+func drawLayer(canvas *image.RGBA, inputImg image.Image, preparedWM preparedWatermark, config GeneralConfig, placer Placer, mode BlendMode) Report {
+	layout := planLayer(canvas, inputImg, preparedWM, config, placer)
+	layout.paint(canvas, inputImg, config, mode)
+
+	return layout.report
+}
+
+// layerLayout holds the image-dependent decisions of a layer, so they can be reused on similar canvases
+// such as the frames of an animation.
+//
+// Fields:
+//   - footprint: The bounds of the first candidate, used for placement.
+//   - faded: The fitted candidates, with the opacity mask and the uniform opacity applied.
+//   - factors: The opacity multiplier of each position (vignette and faded detections).
+//   - clipped: One value per position that is true when the watermark must be clipped by the exclusions.
+//   - exclusions: The exclusion map, or nil when no area is excluded.
+//   - report: The decisions reported to the caller.
+type layerLayout struct {
+	footprint  image.Rectangle
+	faded      []*image.NRGBA
+	factors    []float64
+	clipped    []bool
+	exclusions *exclusionMap
+	report     Report
+}
+
+// planLayer fits the watermark candidates and takes every placement and appearance decision for a canvas.
+//
+// When adaptive variant selection is enabled, every candidate is fitted and the one with the best contrast
+// is chosen independently for each position. When automatic opacity is enabled, the opacity is measured against
+// the canvas under all positions before the watermarks are faded. Exclusions filter the positions
+// returned by the placer before any of these measurements, and detected regions are added to them when
+// detections must be avoided. The opacity mask is applied before the uniform opacity, and the vignette
+// and faded detections then fade each position independently.
+//
+// Parameters:
+//   - canvas: The RGBA image the watermarks will be drawn onto, used for measurements.
+//   - inputImg: The input image used as reference for sizing, positioning and detection.
+//   - preparedWM: The watermark returned by prepareWatermark.
+//   - config: GeneralConfig containing the watermark appearance settings.
+//   - placer: The Placer that computes where the watermark is drawn.
+//
+// Returns:
+//   - A layerLayout ready to be painted.
+func planLayer(canvas *image.RGBA, inputImg image.Image, preparedWM preparedWatermark, config GeneralConfig, placer Placer) layerLayout {
+	candidates := make([]*image.NRGBA, len(preparedWM.candidates))
+	for i, candidate := range preparedWM.candidates {
+		candidates[i] = toNRGBA(fitWatermark(candidate, inputImg, config))
+	}
+
+	footprint := candidates[0].Bounds()
+	positions := placer.Place(inputImg, footprint)
+
+	var detections []image.Rectangle
+	if config.Detection != nil {
+		detections = config.Detection.detect(inputImg)
+	}
+
+	excluded := config.Exclusions
+	if len(detections) > 0 && config.Detection.Action == DetectionAvoid {
+		merged := Exclusions{}
+		if excluded != nil {
+			merged = *excluded
+		}
+		merged.Rects = append(append([]image.Rectangle(nil), merged.Rects...), detections...)
+		excluded = &merged
+	}
+
+	var exclusions *exclusionMap
+	var clipped []bool
+	var rejections []Rejection
+	if excluded != nil {
+		exclusions = newExclusionMap(*excluded, inputImg.Bounds())
+		positions, clipped, rejections = exclusions.filter(positions, footprint.Size())
+	}
+
+	variants := make([]int, len(positions))
+	if len(candidates) > 1 {
+		variants = chooseVariants(canvas, candidates, positions)
+	}
+
+	opacity := config.OpacityAlpha
+	if config.AutoOpacity != nil {
+		opacity = config.AutoOpacity.opacity(canvas, candidates, positions, variants)
+	}
+
+	faded := make([]*image.NRGBA, len(candidates))
+	for i, candidate := range candidates {
+		faded[i] = candidate
+		if config.OpacityMask != nil {
+			faded[i] = config.OpacityMask.apply(faded[i])
+		}
+		if opacity < 1 {
+			faded[i] = applyOpacity(faded[i], opacity)
+		}
+	}
+
+	factors := make([]float64, len(positions))
+	for i, pos := range positions {
+		factors[i] = 1
+		if config.Vignette != nil {
+			factors[i] *= config.Vignette.factor(inputImg.Bounds(), pos.Add(footprint.Size().Div(2)))
+		}
+		if config.Detection != nil && config.Detection.Action == DetectionFade {
+			rect := image.Rectangle{Min: pos, Max: pos.Add(footprint.Size())}
+			for _, detection := range detections {
+				if detection.Overlaps(rect) {
+					factors[i] *= config.Detection.FadeOpacity
+					break
+				}
+			}
+		}
+	}
+
+	return layerLayout{
+		footprint:  footprint,
+		faded:      faded,
+		factors:    factors,
+		clipped:    clipped,
+		exclusions: exclusions,
+		report:     Report{Positions: positions, Variants: variants, Opacity: opacity, Rejections: rejections, Detections: detections},
+	}
+}
+
+// paint draws the planned watermarks onto a canvas.
+//
+// The underlay removes the watermark pixels that fall on ink, and ClipToInputAlpha masks them by the alpha
+// of the canvas before the layer is drawn. Both are measured on the given canvas and input image.
+//
+// Parameters:
+//   - canvas: The RGBA image onto which the watermarks will be drawn.
+//   - inputImg: The input image used to detect ink.
+//   - config: GeneralConfig containing the watermark appearance settings.
+//   - mode: The blend mode used to combine the watermark with the canvas.
+func (l layerLayout) paint(canvas *image.RGBA, inputImg image.Image, config GeneralConfig, mode BlendMode) {
+	positions, variants := l.report.Positions, l.report.Variants
+
+	var ink []bool
+	if config.Underlay != nil && len(positions) > 0 {
+		ink = config.Underlay.ink(inputImg)
+	}
+
+	var inputAlpha []uint8
+	if config.ClipToInputAlpha && len(positions) > 0 {
+		inputAlpha = canvasAlpha(canvas)
+	}
+
+	fadedLevels := make(map[[2]int]*image.NRGBA)
+
+	for i, pos := range positions {
+		wm := l.faded[variants[i]]
+
+		level := int(math.Round(l.factors[i] * fadeLevels))
+		if level == 0 {
+			continue
+		}
+
+		if level < fadeLevels {
+			key := [2]int{variants[i], level}
+			if fadedLevels[key] == nil {
+				fadedLevels[key] = applyOpacity(wm, float64(level)/fadeLevels)
+			}
+			wm = fadedLevels[key]
+		}
+
+		drawPos := variantPosition(l.footprint, wm.Bounds(), pos)
+		if l.clipped != nil && l.clipped[i] {
+			wm = l.exclusions.clip(wm, drawPos)
+		}
+		if ink != nil {
+			wm = clipToMask(wm, drawPos, inputImg.Bounds(), ink)
+		}
+		if inputAlpha != nil {
+			wm = maskByAlpha(wm, drawPos, canvas.Bounds(), inputAlpha)
+		}
+
+		drawWatermarkBlended(canvas, wm, drawPos, mode, config.LinearLight)
+	}
+}
@@ -0,0 +1,176 @@
+package imagewatermark
+
+import (
+	"image"
+	"image/color"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestApplyWithPlacer(t *testing.T) {
+	white := color.NRGBA{R: 255, G: 255, B: 255, A: 255}
+	red := color.NRGBA{R: 255, A: 255}
+
+	tests := []struct {
+		name      string
+		positions []image.Point
+		want      map[image.Point]color.NRGBA
+	}{
+		{
+			name:      "no positions",
+			positions: nil,
+			want:      map[image.Point]color.NRGBA{{0, 0}: white, {50, 50}: white},
+		},
+		{
+			name:      "several positions",
+			positions: []image.Point{{0, 0}, {60, 30}},
+			want:      map[image.Point]color.NRGBA{{0, 0}: red, {19, 19}: red, {20, 20}: white, {65, 35}: red, {79, 49}: red, {80, 30}: white},
+		},
+		{
+			name:      "partly outside",
+			positions: []image.Point{{-10, 90}},
+			want:      map[image.Point]color.NRGBA{{0, 99}: red, {9, 90}: red, {10, 90}: white},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var gotBounds image.Rectangle
+			placer := PlacerFunc(func(inputImg image.Image, watermarkBounds image.Rectangle) []image.Point {
+				gotBounds = watermarkBounds
+				return tt.positions
+			})
+
+			config := GeneralConfig{OpacityAlpha: 1, WatermarkWidthPercent: 20}
+			result, report, err := ApplyWithReport(uniformImage(100, 100, white), uniformImage(40, 40, red), config, placer)
+			if err != nil {
+				t.Fatalf("ApplyWithReport: %v", err)
+			}
+
+			if gotBounds.Dx() != 20 || gotBounds.Dy() != 20 {
+				t.Errorf("placer got watermark bounds %v, want 20x20", gotBounds)
+			}
+			if !slices.Equal(report.Positions, tt.positions) {
+				t.Errorf("report positions = %v, want %v", report.Positions, tt.positions)
+			}
+			for p, want := range tt.want {
+				if got := nrgbaAt(result, p.X, p.Y); got != want {
+					t.Errorf("pixel %v = %v, want %v", p, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestBuiltinPlacers(t *testing.T) {
+	input := image.NewRGBA(image.Rect(0, 0, 100, 60))
+	watermarkBounds := image.Rect(0, 0, 20, 10)
+
+	tests := []struct {
+		name   string
+		placer Placer
+		want   []image.Point
+	}{
+		{
+			name:   "single bottom right",
+			placer: SingleConfig{VerticalAlign: VerticalBottom, HorizontalAlign: HorizontalRight, Spacing: 5},
+			want:   []image.Point{{75, 45}},
+		},
+		{
+			name:   "single middle",
+			placer: SingleConfig{VerticalAlign: VerticalMiddle, HorizontalAlign: HorizontalMiddle},
+			want:   []image.Point{{40, 25}},
+		},
+		{
+			name:   "grid",
+			placer: GridConfig{GridSpacingX: 20, GridSpacingY: 10},
+			want:   []image.Point{{0, 0}, {40, 0}, {80, 0}, {0, 20}, {40, 20}, {80, 20}, {0, 40}, {40, 40}, {80, 40}},
+		},
+		{
+			name:   "grid with offset",
+			placer: GridConfig{GridSpacingX: 30, GridSpacingY: 40, OffsetX: 10, OffsetY: 5},
+			want:   []image.Point{{10, 5}, {60, 5}, {10, 55}, {60, 55}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.placer.Place(input, watermarkBounds); !slices.Equal(got, tt.want) {
+				t.Errorf("Place() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidatePlacer(t *testing.T) {
+	placer := PlacerFunc(func(image.Image, image.Rectangle) []image.Point { return nil })
+
+	tests := []struct {
+		name    string
+		config  GeneralConfig
+		placer  Placer
+		wantErr string
+	}{
+		{"valid", GeneralConfig{OpacityAlpha: 0.5, WatermarkWidthPercent: 10}, placer, ""},
+		{"nil placer", GeneralConfig{OpacityAlpha: 0.5, WatermarkWidthPercent: 10}, nil, "placer is required"},
+		{"invalid config", GeneralConfig{WatermarkWidthPercent: 10}, placer, "opacity"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ApplyWithPlacer(image.NewRGBA(image.Rect(0, 0, 10, 10)), uniformImage(5, 5, color.NRGBA{A: 255}), tt.config, tt.placer)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("ApplyWithPlacer() = %v, want nil", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("ApplyWithPlacer() = %v, want error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestApplyClipToInputAlpha(t *testing.T) {
+	red := color.NRGBA{R: 255, A: 255}
+
+	// The left half of the input is fully transparent, the right half is opaque white, and one column is half transparent.
+	input := splitImage(100, 100, color.NRGBA{}, color.NRGBA{R: 255, G: 255, B: 255, A: 255})
+	for y := 0; y < 100; y++ {
+		input.SetNRGBA(75, y, color.NRGBA{R: 255, G: 255, B: 255, A: 128})
+	}
+
+	tests := []struct {
+		name string
+		clip bool
+		want map[image.Point]color.NRGBA
+	}{
+		{"clipped", true, map[image.Point]color.NRGBA{{10, 10}: {}, {49, 90}: {}, {50, 10}: red, {75, 10}: {R: 255, G: 83, B: 83, A: 192}}},
+		{"not clipped", false, map[image.Point]color.NRGBA{{10, 10}: red, {49, 90}: red, {50, 10}: red, {75, 10}: red}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := GeneralConfig{OpacityAlpha: 1, WatermarkWidthPercent: 100, ClipToInputAlpha: tt.clip}
+			result, err := ApplyWithPlacer(input, uniformImage(10, 10, red), config, SingleConfig{})
+			if err != nil {
+				t.Fatalf("ApplyWithPlacer: %v", err)
+			}
+
+			for p, want := range tt.want {
+				got := nrgbaAt(result, p.X, p.Y)
+				if want.A == 0 {
+					if got.A != 0 {
+						t.Errorf("pixel %v = %v, want fully transparent", p, got)
+					}
+					continue
+				}
+				if got != want {
+					t.Errorf("pixel %v = %v, want %v", p, got, want)
+				}
+			}
+		})
+	}
+}
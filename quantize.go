@@ -0,0 +1,283 @@
+package imagewatermark
+
+import (
+	"image"
+	"image/color"
+	"sort"
+)
+
+// quantizeAlphaThreshold is the alpha below which a pixel is mapped to the transparent palette entry.
+const quantizeAlphaThreshold = 128
+
+// histogramEntry accumulates the pixels of one 15-bit color bucket.
+type histogramEntry struct {
+	key              int
+	count            int
+	sumR, sumG, sumB int
+}
+
+// channel returns the 5-bit value of a channel (0 = red, 1 = green, 2 = blue) of the bucket.
+func (e histogramEntry) channel(c int) int {
+	return e.key >> (10 - 5*c) & 0x1F
+}
+
+// colorBox is a set of histogram buckets that will be represented by a single palette color.
+type colorBox struct {
+	entries []histogramEntry
+	count   int
+}
+
+// widestChannel returns the channel with the largest range in the box, and that range.
+func (b colorBox) widestChannel() (int, int) {
+	bestChannel, bestRange := 0, -1
+
+	for c := 0; c < 3; c++ {
+		low, high := 31, 0
+		for _, e := range b.entries {
+			v := e.channel(c)
+			low, high = min(low, v), max(high, v)
+		}
+		if high-low > bestRange {
+			bestChannel, bestRange = c, high-low
+		}
+	}
+
+	return bestChannel, bestRange
+}
+
+// average returns the count-weighted mean color of the box.
+func (b colorBox) average() color.NRGBA {
+	var r, g, bl int
+	for _, e := range b.entries {
+		r += e.sumR
+		g += e.sumG
+		bl += e.sumB
+	}
+
+	return color.NRGBA{R: uint8(r / b.count), G: uint8(g / b.count), B: uint8(bl / b.count), A: 255}
+}
+
+// quantizer maps colors to the nearest entry of a palette built with the median cut algorithm.
+//
+// Fields:
+//   - palette: The palette, including a trailing transparent entry when the image has transparency.
+//   - transparent: The index of the transparent entry, or -1 when there is none.
+//   - lookup: Cache of the nearest palette index for each 15-bit color bucket, or -1 when not computed yet.
+type quantizer struct {
+	palette     color.Palette
+	transparent int
+	lookup      []int16
+}
+
+// newQuantizer builds a palette of at most maxColors entries for the image.
+//
+// Pixels with an alpha below 50% are considered transparent and, when present, reserve one palette entry.
+//
+// Parameters:
+//   - img: The image whose colors are analyzed, with bounds starting at the origin.
+//   - maxColors: The maximum number of palette entries (2 to 256).
+//
+// Returns:
+//   - A pointer to the quantizer.
+func newQuantizer(img *image.NRGBA, maxColors int) *quantizer {
+	histogram := make([]histogramEntry, 1<<15)
+	transparent := false
+
+	for i := 0; i < len(img.Pix); i += 4 {
+		p := img.Pix[i : i+4 : i+4]
+		if p[3] < quantizeAlphaThreshold {
+			transparent = true
+			continue
+		}
+
+		key := int(p[0]>>3)<<10 | int(p[1]>>3)<<5 | int(p[2]>>3)
+		e := &histogram[key]
+		e.key = key
+		e.count++
+		e.sumR += int(p[0])
+		e.sumG += int(p[1])
+		e.sumB += int(p[2])
+	}
+
+	colors := maxColors
+	if transparent {
+		colors--
+	}
+
+	q := &quantizer{transparent: -1, lookup: make([]int16, 1<<15)}
+	for i := range q.lookup {
+		q.lookup[i] = -1
+	}
+
+	for _, box := range medianCut(histogram, colors) {
+		q.palette = append(q.palette, box.average())
+	}
+
+	if transparent {
+		q.transparent = len(q.palette)
+		q.palette = append(q.palette, color.NRGBA{})
+	}
+
+	if len(q.palette) == 0 {
+		q.palette = append(q.palette, color.NRGBA{A: 255})
+	}
+
+	return q
+}
+
+// medianCut splits the used histogram buckets into at most maxBoxes boxes of similar population.
+//
+// Parameters:
+//   - histogram: The 15-bit color histogram. Buckets with a zero count are ignored.
+//   - maxBoxes: The maximum number of boxes.
+//
+// Returns:
+//   - The boxes, each representing one palette color.
+func medianCut(histogram []histogramEntry, maxBoxes int) []colorBox {
+	initial := colorBox{}
+	for _, e := range histogram {
+		if e.count > 0 {
+			initial.entries = append(initial.entries, e)
+			initial.count += e.count
+		}
+	}
+
+	if initial.count == 0 || maxBoxes < 1 {
+		return nil
+	}
+
+	boxes := []colorBox{initial}
+
+	for len(boxes) < maxBoxes {
+		// Split the box with the largest population weighted by its color range.
+		best, bestScore, bestChannel := -1, 0, 0
+		for i, box := range boxes {
+			if len(box.entries) < 2 {
+				continue
+			}
+			channel, spread := box.widestChannel()
+			if score := box.count * (spread + 1); score > bestScore {
+				best, bestScore, bestChannel = i, score, channel
+			}
+		}
+
+		if best < 0 {
+			break
+		}
+
+		box := boxes[best]
+		sort.Slice(box.entries, func(a, b int) bool {
+			return box.entries[a].channel(bestChannel) < box.entries[b].channel(bestChannel)
+		})
+
+		split, accumulated := 1, 0
+		for i, e := range box.entries[:len(box.entries)-1] {
+			accumulated += e.count
+			if accumulated*2 >= box.count {
+				split = i + 1
+				break
+			}
+		}
+
+		low := colorBox{entries: box.entries[:split:split]}
+		high := colorBox{entries: box.entries[split:]}
+		for _, e := range low.entries {
+			low.count += e.count
+		}
+		high.count = box.count - low.count
+
+		boxes[best] = low
+		boxes = append(boxes, high)
+	}
+
+	return boxes
+}
+
+// index returns the palette index of the given color.
+func (q *quantizer) index(r, g, b, a uint8) uint8 {
+	if a < quantizeAlphaThreshold && q.transparent >= 0 {
+		return uint8(q.transparent)
+	}
+
+	key := int(r>>3)<<10 | int(g>>3)<<5 | int(b>>3)
+	if q.lookup[key] < 0 {
+		best, bestDistance := 0, 1<<30
+		for i, c := range q.palette {
+			if i == q.transparent {
+				continue
+			}
+			pc := c.(color.NRGBA)
+			dr, dg, db := int(pc.R)-int(r), int(pc.G)-int(g), int(pc.B)-int(b)
+			if distance := 2*dr*dr + 4*dg*dg + 3*db*db; distance < bestDistance {
+				best, bestDistance = i, distance
+			}
+		}
+		q.lookup[key] = int16(best)
+	}
+
+	return uint8(q.lookup[key])
+}
+
+// quantize converts an image to a paletted image of at most maxColors colors.
+//
+// With dithering, the quantization error of each pixel is diffused to its neighbors (Floyd-Steinberg),
+// which trades banding in gradients for fine noise.
+//
+// Parameters:
+//   - img: The image to quantize.
+//   - maxColors: The maximum number of palette entries (2 to 256).
+//   - dither: Whether to apply Floyd-Steinberg error diffusion.
+//
+// Returns:
+//   - A pointer to an image.Paletted with the same bounds as the input.
+func quantize(img image.Image, maxColors int, dither bool) *image.Paletted {
+	src := toNRGBA(img)
+	q := newQuantizer(src, maxColors)
+
+	result := image.NewPaletted(img.Bounds(), q.palette)
+	width, height := src.Bounds().Dx(), src.Bounds().Dy()
+
+	// Errors of the current and next rows, per channel, multiplied by 16, with one pixel of padding on each side.
+	var current, next []int32
+	if dither {
+		current = make([]int32, (width+2)*3)
+		next = make([]int32, (width+2)*3)
+	}
+
+	for y := 0; y < height; y++ {
+		srcRow := src.Pix[y*src.Stride:]
+		dstRow := result.Pix[y*result.Stride:]
+
+		for x := 0; x < width; x++ {
+			p := srcRow[x*4 : x*4+4 : x*4+4]
+			if !dither || (p[3] < quantizeAlphaThreshold && q.transparent >= 0) {
+				dstRow[x] = q.index(p[0], p[1], p[2], p[3])
+				continue
+			}
+
+			var target [3]int32
+			for c := range target {
+				target[c] = min(max(int32(p[c])+current[(x+1)*3+c]/16, 0), 255)
+			}
+
+			index := q.index(uint8(target[0]), uint8(target[1]), uint8(target[2]), p[3])
+			dstRow[x] = index
+
+			pc := q.palette[index].(color.NRGBA)
+			for c, value := range [3]uint8{pc.R, pc.G, pc.B} {
+				e := target[c] - int32(value)
+				current[(x+2)*3+c] += e * 7
+				next[x*3+c] += e * 3
+				next[(x+1)*3+c] += e * 5
+				next[(x+2)*3+c] += e
+			}
+		}
+
+		if dither {
+			current, next = next, current
+			clear(next)
+		}
+	}
+
+	return result
+}
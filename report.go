@@ -0,0 +1,91 @@
+package imagewatermark
+
+import (
+	"fmt"
+	"image"
+)
+
+// Report describes the decisions taken while watermarking a single image.
+//
+// Fields:
+//   - Positions: The top-left corners where the watermark was drawn, after exclusions were applied.
+//   - Variants: The index of the adaptive candidate drawn at each position (always 0 when Adaptive is not set).
+//   - Opacity: The opacity applied to the watermark, as chosen by AutoOpacity when it is enabled.
+//   - Rejections: The positions that overlapped an excluded area or an avoided detection, with the action taken.
+//   - Detections: The (padded) regions found by the detector, when Detection is enabled.
+type Report struct {
+	Positions  []image.Point
+	Variants   []int
+	Opacity    float64
+	Rejections []Rejection
+	Detections []image.Rectangle
+}
+
+// ApplyWithReport applies a watermark like ApplyWithPlacer and also reports the decisions taken for the image.
+//
+// SingleConfig and GridConfig can be passed directly as the placer, together with their GeneralConfig.
+//
+// Parameters:
+//   - inputImg: The input image to which the watermark will be applied.
+//   - watermarkImg: The watermark image to overlay on the input image.
+//   - config: GeneralConfig containing the watermark appearance settings.
+//   - placer: The Placer that computes where the watermark is drawn.
+//
+// Returns:
+//   - An image.Image containing the final image with the watermark applied.
+//   - A Report with the positions, variants and opacity used.
+//   - An error if the configuration is invalid or the placer is nil.
+//
+// Example:
+//
+//	config.AutoOpacity = &AutoOpacityConfig{Min: 0.2, Max: 0.8, Target: 2}
+//	result, report, err := ApplyWithReport(inputImg, watermarkImg, config.GeneralConfig, config)
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	log.Printf("opacity used: %.2f", report.Opacity)
+func ApplyWithReport(
+	inputImg image.Image,
+	watermarkImg image.Image,
+	config GeneralConfig,
+	placer Placer,
+) (image.Image, Report, error) {
+	if err := validatePlacer(config, placer); err != nil {
+		return nil, Report{}, fmt.Errorf("invalid watermark configuration: %w", err)
+	}
+
+	result, report := applyWatermark(inputImg, watermarkImg, config, placer)
+
+	return result, report, nil
+}
+
+// BatchApplyWithReport applies a watermark like BatchApplyWithPlacer and also reports the decisions taken for each image.
+//
+// Parameters:
+//   - inputImgs: A slice of input images to which the watermark will be applied.
+//   - watermarkImg: The watermark image to overlay on each input image.
+//   - config: GeneralConfig containing the watermark appearance and concurrency settings.
+//   - placer: The Placer that computes where the watermark is drawn on each image. It must be safe for concurrent use.
+//
+// Returns:
+//   - A slice of image.Image objects containing the final images with the watermark applied.
+//   - A slice of Report objects, one per input image, in the same order.
+//   - An error if the configuration is invalid, the placer is nil or an input image exceeds MaxInputPixels.
+func BatchApplyWithReport(
+	inputImgs []image.Image,
+	watermarkImg image.Image,
+	config GeneralConfig,
+	placer Placer,
+) ([]image.Image, []Report, error) {
+	if err := validatePlacer(config, placer); err != nil {
+		return nil, nil, fmt.Errorf("invalid watermark configuration: %w", err)
+	}
+
+	if err := checkInputPixels(inputImgs, config.MaxInputPixels); err != nil {
+		return nil, nil, err
+	}
+
+	results, reports := batchApplyWatermark(inputImgs, watermarkImg, config, placer)
+
+	return results, reports, nil
+}
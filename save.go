@@ -0,0 +1,736 @@
+package imagewatermark
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/disintegration/imaging"
+)
+
+// Format identifies an output image encoding.
+//
+// Supported values:
+//   - FormatPNG: Lossless, keeps the alpha channel.
+//   - FormatJPEG: Lossy, without alpha. Transparent pixels are flattened onto SaveOptions.Background.
+//   - FormatWebP: Lossless WebP, keeps the alpha channel. Usually smaller than PNG.
+type Format int
+
+const (
+	FormatPNG Format = iota
+	FormatJPEG
+	FormatWebP
+)
+
+// String returns the lowercase name of the format.
+func (f Format) String() string {
+	switch f {
+	case FormatPNG:
+		return "png"
+	case FormatJPEG:
+		return "jpeg"
+	case FormatWebP:
+		return "webp"
+	default:
+		return fmt.Sprintf("Format(%d)", int(f))
+	}
+}
+
+// hasAlpha reports whether the format can store transparency.
+func (f Format) hasAlpha() bool {
+	return f == FormatPNG || f == FormatWebP
+}
+
+// FormatFromFilename returns the Format matching the extension of a file name (case-insensitive).
+//
+// Parameters:
+//   - path: The file name or path, such as "output.png" or "photo.JPG".
+//
+// Returns:
+//   - The Format matching the extension.
+//   - An error if the extension is not supported.
+func FormatFromFilename(path string) (Format, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".png":
+		return FormatPNG, nil
+	case ".jpg", ".jpeg":
+		return FormatJPEG, nil
+	case ".webp":
+		return FormatWebP, nil
+	default:
+		return 0, fmt.Errorf("unsupported image format extension: %q", filepath.Ext(path))
+	}
+}
+
+// SaveOptions holds the encoding settings used by SaveImage and EncodeImage.
+//
+// Fields:
+//   - JPEGQuality: Quality of JPEG output (1-100, Default is 95). With MaxBytes, it is the highest quality tried.
+//   - Background: Color that transparent pixels are flattened onto when the format has no alpha channel
+//     (Default is white). Formats with alpha keep transparency untouched.
+//   - MaxBytes: Maximum size of the encoded image in bytes (Default is 0, no limit). JPEG quality is lowered
+//     with a binary search until the image fits, and the image is downscaled when MinScale allows it.
+//   - MinJPEGQuality: Lowest JPEG quality accepted to fit MaxBytes (1-100, Default is 60).
+//   - MinScale: Smallest scale factor accepted to fit MaxBytes, in (0, 1] (Default is 0, never downscale).
+//     The image is downscaled only when the lowest quality still exceeds the budget.
+//   - MatchSource: Optional information about the source image, as returned by OpenImageWithInfo. When the source
+//     is a JPEG, its estimated quality (but at least MinJPEGQuality when set) replaces JPEGQuality, so re-encoding
+//     neither bloats nor degrades the file.
+//   - Quantize: Optional lossy palette reduction of PNG output, for images with more colors than a palette holds.
+type SaveOptions struct {
+	JPEGQuality    int
+	Background     color.Color
+	MaxBytes       int
+	MinJPEGQuality int
+	MinScale       float64
+	MatchSource    *ImageInfo
+	Quantize       *QuantizeConfig
+}
+
+// QuantizeConfig reduces PNG output to a palette built with the median cut algorithm.
+//
+// Pixels with an alpha below 50% become fully transparent and the others fully opaque.
+//
+// Fields:
+//   - MaxColors: The maximum number of palette entries, including the transparent one (2-256, Default is 256).
+//   - Dither: Whether to diffuse the quantization error (Floyd-Steinberg) to hide banding in gradients.
+type QuantizeConfig struct {
+	MaxColors int
+	Dither    bool
+}
+
+// validate checks if the QuantizeConfig has a palette size within range.
+//
+// Returns:
+//   - An error if MaxColors is out of range, or nil if the configuration is valid.
+func (q QuantizeConfig) validate() error {
+	if q.MaxColors != 0 && (q.MaxColors < 2 || q.MaxColors > 256) {
+		return fmt.Errorf("max colors must be between 2 and 256: %d", q.MaxColors)
+	}
+
+	return nil
+}
+
+// maxColors returns the palette size, applying the default.
+func (q QuantizeConfig) maxColors() int {
+	if q.MaxColors == 0 {
+		return 256
+	}
+	return q.MaxColors
+}
+
+// SaveResult describes how an image was encoded.
+//
+// Fields:
+//   - Format: The output format.
+//   - Quality: The JPEG quality used, or 0 for lossless formats.
+//   - Scale: The scale factor applied to fit MaxBytes (1 when the image was not downscaled).
+//   - Width: The width of the encoded image.
+//   - Height: The height of the encoded image.
+//   - Bytes: The size of the encoded image in bytes.
+type SaveResult struct {
+	Format  Format
+	Quality int
+	Scale   float64
+	Width   int
+	Height  int
+	Bytes   int
+}
+
+// ErrMaxBytesExceeded is returned when an image cannot be encoded within SaveOptions.MaxBytes,
+// even at the lowest quality and scale allowed.
+var ErrMaxBytesExceeded = errors.New("encoded image exceeds the maximum size")
+
+// validate checks if the SaveOptions have values within range.
+//
+// Returns:
+//   - An error describing the first invalid value found, or nil if the options are valid.
+func (o SaveOptions) validate() error {
+	if o.JPEGQuality < 0 || o.JPEGQuality > 100 {
+		return fmt.Errorf("jpeg quality must be between 1 and 100: %d", o.JPEGQuality)
+	}
+
+	if o.MaxBytes < 0 {
+		return fmt.Errorf("max bytes must not be negative: %d", o.MaxBytes)
+	}
+
+	if o.MinJPEGQuality < 0 || o.MinJPEGQuality > 100 {
+		return fmt.Errorf("minimum jpeg quality must be between 1 and 100: %d", o.MinJPEGQuality)
+	}
+
+	if o.minJPEGQuality() > o.jpegQuality() {
+		return fmt.Errorf("minimum jpeg quality %d exceeds jpeg quality %d", o.minJPEGQuality(), o.jpegQuality())
+	}
+
+	if o.MinScale < 0 || o.MinScale > 1 {
+		return fmt.Errorf("minimum scale must be between 0 and 1: %v", o.MinScale)
+	}
+
+	if o.Quantize != nil {
+		if err := o.Quantize.validate(); err != nil {
+			return fmt.Errorf("invalid quantization: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// jpegQuality returns the JPEG quality, applying the source quality and the default.
+func (o SaveOptions) jpegQuality() int {
+	if o.MatchSource != nil && o.MatchSource.JPEGQuality > 0 {
+		return max(min(o.MatchSource.JPEGQuality, 100), o.MinJPEGQuality)
+	}
+	if o.JPEGQuality == 0 {
+		return 95
+	}
+	return o.JPEGQuality
+}
+
+// minJPEGQuality returns the lowest JPEG quality accepted to fit MaxBytes, applying the default.
+func (o SaveOptions) minJPEGQuality() int {
+	if o.MinJPEGQuality == 0 {
+		return min(60, o.jpegQuality())
+	}
+	return o.MinJPEGQuality
+}
+
+// SaveImage encodes an image and writes it to a file, choosing the format from the file extension.
+//
+// Transparency is preserved for formats that support it (PNG and WebP), so watermarked cut-outs stay transparent.
+//
+// Parameters:
+//   - img: The image to save, typically the result of one of the Apply functions.
+//   - path: The destination file path. Its extension selects the format.
+//   - options: SaveOptions with the encoding settings.
+//
+// Returns:
+//   - An error if the format is not supported, the options are invalid or the file cannot be written.
+//
+// Example:
+//
+//	err := SaveImage(result, "output.png", SaveOptions{})
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+func SaveImage(img image.Image, path string, options SaveOptions) error {
+	_, err := SaveImageWithResult(img, path, options)
+	return err
+}
+
+// SaveImageWithResult saves an image like SaveImage and also reports how it was encoded.
+//
+// The image is encoded in memory first, so no file is written if the encoding fails or the image does not
+// fit MaxBytes.
+//
+// Parameters:
+//   - img: The image to save.
+//   - path: The destination file path. Its extension selects the format.
+//   - options: SaveOptions with the encoding settings.
+//
+// Returns:
+//   - A SaveResult with the quality, scale and size used.
+//   - An error if the format is not supported, the options are invalid, the image does not fit MaxBytes
+//     (ErrMaxBytesExceeded) or the file cannot be written.
+//
+// Example:
+//
+//	result, err := SaveImageWithResult(img, "listing.jpg", SaveOptions{MaxBytes: 500 << 10, MinScale: 0.5})
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	log.Printf("saved %d bytes at quality %d", result.Bytes, result.Quality)
+func SaveImageWithResult(img image.Image, path string, options SaveOptions) (SaveResult, error) {
+	format, err := FormatFromFilename(path)
+	if err != nil {
+		return SaveResult{}, err
+	}
+
+	if err := options.validate(); err != nil {
+		return SaveResult{}, fmt.Errorf("invalid save options: %w", err)
+	}
+
+	// The image is encoded in memory first, so a failed encoding (or an image that does not fit MaxBytes)
+	// does not leave a truncated file at the destination.
+	var buf bytes.Buffer
+	result, err := EncodeImageWithResult(&buf, img, format, options)
+	if err != nil {
+		return SaveResult{}, err
+	}
+
+	return result, os.WriteFile(path, buf.Bytes(), 0o666)
+}
+
+// SaveImages saves several images concurrently, like SaveImageWithResult.
+//
+// Every image is attempted even if some fail.
+//
+// Parameters:
+//   - imgs: The images to save, typically the results of one of the BatchApply functions.
+//   - paths: The destination file paths, one per image.
+//   - options: SaveOptions with the encoding settings, shared by all images.
+//   - maxWorkers: The maximum number of images encoded at the same time (Default is the number of CPUs).
+//
+// Returns:
+//   - A slice of SaveResult objects, one per image, in the same order. Failed images have a zero SaveResult.
+//   - An error joining the errors of every failed image, or nil if all of them were saved.
+func SaveImages(imgs []image.Image, paths []string, options SaveOptions, maxWorkers int) ([]SaveResult, error) {
+	if len(imgs) != len(paths) {
+		return nil, fmt.Errorf("got %d images but %d paths", len(imgs), len(paths))
+	}
+
+	if err := options.validate(); err != nil {
+		return nil, fmt.Errorf("invalid save options: %w", err)
+	}
+
+	results := make([]SaveResult, len(imgs))
+	errs := make([]error, len(imgs))
+
+	processBatch(len(imgs), maxWorkers, func(index int) {
+		result, err := SaveImageWithResult(imgs[index], paths[index], options)
+		if err != nil {
+			errs[index] = fmt.Errorf("image %d (%s): %w", index, paths[index], err)
+			return
+		}
+		results[index] = result
+	})
+
+	return results, errors.Join(errs...)
+}
+
+// EncodeImage encodes an image in the given format and writes it to w.
+//
+// Parameters:
+//   - w: The destination writer.
+//   - img: The image to encode.
+//   - format: The output format.
+//   - options: SaveOptions with the encoding settings.
+//
+// Returns:
+//   - An error if the format is not supported, the options are invalid or the encoding fails.
+func EncodeImage(w io.Writer, img image.Image, format Format, options SaveOptions) error {
+	_, err := EncodeImageWithResult(w, img, format, options)
+	return err
+}
+
+// EncodeImageWithResult encodes an image like EncodeImage and also reports how it was encoded.
+//
+// Parameters:
+//   - w: The destination writer.
+//   - img: The image to encode.
+//   - format: The output format.
+//   - options: SaveOptions with the encoding settings.
+//
+// Returns:
+//   - A SaveResult with the quality, scale and size used.
+//   - An error if the format is not supported, the options are invalid, the image does not fit MaxBytes
+//     (ErrMaxBytesExceeded) or the encoding fails.
+func EncodeImageWithResult(w io.Writer, img image.Image, format Format, options SaveOptions) (SaveResult, error) {
+	if err := options.validate(); err != nil {
+		return SaveResult{}, fmt.Errorf("invalid save options: %w", err)
+	}
+
+	if format < FormatPNG || format > FormatWebP {
+		return SaveResult{}, fmt.Errorf("unsupported image format: %v", format)
+	}
+
+	if !format.hasAlpha() {
+		img = flattenImage(img, options.Background)
+	}
+
+	if options.MaxBytes > 0 {
+		data, result, err := encodeWithinBudget(img, format, options)
+		if err != nil {
+			return SaveResult{}, err
+		}
+
+		_, err = w.Write(data)
+		return result, err
+	}
+
+	quality := 0
+	if format == FormatJPEG {
+		quality = options.jpegQuality()
+	}
+
+	counter := &countingWriter{w: w}
+	if err := encodeFormat(counter, img, format, quality, options.Quantize); err != nil {
+		return SaveResult{}, err
+	}
+
+	bounds := img.Bounds()
+	return SaveResult{
+		Format:  format,
+		Quality: quality,
+		Scale:   1,
+		Width:   bounds.Dx(),
+		Height:  bounds.Dy(),
+		Bytes:   counter.n,
+	}, nil
+}
+
+// encodeFormat writes an image in the given format.
+//
+// Parameters:
+//   - w: The destination writer.
+//   - img: The image to encode, already flattened for formats without alpha.
+//   - format: The output format.
+//   - quality: The JPEG quality, ignored by other formats.
+//   - quantizeConfig: Optional palette reduction of PNG output.
+//
+// Returns:
+//   - An error if the encoding fails.
+func encodeFormat(w io.Writer, img image.Image, format Format, quality int, quantizeConfig *QuantizeConfig) error {
+	switch format {
+	case FormatJPEG:
+		return jpeg.Encode(w, img, &jpeg.Options{Quality: quality})
+	case FormatWebP:
+		return encodeWebP(w, img)
+	default:
+		return png.Encode(w, compactPNG(img, quantizeConfig))
+	}
+}
+
+// compactPNG converts an image to the type that image/png writes with the smallest color type without losing
+// any sample.
+//
+// Images with at most 256 colors (or the quantization limit) become exact palettes, opaque grayscale images
+// become image.Gray, and other images are quantized when quantizeConfig is set. image/png already drops the
+// alpha channel of opaque images.
+//
+// 16-bit images (image.Gray16, image.RGBA64 and image.NRGBA64) are checked at 16 bits: they are reduced to
+// 8 bits only when every sample is an 8-bit value scaled by 0x101, and otherwise stay 16-bit, becoming
+// image.Gray16 when opaque and grayscale. Only quantizeConfig reduces them with loss.
+//
+// Parameters:
+//   - img: The image to write.
+//   - quantizeConfig: Optional palette reduction for images with more colors.
+//
+// Returns:
+//   - An image.Paletted, image.Gray, image.Gray16, image.NRGBA, or the image itself when no smaller
+//     representation applies.
+func compactPNG(img image.Image, quantizeConfig *QuantizeConfig) image.Image {
+	switch img.(type) {
+	case *image.Paletted, *image.Gray:
+		return img
+	case *image.Gray16, *image.RGBA64, *image.NRGBA64:
+		src, ok := reduceTo8Bit(img)
+		if !ok {
+			return compactPNG16(img, quantizeConfig)
+		}
+		return compactNRGBA(img, src, quantizeConfig)
+	default:
+		return compactNRGBA(img, toNRGBA(img), quantizeConfig)
+	}
+}
+
+// compactNRGBA picks the smallest PNG representation of an image whose samples all fit 8 bits.
+//
+// Parameters:
+//   - img: The image to write.
+//   - src: The pixels of the image as an 8-bit image.NRGBA with its origin at (0, 0).
+//   - quantizeConfig: Optional palette reduction for images with more colors.
+//
+// Returns:
+//   - An image.Paletted, image.Gray, or src when no smaller representation applies.
+func compactNRGBA(img image.Image, src *image.NRGBA, quantizeConfig *QuantizeConfig) image.Image {
+	width, height := src.Bounds().Dx(), src.Bounds().Dy()
+
+	indexes := make(map[color.NRGBA]uint8)
+	var palette color.Palette
+	gray := true
+
+	for y := 0; y < height && (gray || indexes != nil); y++ {
+		row := src.Pix[y*src.Stride:]
+		for x := 0; x < width; x++ {
+			p := row[x*4 : x*4+4 : x*4+4]
+			if gray && (p[3] != 0xff || p[0] != p[1] || p[1] != p[2]) {
+				gray = false
+			}
+
+			if indexes == nil {
+				continue
+			}
+
+			c := color.NRGBA{R: p[0], G: p[1], B: p[2], A: p[3]}
+			if _, ok := indexes[c]; !ok {
+				if len(palette) == 256 {
+					// Too many colors for a palette.
+					indexes, palette = nil, nil
+					continue
+				}
+				indexes[c] = uint8(len(palette))
+				palette = append(palette, c)
+			}
+		}
+	}
+
+	fits := indexes != nil && (quantizeConfig == nil || len(palette) <= quantizeConfig.maxColors())
+
+	// Small palettes are written with 1, 2 or 4 bits per pixel, which beats 8-bit grayscale.
+	if fits && (len(palette) <= 16 || !gray) {
+		result := image.NewPaletted(img.Bounds(), palette)
+		for y := 0; y < height; y++ {
+			srcRow := src.Pix[y*src.Stride:]
+			dstRow := result.Pix[y*result.Stride:]
+			for x := 0; x < width; x++ {
+				p := srcRow[x*4 : x*4+4 : x*4+4]
+				dstRow[x] = indexes[color.NRGBA{R: p[0], G: p[1], B: p[2], A: p[3]}]
+			}
+		}
+		return result
+	}
+
+	if gray && (fits || quantizeConfig == nil) {
+		result := image.NewGray(img.Bounds())
+		for y := 0; y < height; y++ {
+			srcRow := src.Pix[y*src.Stride:]
+			dstRow := result.Pix[y*result.Stride:]
+			for x := 0; x < width; x++ {
+				dstRow[x] = srcRow[x*4]
+			}
+		}
+		return result
+	}
+
+	if quantizeConfig != nil {
+		return quantize(src, quantizeConfig.maxColors(), quantizeConfig.Dither)
+	}
+
+	// image/png ignores the origin, and src holds the 8-bit samples of 16-bit images.
+	return src
+}
+
+// compactPNG16 picks the smallest PNG representation of a 16-bit image whose samples do not all fit 8 bits.
+//
+// Parameters:
+//   - img: An image.Gray16, image.RGBA64 or image.NRGBA64.
+//   - quantizeConfig: Optional palette reduction, the only conversion that loses precision.
+//
+// Returns:
+//   - An image.Paletted when quantizeConfig is set, an image.Gray16 when the image is opaque and grayscale,
+//     or the image itself.
+func compactPNG16(img image.Image, quantizeConfig *QuantizeConfig) image.Image {
+	if quantizeConfig != nil {
+		return quantize(img, quantizeConfig.maxColors(), quantizeConfig.Dither)
+	}
+
+	if _, ok := img.(*image.Gray16); ok {
+		return img
+	}
+
+	bounds := img.Bounds()
+	result := image.NewGray16(bounds)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := nrgba64At(img, x, y)
+			if c.A != 0xffff || c.R != c.G || c.G != c.B {
+				return img
+			}
+			result.SetGray16(x, y, color.Gray16{Y: c.R})
+		}
+	}
+
+	return result
+}
+
+// reduceTo8Bit converts a 16-bit image to 8 bits when no sample loses precision.
+//
+// Samples are compared as image/png writes them (non-premultiplied), and a sample fits 8 bits when it is an
+// 8-bit value scaled by 0x101, such as 0x0000, 0x1212 or 0xffff.
+//
+// Parameters:
+//   - img: An image.Gray16, image.RGBA64 or image.NRGBA64.
+//
+// Returns:
+//   - A pointer to an image.NRGBA with its origin at (0, 0), or nil.
+//   - A bool that is false when a sample needs 16 bits.
+func reduceTo8Bit(img image.Image) (*image.NRGBA, bool) {
+	bounds := img.Bounds()
+	result := image.NewNRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		row := result.Pix[(y-bounds.Min.Y)*result.Stride:]
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := nrgba64At(img, x, y)
+			p := row[(x-bounds.Min.X)*4 : (x-bounds.Min.X)*4+4 : (x-bounds.Min.X)*4+4]
+			for i, v := range [4]uint16{c.R, c.G, c.B, c.A} {
+				if v != (v>>8)*0x101 {
+					return nil, false
+				}
+				p[i] = uint8(v >> 8)
+			}
+		}
+	}
+
+	return result, true
+}
+
+// nrgba64At returns a pixel of a 16-bit image with non-premultiplied samples, as image/png writes it, without
+// the allocation of At.
+//
+// Parameters:
+//   - img: An image.Gray16, image.RGBA64 or image.NRGBA64.
+//   - x: The column of the pixel.
+//   - y: The row of the pixel.
+//
+// Returns:
+//   - The color of the pixel.
+func nrgba64At(img image.Image, x, y int) color.NRGBA64 {
+	switch img := img.(type) {
+	case *image.Gray16:
+		v := img.Gray16At(x, y).Y
+		return color.NRGBA64{R: v, G: v, B: v, A: 0xffff}
+	case *image.NRGBA64:
+		return img.NRGBA64At(x, y)
+	default:
+		c := img.(*image.RGBA64).RGBA64At(x, y)
+		switch c.A {
+		case 0:
+			return color.NRGBA64{}
+		case 0xffff:
+			return color.NRGBA64(c)
+		}
+		a := uint32(c.A)
+		return color.NRGBA64{
+			R: uint16(uint32(c.R) * 0xffff / a),
+			G: uint16(uint32(c.G) * 0xffff / a),
+			B: uint16(uint32(c.B) * 0xffff / a),
+			A: c.A,
+		}
+	}
+}
+
+// encodeWithinBudget encodes an image with the highest quality and scale that fit SaveOptions.MaxBytes.
+//
+// JPEG quality is binary-searched between MinJPEGQuality and JPEGQuality. When even the lowest quality
+// (or the only encoding of a lossless format) is too large, the image is downscaled by the square root of
+// the size ratio, with a 5% margin, and the search is repeated until MinScale is reached.
+//
+// Parameters:
+//   - img: The image to encode, already flattened for formats without alpha.
+//   - format: The output format.
+//   - options: SaveOptions with a positive MaxBytes.
+//
+// Returns:
+//   - The encoded image.
+//   - A SaveResult with the quality, scale and size used.
+//   - An error wrapping ErrMaxBytesExceeded if the image does not fit, or the encoding error.
+func encodeWithinBudget(img image.Image, format Format, options SaveOptions) ([]byte, SaveResult, error) {
+	bounds := img.Bounds()
+	scale := 1.0
+
+	for {
+		scaled := img
+		if scale < 1 {
+			width := max(int(math.Round(float64(bounds.Dx())*scale)), 1)
+			height := max(int(math.Round(float64(bounds.Dy())*scale)), 1)
+			scaled = imaging.Resize(img, width, height, imaging.CatmullRom)
+		}
+
+		result := SaveResult{
+			Format: format,
+			Scale:  scale,
+			Width:  scaled.Bounds().Dx(),
+			Height: scaled.Bounds().Dy(),
+		}
+
+		encode := func(quality int) ([]byte, error) {
+			var buf bytes.Buffer
+			err := encodeFormat(&buf, scaled, format, quality, options.Quantize)
+			return buf.Bytes(), err
+		}
+
+		var best []byte
+		var smallest int
+
+		if format == FormatJPEG {
+			low, high := options.minJPEGQuality(), options.jpegQuality()
+			for low <= high {
+				quality := (low + high) / 2
+				data, err := encode(quality)
+				if err != nil {
+					return nil, SaveResult{}, err
+				}
+
+				if len(data) <= options.MaxBytes {
+					best, result.Quality = data, quality
+					low = quality + 1
+				} else {
+					smallest = len(data)
+					high = quality - 1
+				}
+			}
+		} else {
+			data, err := encode(0)
+			if err != nil {
+				return nil, SaveResult{}, err
+			}
+
+			if len(data) <= options.MaxBytes {
+				best = data
+			}
+			smallest = len(data)
+		}
+
+		if best != nil {
+			result.Bytes = len(best)
+			return best, result, nil
+		}
+
+		if options.MinScale == 0 || scale <= options.MinScale {
+			return nil, SaveResult{}, fmt.Errorf("%w: %d bytes at %dx%d, limit is %d bytes",
+				ErrMaxBytesExceeded, smallest, result.Width, result.Height, options.MaxBytes)
+		}
+
+		next := scale * math.Sqrt(float64(options.MaxBytes)/float64(smallest)) * 0.95
+		scale = max(min(next, scale*0.9), options.MinScale)
+	}
+}
+
+// countingWriter counts the bytes written through it.
+type countingWriter struct {
+	w io.Writer
+	n int
+}
+
+// Write writes p to the underlying writer and counts the written bytes.
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += n
+	return n, err
+}
+
+// flattenImage composites an image with transparency onto a solid background.
+//
+// Parameters:
+//   - img: The image to flatten.
+//   - background: The background color (Default is white).
+//
+// Returns:
+//   - The image itself when it is already opaque, or a new opaque image.RGBA.
+func flattenImage(img image.Image, background color.Color) image.Image {
+	if opaque, ok := img.(interface{ Opaque() bool }); ok && opaque.Opaque() {
+		return img
+	}
+
+	if background == nil {
+		background = color.White
+	}
+
+	bounds := img.Bounds()
+	result := image.NewRGBA(bounds)
+	draw.Draw(result, bounds, image.NewUniform(background), image.Point{}, draw.Src)
+	draw.Draw(result, bounds, img, bounds.Min, draw.Over)
+
+	return result
+}
@@ -0,0 +1,325 @@
+package imagewatermark
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSaveImageTransparency(t *testing.T) {
+	img := splitImage(20, 10, color.NRGBA{}, color.NRGBA{R: 255, A: 255})
+	img.SetNRGBA(15, 5, color.NRGBA{B: 255, A: 128})
+
+	tests := []struct {
+		name    string
+		file    string
+		options SaveOptions
+		want    map[image.Point]color.NRGBA
+		// tolerance allows for lossy encoding.
+		tolerance int
+	}{
+		{"png keeps alpha", "out.png", SaveOptions{}, map[image.Point]color.NRGBA{{2, 2}: {}, {12, 2}: {R: 255, A: 255}, {15, 5}: {B: 255, A: 128}}, 0},
+		{"webp keeps alpha", "out.webp", SaveOptions{}, map[image.Point]color.NRGBA{{2, 2}: {}, {12, 2}: {R: 255, A: 255}, {15, 5}: {B: 255, A: 128}}, 0},
+		{"jpeg flattens on white", "out.jpg", SaveOptions{}, map[image.Point]color.NRGBA{{2, 2}: {R: 255, G: 255, B: 255, A: 255}, {12, 2}: {R: 255, A: 255}}, 12},
+		{"jpeg flattens on background", "out.jpg", SaveOptions{Background: color.Black}, map[image.Point]color.NRGBA{{2, 2}: {A: 255}, {12, 2}: {R: 255, A: 255}}, 12},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), tt.file)
+			if err := SaveImage(img, path, tt.options); err != nil {
+				t.Fatalf("SaveImage: %v", err)
+			}
+
+			saved, err := OpenImage(path)
+			if err != nil {
+				t.Fatalf("OpenImage: %v", err)
+			}
+
+			for p, want := range tt.want {
+				got := nrgbaAt(saved, p.X, p.Y)
+				if want.A == 0 {
+					if got.A != 0 {
+						t.Errorf("pixel %v = %v, want fully transparent", p, got)
+					}
+					continue
+				}
+				if absInt(int(got.R)-int(want.R)) > tt.tolerance || absInt(int(got.G)-int(want.G)) > tt.tolerance ||
+					absInt(int(got.B)-int(want.B)) > tt.tolerance || got.A != want.A {
+					t.Errorf("pixel %v = %v, want %v", p, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestSaveImageFailureLeavesNoFile(t *testing.T) {
+	img := uniformImage(64, 64, color.NRGBA{R: 200, G: 100, B: 50, A: 255})
+
+	tests := []struct {
+		name    string
+		file    string
+		options SaveOptions
+		wantErr error
+	}{
+		{"exceeds max bytes", "out.jpg", SaveOptions{MaxBytes: 10}, ErrMaxBytesExceeded},
+		{"invalid options", "out.png", SaveOptions{JPEGQuality: 101}, nil},
+		{"unsupported extension", "out.bmp", SaveOptions{}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), tt.file)
+			err := SaveImage(img, path, tt.options)
+			if err == nil {
+				t.Fatal("SaveImage() = nil, want an error")
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("SaveImage() = %v, want %v", err, tt.wantErr)
+			}
+			if _, statErr := os.Stat(path); !errors.Is(statErr, os.ErrNotExist) {
+				t.Errorf("SaveImage() left a file at the destination: %v", statErr)
+			}
+		})
+	}
+}
+
+func TestSaveImageFailureKeepsExistingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "out.jpg")
+	if err := os.WriteFile(path, []byte("previous"), 0o666); err != nil {
+		t.Fatal(err)
+	}
+
+	err := SaveImage(uniformImage(64, 64, color.NRGBA{R: 200, A: 255}), path, SaveOptions{MaxBytes: 10})
+	if !errors.Is(err, ErrMaxBytesExceeded) {
+		t.Fatalf("SaveImage() = %v, want %v", err, ErrMaxBytesExceeded)
+	}
+
+	if data, _ := os.ReadFile(path); string(data) != "previous" {
+		t.Errorf("existing file content = %q, want it untouched", data)
+	}
+}
+
+// noiseImage returns an opaque image of deterministic noise, which compresses poorly.
+func noiseImage(width, height int) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	seed := uint32(1)
+	for i := range img.Pix {
+		seed = seed*1664525 + 1013904223
+		img.Pix[i] = uint8(seed >> 24)
+		if i%4 == 3 {
+			img.Pix[i] = 255
+		}
+	}
+	return img
+}
+
+// encodedSize returns the size of an image encoded in the given format and JPEG quality.
+func encodedSize(t *testing.T, img image.Image, format Format, quality int) int {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := encodeFormat(&buf, img, format, quality, nil); err != nil {
+		t.Fatalf("encodeFormat: %v", err)
+	}
+	return buf.Len()
+}
+
+func TestEncodeImageMaxBytes(t *testing.T) {
+	img := noiseImage(120, 80)
+	atQuality := func(quality int) int { return encodedSize(t, img, FormatJPEG, quality) }
+	pngSize := encodedSize(t, img, FormatPNG, 0)
+
+	tests := []struct {
+		name        string
+		format      Format
+		options     SaveOptions
+		wantQuality [2]int
+		wantScaled  bool
+		wantErr     bool
+	}{
+		{"fits at full quality", FormatJPEG, SaveOptions{MaxBytes: atQuality(95) + 1}, [2]int{95, 95}, false, false},
+		{"quality search", FormatJPEG, SaveOptions{MaxBytes: atQuality(80)}, [2]int{80, 94}, false, false},
+		{"quality search below the default floor", FormatJPEG, SaveOptions{MaxBytes: atQuality(30), MinJPEGQuality: 10}, [2]int{30, 59}, false, false},
+		{"custom maximum quality", FormatJPEG, SaveOptions{MaxBytes: atQuality(95), JPEGQuality: 70}, [2]int{70, 70}, false, false},
+		{"below the quality floor", FormatJPEG, SaveOptions{MaxBytes: atQuality(60) - 1}, [2]int{}, false, true},
+		{"custom quality floor", FormatJPEG, SaveOptions{MaxBytes: atQuality(85), MinJPEGQuality: 90}, [2]int{}, false, true},
+		{"downscaled", FormatJPEG, SaveOptions{MaxBytes: atQuality(60) / 2, MinScale: 0.25}, [2]int{60, 95}, true, false},
+		{"minimum scale too large", FormatJPEG, SaveOptions{MaxBytes: atQuality(60) / 10, MinScale: 0.9}, [2]int{}, false, true},
+		{"lossless fits", FormatPNG, SaveOptions{MaxBytes: pngSize}, [2]int{0, 0}, false, false},
+		{"lossless downscaled", FormatPNG, SaveOptions{MaxBytes: pngSize / 2, MinScale: 0.1}, [2]int{0, 0}, true, false},
+		{"lossless without downscaling", FormatPNG, SaveOptions{MaxBytes: pngSize - 1}, [2]int{}, false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			result, err := EncodeImageWithResult(&buf, img, tt.format, tt.options)
+			if tt.wantErr {
+				if !errors.Is(err, ErrMaxBytesExceeded) {
+					t.Fatalf("EncodeImageWithResult() = %v, want %v", err, ErrMaxBytesExceeded)
+				}
+				if buf.Len() != 0 {
+					t.Errorf("EncodeImageWithResult() wrote %d bytes on failure", buf.Len())
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("EncodeImageWithResult: %v", err)
+			}
+
+			if result.Bytes != buf.Len() || result.Bytes > tt.options.MaxBytes {
+				t.Errorf("result bytes = %d, written %d, want at most %d", result.Bytes, buf.Len(), tt.options.MaxBytes)
+			}
+			if result.Quality < tt.wantQuality[0] || result.Quality > tt.wantQuality[1] {
+				t.Errorf("result quality = %d, want between %d and %d", result.Quality, tt.wantQuality[0], tt.wantQuality[1])
+			}
+			if scaled := result.Scale < 1; scaled != tt.wantScaled || result.Scale < tt.options.MinScale {
+				t.Errorf("result scale = %v, want scaled %v and at least %v", result.Scale, tt.wantScaled, tt.options.MinScale)
+			}
+
+			decoded, _, err := image.Decode(&buf)
+			if err != nil {
+				t.Fatalf("image.Decode: %v", err)
+			}
+			if size := decoded.Bounds().Size(); size != image.Pt(result.Width, result.Height) {
+				t.Errorf("decoded size = %v, want %dx%d", size, result.Width, result.Height)
+			}
+			if !tt.wantScaled && (result.Width != 120 || result.Height != 80) {
+				t.Errorf("result size = %dx%d, want the original size", result.Width, result.Height)
+			}
+		})
+	}
+}
+
+func TestEncodeImageMaxBytesHighestQuality(t *testing.T) {
+	img := noiseImage(120, 80)
+	budget := encodedSize(t, img, FormatJPEG, 75)
+
+	result, err := EncodeImageWithResult(&bytes.Buffer{}, img, FormatJPEG, SaveOptions{MaxBytes: budget})
+	if err != nil {
+		t.Fatalf("EncodeImageWithResult: %v", err)
+	}
+
+	if next := encodedSize(t, img, FormatJPEG, result.Quality+1); next <= budget {
+		t.Errorf("quality %d was chosen, but quality %d also fits: %d <= %d bytes", result.Quality, result.Quality+1, next, budget)
+	}
+}
+
+func TestSaveImagesMaxBytes(t *testing.T) {
+	dir := t.TempDir()
+	imgs := []image.Image{uniformImage(40, 40, color.NRGBA{R: 255, A: 255}), noiseImage(120, 80)}
+	paths := []string{filepath.Join(dir, "flat.jpg"), filepath.Join(dir, "noise.jpg")}
+	options := SaveOptions{MaxBytes: encodedSize(t, imgs[1], FormatJPEG, 60) - 1}
+
+	results, err := SaveImages(imgs, paths, options, 2)
+	if !errors.Is(err, ErrMaxBytesExceeded) || !strings.Contains(err.Error(), "noise.jpg") {
+		t.Fatalf("SaveImages() = %v, want %v for noise.jpg", err, ErrMaxBytesExceeded)
+	}
+
+	if results[0].Quality != 95 || results[0].Bytes == 0 || results[1] != (SaveResult{}) {
+		t.Errorf("SaveImages() results = %+v, want the first image at quality 95 and a zero result for the second", results)
+	}
+	if _, err := os.Stat(paths[0]); err != nil {
+		t.Errorf("first image was not saved: %v", err)
+	}
+	if _, err := os.Stat(paths[1]); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("second image was saved: %v", err)
+	}
+}
+
+func TestCompactPNG(t *testing.T) {
+	grayLevels := func(img draw.Image, scale uint16) draw.Image {
+		bounds := img.Bounds()
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+			for x := bounds.Min.X; x < bounds.Max.X; x++ {
+				v := uint16((y*bounds.Dx() + x) % 256)
+				img.Set(x, y, color.Gray16{Y: v * scale})
+			}
+		}
+		return img
+	}
+	fill := func(img draw.Image, at func(x, y int) color.Color) draw.Image {
+		bounds := img.Bounds()
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+			for x := bounds.Min.X; x < bounds.Max.X; x++ {
+				img.Set(x, y, at(x, y))
+			}
+		}
+		return img
+	}
+	rect := image.Rect(0, 0, 32, 32)
+	twoColors := func(x, y int) color.Color {
+		if x < 16 {
+			return color.NRGBA{R: 255, A: 255}
+		}
+		return color.NRGBA{B: 255, A: 128}
+	}
+	deepColors := func(x, y int) color.Color {
+		return color.NRGBA64{R: uint16(x*2000 + 1), G: uint16(y * 2000), B: 0x1234, A: 0xffff}
+	}
+	deepGray := func(x, y int) color.Color {
+		v := uint16((x*32 + y) * 61)
+		return color.NRGBA64{R: v, G: v, B: v, A: 0xffff}
+	}
+
+	tests := []struct {
+		name     string
+		img      image.Image
+		quantize *QuantizeConfig
+		want     string
+		lossy    bool
+	}{
+		{"few colors", fill(image.NewRGBA(rect), twoColors), nil, "*image.Paletted", false},
+		{"grayscale", grayLevels(image.NewNRGBA(rect), 0x101), nil, "*image.Gray", false},
+		{"few gray levels", fill(image.NewNRGBA(rect), func(x, y int) color.Color { return color.Gray{Y: uint8(x % 4 * 80)} }), nil, "*image.Paletted", false},
+		{"many colors", noiseImage(32, 32), nil, "*image.NRGBA", false},
+		{"paletted", image.NewPaletted(rect, color.Palette{color.Black, color.White}), nil, "*image.Paletted", false},
+		{"gray", image.NewGray(rect), nil, "*image.Gray", false},
+		{"8-bit values in Gray16", grayLevels(image.NewGray16(rect), 0x101), nil, "*image.Gray", false},
+		{"16-bit gray", grayLevels(image.NewGray16(rect), 0xff), nil, "*image.Gray16", false},
+		{"16-bit gray in NRGBA64", fill(image.NewNRGBA64(rect), deepGray), nil, "*image.Gray16", false},
+		{"16-bit colors", fill(image.NewNRGBA64(rect), deepColors), nil, "*image.NRGBA64", false},
+		{"8-bit values in RGBA64", fill(image.NewRGBA64(rect), twoColors), nil, "*image.Paletted", false},
+		{"quantized", noiseImage(32, 32), &QuantizeConfig{MaxColors: 16}, "*image.Paletted", true},
+		{"quantized 16-bit", fill(image.NewNRGBA64(rect), deepColors), &QuantizeConfig{}, "*image.Paletted", true},
+		{"palette within the quantization limit", fill(image.NewRGBA(rect), twoColors), &QuantizeConfig{MaxColors: 2}, "*image.Paletted", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := fmt.Sprintf("%T", compactPNG(tt.img, tt.quantize)); got != tt.want {
+				t.Errorf("compactPNG() = %s, want %s", got, tt.want)
+			}
+			if tt.lossy {
+				return
+			}
+
+			var buf bytes.Buffer
+			if err := EncodeImage(&buf, tt.img, FormatPNG, SaveOptions{Quantize: tt.quantize}); err != nil {
+				t.Fatalf("EncodeImage: %v", err)
+			}
+			decoded, err := png.Decode(&buf)
+			if err != nil {
+				t.Fatalf("png.Decode: %v", err)
+			}
+
+			bounds := tt.img.Bounds()
+			for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+				for x := bounds.Min.X; x < bounds.Max.X; x++ {
+					want := color.NRGBA64Model.Convert(tt.img.At(x, y))
+					if got := color.NRGBA64Model.Convert(decoded.At(x, y)); got != want {
+						t.Fatalf("pixel (%d, %d) = %v, want %v", x, y, got, want)
+					}
+				}
+			}
+		})
+	}
+}
@@ -3,18 +3,17 @@ package imagewatermark
 import (
 	"fmt"
 	"image"
-	"runtime"
-	"sync"
 )
 
 // ApplySingle overlays a single watermark onto an input image at a specific position based on the provided configuration.
 //
 // The function performs the following steps:
 //  1. Validates the SingleConfig to ensure all settings are valid.
-//  2. Preprocesses the watermark (resize, rotate, and apply opacity).
+//  2. Preprocesses the watermark (resize, rotate at the final size, render effects, and apply opacity).
 //  3. Calculates the watermark position based on vertical/horizontal alignment and spacing.
 //  4. Creates an RGBA copy of the input image.
 //  5. Overlays the watermark onto the input image using the "Over" compositing operator.
+//  6. Converts the result back to the pixel format of the input (Gray, 16-bit, YCbCr, ...) unless ForceRGBA is set.
 //
 // Parameters:
 //   - inputImg: The input image to which the watermark will be applied.
@@ -50,31 +49,16 @@ func ApplySingle(
 		return nil, fmt.Errorf("invalid single watermark configuration: %w", err)
 	}
 
-	preparedWM := watermarkImg
+	result, _ := applyWatermark(inputImg, watermarkImg, config.GeneralConfig, config)
 
-	if config.OpacityAlpha < 1 {
-		preparedWM = applyOpacity(preparedWM, config.OpacityAlpha)
-	}
-
-	if config.RotationDegrees != 0 {
-		preparedWM = rotateImage(preparedWM, config.RotationDegrees)
-	}
-
-	preparedWM = resizeWatermark(preparedWM, inputImg, config.GeneralConfig)
-	watermarkPosition := getWatermarkPosition(preparedWM, inputImg, config.VerticalAlign, config.HorizontalAlign, config.Spacing)
-
-	canvas := generateBaseCanvas(inputImg)
-
-	drawWatermarkAtPosition(canvas, preparedWM, watermarkPosition)
-
-	return canvas, nil
+	return result, nil
 }
 
 // BatchApplySingle applies a single watermark to a batch of input images concurrently based on the provided configuration.
 //
 // This function performs the following steps:
 //  1. Validates the SingleConfig to ensure all settings are valid.
-//  2. Preprocesses the watermark (rotate and apply opacity) once for efficiency.
+//  2. Preprocesses the watermark once for efficiency, then resizes, rotates, decorates and fades it per image.
 //  3. Uses a worker pool to process multiple images concurrently, applying the watermark to each image.
 //  4. Calculates the watermark position for each image based on alignment and spacing settings.
 //  5. Returns a slice of images with the watermark applied.
@@ -86,7 +70,7 @@ func ApplySingle(
 //
 // Returns:
 //   - A slice of image.Image objects containing the final images with the watermark applied.
-//   - An error if any step fails, such as invalid configuration.
+//   - An error if any step fails, such as invalid configuration or an input image exceeding MaxInputPixels.
 //
 // Example:
 //
@@ -114,47 +98,11 @@ func BatchApplySingle(
 		return nil, fmt.Errorf("invalid single watermark configuration: %w", err)
 	}
 
-	maxWorkers := config.MaxWorkers
-	if maxWorkers <= 0 {
-		maxWorkers = runtime.NumCPU()
-	}
-
-	preparedWM := watermarkImg
-	if config.OpacityAlpha < 1 {
-		preparedWM = applyOpacity(preparedWM, config.OpacityAlpha)
-	}
-	if config.RotationDegrees != 0 {
-		preparedWM = rotateImage(preparedWM, config.RotationDegrees)
-	}
-
-	numImages := len(inputImgs)
-	results := make([]image.Image, numImages)
-
-	var wg sync.WaitGroup
-	sem := make(chan struct{}, maxWorkers)
-
-	for i := 0; i < numImages; i++ {
-		wg.Add(1)
-
-		go func(index int) {
-			defer wg.Done()
-
-			sem <- struct{}{}
-			defer func() { <-sem }()
-
-			currImage := inputImgs[index]
-			currentWM := resizeWatermark(preparedWM, currImage, config.GeneralConfig)
-
-			watermarkPosition := getWatermarkPosition(currentWM, currImage, config.VerticalAlign, config.HorizontalAlign, config.Spacing)
-
-			canvas := generateBaseCanvas(currImage)
-			drawWatermarkAtPosition(canvas, currentWM, watermarkPosition)
-
-			results[index] = canvas
-		}(i)
+	if err := checkInputPixels(inputImgs, config.GeneralConfig.MaxInputPixels); err != nil {
+		return nil, err
 	}
 
-	wg.Wait()
+	results, _ := batchApplyWatermark(inputImgs, watermarkImg, config.GeneralConfig, config)
 
 	return results, nil
 }
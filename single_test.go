@@ -0,0 +1,85 @@
+package imagewatermark
+
+import (
+	"image"
+	"image/color"
+	"slices"
+	"testing"
+)
+
+func TestSingleConfigPlace(t *testing.T) {
+	input := image.NewRGBA(image.Rect(0, 0, 200, 100))
+	watermarkBounds := image.Rect(0, 0, 40, 20)
+
+	tests := []struct {
+		name   string
+		config SingleConfig
+		want   image.Point
+	}{
+		{"anchor center", SingleConfig{Placement: PlacementAnchor, Anchor: Anchor{ImageX: 0.5, ImageY: 0.5, WatermarkX: 0.5, WatermarkY: 0.5}}, image.Pt(80, 40)},
+		{"anchor top left", SingleConfig{Placement: PlacementAnchor}, image.Pt(0, 0)},
+		{"anchor bottom right", SingleConfig{Placement: PlacementAnchor, Anchor: Anchor{ImageX: 1, ImageY: 1, WatermarkX: 1, WatermarkY: 1}}, image.Pt(160, 80)},
+		{"anchor fractional", SingleConfig{Placement: PlacementAnchor, Anchor: Anchor{ImageX: 0.3, ImageY: 0.8, WatermarkX: 0.5, WatermarkY: 0.5}}, image.Pt(40, 70)},
+		{"anchor rounds", SingleConfig{Placement: PlacementAnchor, Anchor: Anchor{ImageX: 0.1234, ImageY: 0.0051}}, image.Pt(25, 1)},
+		{"anchor past the edge", SingleConfig{Placement: PlacementAnchor, Anchor: Anchor{ImageX: 1, ImageY: 1}}, image.Pt(200, 100)},
+		{"absolute", SingleConfig{Placement: PlacementAbsolute, Position: image.Pt(13, 27)}, image.Pt(13, 27)},
+		{"absolute outside", SingleConfig{Placement: PlacementAbsolute, Position: image.Pt(-10, 95)}, image.Pt(-10, 95)},
+		{"absolute ignores alignment", SingleConfig{Placement: PlacementAbsolute, VerticalAlign: VerticalBottom, Spacing: 10}, image.Pt(0, 0)},
+		{"align", SingleConfig{VerticalAlign: VerticalTop, HorizontalAlign: HorizontalRight, Spacing: 10}, image.Pt(150, 10)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.config.Place(input, watermarkBounds)
+			if !slices.Equal(got, []image.Point{tt.want}) {
+				t.Errorf("Place() = %v, want [%v]", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSingleConfigValidatePlacement(t *testing.T) {
+	general := GeneralConfig{OpacityAlpha: 1, WatermarkWidthPercent: 10}
+
+	tests := []struct {
+		name    string
+		config  SingleConfig
+		wantErr string
+	}{
+		{"align", SingleConfig{GeneralConfig: general}, ""},
+		{"anchor", SingleConfig{GeneralConfig: general, Placement: PlacementAnchor, Anchor: Anchor{ImageX: 1, WatermarkY: 0.5}}, ""},
+		{"anchor out of range", SingleConfig{GeneralConfig: general, Placement: PlacementAnchor, Anchor: Anchor{ImageY: 1.5}}, "anchor image y"},
+		{"negative anchor", SingleConfig{GeneralConfig: general, Placement: PlacementAnchor, Anchor: Anchor{WatermarkX: -0.1}}, "anchor watermark x"},
+		{"anchor ignored when aligning", SingleConfig{GeneralConfig: general, Anchor: Anchor{ImageY: 1.5}}, ""},
+		{"negative absolute position", SingleConfig{GeneralConfig: general, Placement: PlacementAbsolute, Position: image.Pt(-5, -5)}, ""},
+		{"unknown placement", SingleConfig{GeneralConfig: general, Placement: PlacementAbsolute + 1}, "unknown placement mode"},
+		{"negative margin", SingleConfig{GeneralConfig: general, MarginX: Pixels(-1)}, "invalid margin x"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checkValidateError(t, tt.config.validate(), tt.wantErr)
+		})
+	}
+}
+
+func TestApplySingleAbsolute(t *testing.T) {
+	white := color.NRGBA{R: 255, G: 255, B: 255, A: 255}
+	red := color.NRGBA{R: 255, A: 255}
+
+	config := SingleConfig{
+		GeneralConfig: GeneralConfig{OpacityAlpha: 1, WatermarkWidthPercent: 10},
+		Placement:     PlacementAbsolute,
+		Position:      image.Pt(33, 44),
+	}
+	result, err := ApplySingle(uniformImage(100, 100, white), uniformImage(10, 10, red), config)
+	if err != nil {
+		t.Fatalf("ApplySingle: %v", err)
+	}
+
+	for p, want := range map[image.Point]color.NRGBA{{33, 44}: red, {42, 53}: red, {32, 44}: white, {43, 53}: white, {33, 54}: white} {
+		if got := nrgbaAt(result, p.X, p.Y); got != want {
+			t.Errorf("pixel %v = %v, want %v", p, got, want)
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package imagewatermark
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+
+	"github.com/disintegration/imaging"
+)
+
+// TintMode defines how the watermark is recolored by a Tint.
+//
+// Supported values:
+//   - TintSolid: Paints every pixel with Colors[0].
+//   - TintDuotone: Maps the luminance of each pixel from Colors[0] (shadows) to Colors[1] (highlights).
+//   - TintGradient: Paints a linear gradient through all Colors, evenly spaced, along Angle.
+type TintMode int
+
+const (
+	TintSolid TintMode = iota
+	TintDuotone
+	TintGradient
+)
+
+// Tint recolors the watermark while preserving its alpha, so a single logo file can be reused in any brand color.
+//
+// The tint is applied once to the main watermark, before it is sized, decorated and faded. Adaptive variants
+// are left untouched, while adaptive tint colors recolor the already tinted watermark.
+//
+// Fields:
+//   - Mode: How the colors are applied (Default is TintSolid).
+//   - Colors: The colors to paint. TintSolid uses exactly one, TintDuotone exactly two and TintGradient two or more.
+//     The alpha of each color multiplies the watermark alpha.
+//   - Angle: Direction of the gradient in degrees, counter-clockwise from left to right. Used only by TintGradient.
+//   - LuminanceAsAlpha: Derives the alpha from the luminance before recoloring, so black becomes opaque and
+//     white transparent. Useful for black-on-white logos without transparency.
+type Tint struct {
+	Mode             TintMode
+	Colors           []color.Color
+	Angle            float64
+	LuminanceAsAlpha bool
+}
+
+// validate checks if the Tint has a supported mode and the number of colors it requires.
+//
+// Returns:
+//   - An error describing the first invalid value found, or nil if the tint is valid.
+func (t Tint) validate() error {
+	switch t.Mode {
+	case TintSolid:
+		if len(t.Colors) != 1 {
+			return fmt.Errorf("solid tint requires exactly one color: %d", len(t.Colors))
+		}
+	case TintDuotone:
+		if len(t.Colors) != 2 {
+			return fmt.Errorf("duotone tint requires exactly two colors: %d", len(t.Colors))
+		}
+	case TintGradient:
+		if len(t.Colors) < 2 {
+			return fmt.Errorf("gradient tint requires at least two colors: %d", len(t.Colors))
+		}
+	default:
+		return fmt.Errorf("unknown tint mode: %d", t.Mode)
+	}
+
+	for i, c := range t.Colors {
+		if c == nil {
+			return fmt.Errorf("tint color %d is nil", i)
+		}
+	}
+
+	return nil
+}
+
+// apply recolors the watermark according to the tint settings.
+//
+// Parameters:
+//   - img: The watermark image.
+//
+// Returns:
+//   - A pointer to an image.NRGBA containing the recolored watermark.
+func (t Tint) apply(img image.Image) *image.NRGBA {
+	result := imaging.Clone(img)
+	bounds := result.Bounds()
+
+	stops := make([]color.NRGBA, len(t.Colors))
+	for i, c := range t.Colors {
+		stops[i] = color.NRGBAModel.Convert(c).(color.NRGBA)
+	}
+
+	// Gradient positions are the projection of each pixel on the gradient direction, normalized to [0, 1].
+	dirX, dirY := math.Cos(t.Angle*math.Pi/180), -math.Sin(t.Angle*math.Pi/180)
+	extent := math.Abs(dirX)*float64(bounds.Dx()) + math.Abs(dirY)*float64(bounds.Dy())
+	centerX, centerY := float64(bounds.Dx())/2, float64(bounds.Dy())/2
+
+	for y := 0; y < bounds.Dy(); y++ {
+		row := result.Pix[y*result.Stride:]
+
+		for x := 0; x < bounds.Dx(); x++ {
+			i := x * 4
+			luma := (0.2126*float64(row[i]) + 0.7152*float64(row[i+1]) + 0.0722*float64(row[i+2])) / 255
+			alpha := float64(row[i+3]) / 255
+
+			if t.LuminanceAsAlpha {
+				alpha *= 1 - luma
+			}
+
+			var c color.NRGBA
+			switch t.Mode {
+			case TintSolid:
+				c = stops[0]
+			case TintDuotone:
+				c = lerpNRGBA(stops[0], stops[1], luma)
+			case TintGradient:
+				position := 0.5
+				if extent > 0 {
+					position = ((float64(x)+0.5-centerX)*dirX+(float64(y)+0.5-centerY)*dirY)/extent + 0.5
+				}
+				c = gradientColor(stops, position)
+			}
+
+			row[i] = c.R
+			row[i+1] = c.G
+			row[i+2] = c.B
+			row[i+3] = uint8(math.Round(alpha * float64(c.A)))
+		}
+	}
+
+	return result
+}
+
+// gradientColor returns the color at a position of a gradient whose stops are evenly spaced.
+//
+// Parameters:
+//   - stops: The gradient colors, at least two.
+//   - position: The position along the gradient, clamped to [0, 1].
+//
+// Returns:
+//   - The interpolated color.
+func gradientColor(stops []color.NRGBA, position float64) color.NRGBA {
+	position = math.Min(math.Max(position, 0), 1) * float64(len(stops)-1)
+	index := min(int(position), len(stops)-2)
+
+	return lerpNRGBA(stops[index], stops[index+1], position-float64(index))
+}
+
+// lerpNRGBA linearly interpolates between two colors.
+//
+// Parameters:
+//   - from: The color at t = 0.
+//   - to: The color at t = 1.
+//   - t: The interpolation factor in [0, 1].
+//
+// Returns:
+//   - The interpolated color.
+func lerpNRGBA(from, to color.NRGBA, t float64) color.NRGBA {
+	lerp := func(a, b uint8) uint8 {
+		return uint8(math.Round(float64(a) + (float64(b)-float64(a))*t))
+	}
+
+	return color.NRGBA{
+		R: lerp(from.R, to.R),
+		G: lerp(from.G, to.G),
+		B: lerp(from.B, to.B),
+		A: lerp(from.A, to.A),
+	}
+}
@@ -0,0 +1,116 @@
+package imagewatermark
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+// rowImage returns a one pixel high image with the given colors from left to right.
+func rowImage(colors ...color.NRGBA) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, len(colors), 1))
+	for x, c := range colors {
+		img.SetNRGBA(x, 0, c)
+	}
+	return img
+}
+
+func TestTintApply(t *testing.T) {
+	white := color.NRGBA{R: 255, G: 255, B: 255, A: 255}
+	black := color.NRGBA{A: 255}
+	halfWhite := color.NRGBA{R: 255, G: 255, B: 255, A: 128}
+
+	tests := []struct {
+		name  string
+		tint  Tint
+		input *image.NRGBA
+		want  []color.NRGBA
+	}{
+		{
+			name:  "solid keeps alpha",
+			tint:  Tint{Colors: []color.Color{color.NRGBA{R: 255, A: 255}}},
+			input: rowImage(white, black, halfWhite, color.NRGBA{}),
+			want:  []color.NRGBA{{R: 255, A: 255}, {R: 255, A: 255}, {R: 255, A: 128}, {R: 255}},
+		},
+		{
+			name:  "solid color alpha multiplies",
+			tint:  Tint{Colors: []color.Color{color.NRGBA{G: 255, A: 128}}},
+			input: rowImage(white, halfWhite),
+			want:  []color.NRGBA{{G: 255, A: 128}, {G: 255, A: 64}},
+		},
+		{
+			name:  "duotone",
+			tint:  Tint{Mode: TintDuotone, Colors: []color.Color{color.NRGBA{R: 255, A: 255}, color.NRGBA{B: 255, A: 255}}},
+			input: rowImage(black, white, halfWhite),
+			want:  []color.NRGBA{{R: 255, A: 255}, {B: 255, A: 255}, {B: 255, A: 128}},
+		},
+		{
+			name:  "horizontal gradient",
+			tint:  Tint{Mode: TintGradient, Colors: []color.Color{color.NRGBA{R: 255, A: 255}, color.NRGBA{B: 255, A: 255}}},
+			input: rowImage(white, white, white, white),
+			want:  []color.NRGBA{{R: 223, B: 32, A: 255}, {R: 159, B: 96, A: 255}, {R: 96, B: 159, A: 255}, {R: 32, B: 223, A: 255}},
+		},
+		{
+			name:  "reversed gradient",
+			tint:  Tint{Mode: TintGradient, Angle: 180, Colors: []color.Color{color.NRGBA{R: 255, A: 255}, color.NRGBA{B: 255, A: 255}}},
+			input: rowImage(white, white, white, white),
+			want:  []color.NRGBA{{R: 32, B: 223, A: 255}, {R: 96, B: 159, A: 255}, {R: 159, B: 96, A: 255}, {R: 223, B: 32, A: 255}},
+		},
+		{
+			name:  "three stop gradient",
+			tint:  Tint{Mode: TintGradient, Colors: []color.Color{color.NRGBA{R: 255, A: 255}, color.NRGBA{G: 255, A: 255}, color.NRGBA{B: 255, A: 255}}},
+			input: rowImage(white, white),
+			want:  []color.NRGBA{{R: 128, G: 128, A: 255}, {G: 128, B: 128, A: 255}},
+		},
+		{
+			name:  "luminance as alpha",
+			tint:  Tint{Colors: []color.Color{color.NRGBA{R: 255, A: 255}}, LuminanceAsAlpha: true},
+			input: rowImage(black, white, color.NRGBA{R: 128, G: 128, B: 128, A: 255}, color.NRGBA{A: 128}),
+			want:  []color.NRGBA{{R: 255, A: 255}, {R: 255}, {R: 255, A: 127}, {R: 255, A: 128}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := tt.tint.apply(tt.input)
+			for x, want := range tt.want {
+				if got := result.NRGBAAt(x, 0); got != want {
+					t.Errorf("pixel %d = %v, want %v", x, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestTintVerticalGradient(t *testing.T) {
+	tint := Tint{Mode: TintGradient, Angle: 90, Colors: []color.Color{color.NRGBA{R: 255, A: 255}, color.NRGBA{B: 255, A: 255}}}
+	img := uniformImage(1, 4, color.NRGBA{R: 255, G: 255, B: 255, A: 255})
+
+	result := tint.apply(img)
+	if top, bottom := result.NRGBAAt(0, 0), result.NRGBAAt(0, 3); top.B <= top.R || bottom.R <= bottom.B {
+		t.Errorf("gradient at 90 degrees: top %v, bottom %v, want blue at the top and red at the bottom", top, bottom)
+	}
+}
+
+func TestTintValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		tint    Tint
+		wantErr string
+	}{
+		{"solid", Tint{Colors: []color.Color{color.White}}, ""},
+		{"duotone", Tint{Mode: TintDuotone, Colors: []color.Color{color.Black, color.White}}, ""},
+		{"gradient", Tint{Mode: TintGradient, Colors: []color.Color{color.Black, color.White, color.Black}}, ""},
+		{"solid without color", Tint{}, "solid tint requires exactly one color"},
+		{"duotone with one color", Tint{Mode: TintDuotone, Colors: []color.Color{color.Black}}, "duotone tint requires exactly two colors"},
+		{"gradient with one color", Tint{Mode: TintGradient, Colors: []color.Color{color.Black}}, "gradient tint requires at least two colors"},
+		{"unknown mode", Tint{Mode: TintGradient + 1, Colors: []color.Color{color.Black}}, "unknown tint mode"},
+		{"nil color", Tint{Mode: TintDuotone, Colors: []color.Color{color.Black, nil}}, "tint color 1 is nil"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checkValidateError(t, tt.tint.validate(), tt.wantErr)
+		})
+	}
+}
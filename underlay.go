@@ -0,0 +1,133 @@
+package imagewatermark
+
+import (
+	"fmt"
+	"image"
+
+	"github.com/disintegration/imaging"
+)
+
+// UnderlayMode defines how ink (text) pixels are separated from background (paper) pixels.
+//
+// Supported values:
+//   - UnderlayThreshold: Pixels darker than a global luminance Threshold are ink.
+//   - UnderlayAdaptive: Pixels darker than the mean of their neighborhood by more than Sensitivity are ink
+//     (Bradley adaptive binarization), which copes with uneven lighting and yellowed scans.
+type UnderlayMode int
+
+const (
+	UnderlayThreshold UnderlayMode = iota
+	UnderlayAdaptive
+)
+
+// UnderlayConfig composites the watermark only onto background pixels, so that it sits "under" the ink
+// and the text of scanned documents stays crisp.
+//
+// The ink is detected on the input image, before any watermark is drawn, and works with every placement mode.
+//
+// Fields:
+//   - Mode: How ink pixels are detected (Default is UnderlayThreshold).
+//   - Threshold: Relative luminance below which a pixel is ink, used by UnderlayThreshold (0.0 to 1.0, Default is 0.5).
+//   - WindowSize: Side in pixels of the neighborhood used by UnderlayAdaptive
+//     (Default is 1/16 of the shorter side of the image, at least 15).
+//   - Sensitivity: How much darker than its neighborhood a pixel must be to count as ink, used by UnderlayAdaptive
+//     (0.0 to 1.0, Default is 0.15).
+type UnderlayConfig struct {
+	Mode        UnderlayMode
+	Threshold   float64
+	WindowSize  int
+	Sensitivity float64
+}
+
+// validate checks if the UnderlayConfig has a supported mode and values within range.
+//
+// Returns:
+//   - An error describing the first invalid value found, or nil if the configuration is valid.
+func (c UnderlayConfig) validate() error {
+	if c.Mode < UnderlayThreshold || c.Mode > UnderlayAdaptive {
+		return fmt.Errorf("unknown underlay mode: %d", c.Mode)
+	}
+
+	if c.Threshold < 0 || c.Threshold > 1 {
+		return fmt.Errorf("underlay threshold must be between 0 and 1: %f", c.Threshold)
+	}
+
+	if c.WindowSize < 0 {
+		return fmt.Errorf("underlay window size must be a non-negative integer: %d", c.WindowSize)
+	}
+
+	if c.Sensitivity < 0 || c.Sensitivity > 1 {
+		return fmt.Errorf("underlay sensitivity must be between 0 and 1: %f", c.Sensitivity)
+	}
+
+	return nil
+}
+
+// ink classifies every pixel of the input image as ink or background.
+//
+// Parameters:
+//   - inputImg: The input image.
+//
+// Returns:
+//   - One value per input pixel, row by row, that is true for ink pixels.
+func (c UnderlayConfig) ink(inputImg image.Image) []bool {
+	img := imaging.Clone(inputImg)
+	width, height := img.Bounds().Dx(), img.Bounds().Dy()
+
+	luminances := make([]float64, width*height)
+	for i := range luminances {
+		p := img.Pix[i*4:]
+		// Transparent pixels are treated as paper.
+		luminances[i] = 1 - (1-relativeLuminance(p[0], p[1], p[2]))*float64(p[3])/255
+	}
+
+	ink := make([]bool, width*height)
+
+	if c.Mode == UnderlayThreshold {
+		threshold := c.Threshold
+		if threshold == 0 {
+			threshold = 0.5
+		}
+
+		for i, luminance := range luminances {
+			ink[i] = luminance < threshold
+		}
+
+		return ink
+	}
+
+	windowSize := c.WindowSize
+	if windowSize == 0 {
+		windowSize = max(min(width, height)/16, 15)
+	}
+
+	sensitivity := c.Sensitivity
+	if sensitivity == 0 {
+		sensitivity = 0.15
+	}
+
+	sums := make([]float64, (width+1)*(height+1))
+	for y := 0; y < height; y++ {
+		var rowSum float64
+		for x := 0; x < width; x++ {
+			rowSum += luminances[y*width+x]
+			sums[(y+1)*(width+1)+x+1] = sums[y*(width+1)+x+1] + rowSum
+		}
+	}
+
+	half := windowSize / 2
+	for y := 0; y < height; y++ {
+		y0, y1 := max(y-half, 0), min(y+half+1, height)
+
+		for x := 0; x < width; x++ {
+			x0, x1 := max(x-half, 0), min(x+half+1, width)
+
+			sum := sums[y1*(width+1)+x1] - sums[y0*(width+1)+x1] - sums[y1*(width+1)+x0] + sums[y0*(width+1)+x0]
+			mean := sum / float64((x1-x0)*(y1-y0))
+
+			ink[y*width+x] = luminances[y*width+x] < mean*(1-sensitivity)
+		}
+	}
+
+	return ink
+}
@@ -0,0 +1,141 @@
+package imagewatermark
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestUnderlayInkThreshold(t *testing.T) {
+	input := rowImage(
+		color.NRGBA{R: 255, G: 255, B: 255, A: 255},
+		color.NRGBA{A: 255},
+		color.NRGBA{R: 128, G: 128, B: 128, A: 255},
+		color.NRGBA{R: 200, G: 200, B: 200, A: 255},
+		color.NRGBA{},
+		color.NRGBA{A: 100},
+	)
+
+	tests := []struct {
+		name     string
+		underlay UnderlayConfig
+		want     []bool
+	}{
+		{"default threshold", UnderlayConfig{}, []bool{false, true, true, false, false, false}},
+		{"low threshold", UnderlayConfig{Threshold: 0.1}, []bool{false, true, false, false, false, false}},
+		{"high threshold", UnderlayConfig{Threshold: 0.9}, []bool{false, true, true, true, false, true}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.underlay.ink(input)
+			for i, want := range tt.want {
+				if got[i] != want {
+					t.Errorf("ink at %d = %v, want %v", i, got[i], want)
+				}
+			}
+		})
+	}
+}
+
+func TestUnderlayInkAdaptive(t *testing.T) {
+	// The left half is a dim scan (gray paper), the right half is white paper, and each has a black dot.
+	input := splitImage(60, 20, color.NRGBA{R: 100, G: 100, B: 100, A: 255}, color.NRGBA{R: 255, G: 255, B: 255, A: 255})
+	input.SetNRGBA(10, 10, color.NRGBA{A: 255})
+	input.SetNRGBA(50, 10, color.NRGBA{A: 255})
+
+	tests := []struct {
+		name     string
+		underlay UnderlayConfig
+		want     map[image.Point]bool
+	}{
+		{
+			name:     "threshold marks dim paper as ink",
+			underlay: UnderlayConfig{},
+			want:     map[image.Point]bool{{5, 5}: true, {10, 10}: true, {50, 10}: true, {55, 5}: false},
+		},
+		{
+			name:     "adaptive",
+			underlay: UnderlayConfig{Mode: UnderlayAdaptive},
+			want:     map[image.Point]bool{{5, 5}: false, {10, 10}: true, {50, 10}: true, {55, 5}: false},
+		},
+		{
+			name:     "adaptive with small window",
+			underlay: UnderlayConfig{Mode: UnderlayAdaptive, WindowSize: 3},
+			want:     map[image.Point]bool{{5, 5}: false, {10, 10}: true, {11, 10}: false, {50, 10}: true},
+		},
+		{
+			name:     "adaptive with high sensitivity",
+			underlay: UnderlayConfig{Mode: UnderlayAdaptive, Sensitivity: 1},
+			want:     map[image.Point]bool{{10, 10}: false, {50, 10}: false},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.underlay.ink(input)
+			for p, want := range tt.want {
+				if got[p.Y*60+p.X] != want {
+					t.Errorf("ink at %v = %v, want %v", p, got[p.Y*60+p.X], want)
+				}
+			}
+		})
+	}
+}
+
+func TestApplyUnderlay(t *testing.T) {
+	white := color.NRGBA{R: 255, G: 255, B: 255, A: 255}
+	black := color.NRGBA{A: 255}
+	red := color.NRGBA{R: 255, A: 255}
+
+	document := uniformImage(100, 100, white)
+	for x := 0; x < 100; x++ {
+		document.SetNRGBA(x, 50, black)
+	}
+
+	tests := []struct {
+		name   string
+		config GeneralConfig
+		placer Placer
+	}{
+		{"single", GeneralConfig{OpacityAlpha: 1, WatermarkWidthPercent: 100}, SingleConfig{}},
+		{"grid", GeneralConfig{OpacityAlpha: 1, WatermarkWidthPercent: 50}, GridConfig{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.config.Underlay = &UnderlayConfig{}
+			result, err := ApplyWithPlacer(document, uniformImage(10, 10, red), tt.config, tt.placer)
+			if err != nil {
+				t.Fatalf("ApplyWithPlacer: %v", err)
+			}
+
+			for p, want := range map[image.Point]color.NRGBA{{20, 50}: black, {70, 50}: black, {20, 49}: red, {70, 51}: red} {
+				if got := nrgbaAt(result, p.X, p.Y); got != want {
+					t.Errorf("pixel %v = %v, want %v", p, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestUnderlayConfigValidate(t *testing.T) {
+	tests := []struct {
+		name     string
+		underlay UnderlayConfig
+		wantErr  string
+	}{
+		{"threshold", UnderlayConfig{Threshold: 0.4}, ""},
+		{"adaptive", UnderlayConfig{Mode: UnderlayAdaptive, WindowSize: 31, Sensitivity: 0.2}, ""},
+		{"unknown mode", UnderlayConfig{Mode: UnderlayAdaptive + 1}, "unknown underlay mode"},
+		{"threshold above 1", UnderlayConfig{Threshold: 1.5}, "underlay threshold"},
+		{"negative window size", UnderlayConfig{Mode: UnderlayAdaptive, WindowSize: -1}, "underlay window size"},
+		{"negative sensitivity", UnderlayConfig{Mode: UnderlayAdaptive, Sensitivity: -0.1}, "underlay sensitivity"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checkValidateError(t, tt.underlay.validate(), tt.wantErr)
+		})
+	}
+}
@@ -0,0 +1,142 @@
+package imagewatermark
+
+import (
+	"fmt"
+	"math"
+)
+
+// Unit defines the unit in which a Length is expressed.
+//
+// Supported values:
+//   - UnitPixels: Raw pixels (default).
+//   - UnitPercent: Percentage of the matching input image dimension (width for horizontal values, height for vertical ones).
+//   - UnitMillimeters: Millimeters, converted to pixels through the DPI of the GeneralConfig.
+//   - UnitInches: Inches, converted to pixels through the DPI of the GeneralConfig.
+type Unit int
+
+const (
+	UnitPixels Unit = iota
+	UnitPercent
+	UnitMillimeters
+	UnitInches
+)
+
+// DefaultDPI is the resolution used to convert physical units to pixels when GeneralConfig.DPI is not set.
+const DefaultDPI = 72.0
+
+// Length is a distance expressed in a given Unit, resolved to pixels against the input image when the watermark is placed.
+//
+// Fields:
+//   - Value: The numeric value of the length.
+//   - Unit: The unit in which Value is expressed (Default is UnitPixels).
+type Length struct {
+	Value float64
+	Unit  Unit
+}
+
+// Pixels returns a Length of v pixels.
+func Pixels(v float64) *Length {
+	return &Length{Value: v, Unit: UnitPixels}
+}
+
+// Percent returns a Length of v percent of the matching input image dimension.
+func Percent(v float64) *Length {
+	return &Length{Value: v, Unit: UnitPercent}
+}
+
+// Millimeters returns a Length of v millimeters.
+func Millimeters(v float64) *Length {
+	return &Length{Value: v, Unit: UnitMillimeters}
+}
+
+// Inches returns a Length of v inches.
+func Inches(v float64) *Length {
+	return &Length{Value: v, Unit: UnitInches}
+}
+
+// validate checks if the Length uses a supported unit and, optionally, a non-negative value.
+//
+// Parameters:
+//   - allowNegative: Whether negative values are accepted.
+//
+// Returns:
+//   - An error describing the invalid value, or nil if the length is valid.
+func (l Length) validate(allowNegative bool) error {
+	if l.Unit < UnitPixels || l.Unit > UnitInches {
+		return fmt.Errorf("unknown unit: %d", l.Unit)
+	}
+
+	if !allowNegative && l.Value < 0 {
+		return fmt.Errorf("length must be non-negative: %f", l.Value)
+	}
+
+	return nil
+}
+
+// pixels converts the Length to pixels.
+//
+// Parameters:
+//   - reference: The input image dimension (in pixels) used by UnitPercent.
+//   - dpi: The resolution used by physical units. Zero or negative values default to DefaultDPI.
+//
+// Returns:
+//   - An int representing the length in pixels, rounded to the nearest pixel.
+func (l Length) pixels(reference int, dpi float64) int {
+	if dpi <= 0 {
+		dpi = DefaultDPI
+	}
+
+	var px float64
+
+	switch l.Unit {
+	case UnitPercent:
+		px = float64(reference) * l.Value / 100
+	case UnitMillimeters:
+		px = l.Value / 25.4 * dpi
+	case UnitInches:
+		px = l.Value * dpi
+	default:
+		px = l.Value
+	}
+
+	return int(math.Round(px))
+}
+
+// resolveLength returns the pixel value of an optional Length, falling back to the given pixel value when it is nil.
+//
+// Parameters:
+//   - length: The optional Length to resolve.
+//   - fallback: The pixel value used when length is nil.
+//   - reference: The input image dimension (in pixels) used by UnitPercent.
+//   - dpi: The resolution used by physical units.
+//
+// Returns:
+//   - An int representing the resolved length in pixels.
+func resolveLength(length *Length, fallback, reference int, dpi float64) int {
+	if length == nil {
+		return fallback
+	}
+
+	return length.pixels(reference, dpi)
+}
+
+// validateLength validates an optional Length, ignoring it when nil.
+//
+// Parameters:
+//   - name: The field name used in the error message.
+//   - length: The optional Length to validate.
+//   - allowNegative: Whether negative values are accepted.
+//
+// Returns:
+//   - An error describing the invalid length, or nil if it is nil or valid.
+func validateLength(name string, length *Length, allowNegative bool) error {
+	if length == nil {
+		return nil
+	}
+
+	if err := length.validate(allowNegative); err != nil {
+		return fmt.Errorf("invalid %s: %w", name, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,108 @@
+package imagewatermark
+
+import (
+	"image"
+	"testing"
+)
+
+func TestLengthPixels(t *testing.T) {
+	tests := []struct {
+		name      string
+		length    Length
+		reference int
+		dpi       float64
+		want      int
+	}{
+		{"pixels", Length{Value: 12.4}, 1000, 300, 12},
+		{"negative pixels", Length{Value: -7}, 1000, 300, -7},
+		{"percent of width", Length{Value: 5, Unit: UnitPercent}, 1000, 300, 50},
+		{"percent rounds", Length{Value: 2.5, Unit: UnitPercent}, 333, 300, 8},
+		{"millimeters at 300 dpi", Length{Value: 25.4, Unit: UnitMillimeters}, 1000, 300, 300},
+		{"millimeters at 96 dpi", Length{Value: 10, Unit: UnitMillimeters}, 1000, 96, 38},
+		{"inches at 300 dpi", Length{Value: 0.5, Unit: UnitInches}, 1000, 300, 150},
+		{"inches use default dpi", Length{Value: 1, Unit: UnitInches}, 1000, 0, int(DefaultDPI)},
+		{"millimeters use default dpi", Length{Value: 25.4, Unit: UnitMillimeters}, 1000, -1, int(DefaultDPI)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.length.pixels(tt.reference, tt.dpi); got != tt.want {
+				t.Errorf("pixels(%d, %v) = %d, want %d", tt.reference, tt.dpi, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLengthValidate(t *testing.T) {
+	tests := []struct {
+		name          string
+		length        Length
+		allowNegative bool
+		wantErr       bool
+	}{
+		{"pixels", Length{Value: 10}, false, false},
+		{"negative allowed", Length{Value: -10, Unit: UnitPercent}, true, false},
+		{"negative rejected", Length{Value: -10, Unit: UnitMillimeters}, false, true},
+		{"unknown unit", Length{Value: 10, Unit: UnitInches + 1}, true, true},
+		{"negative unit", Length{Value: 10, Unit: -1}, true, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.length.validate(tt.allowNegative); (err != nil) != tt.wantErr {
+				t.Errorf("validate(%v) = %v, want error %v", tt.allowNegative, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSingleConfigMargins(t *testing.T) {
+	bounds := image.Rect(0, 0, 2000, 1000)
+
+	tests := []struct {
+		name   string
+		config SingleConfig
+		wantX  int
+		wantY  int
+	}{
+		{"spacing", SingleConfig{Spacing: 15}, 15, 15},
+		{"percent per axis", SingleConfig{MarginX: Percent(5), MarginY: Percent(5)}, 100, 50},
+		{"millimeters with dpi", SingleConfig{GeneralConfig: GeneralConfig{DPI: 300}, MarginX: Millimeters(10), MarginY: Millimeters(5)}, 118, 59},
+		{"inches with default dpi", SingleConfig{MarginX: Inches(1)}, 72, 0},
+		{"length overrides spacing on one axis", SingleConfig{Spacing: 15, MarginY: Pixels(40)}, 15, 40},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			x, y := tt.config.margins(bounds)
+			if x != tt.wantX || y != tt.wantY {
+				t.Errorf("margins() = %d, %d, want %d, %d", x, y, tt.wantX, tt.wantY)
+			}
+		})
+	}
+}
+
+func TestGridConfigResolved(t *testing.T) {
+	bounds := image.Rect(0, 0, 1000, 500)
+
+	tests := []struct {
+		name   string
+		config GridConfig
+		want   [4]int
+	}{
+		{"pixels", GridConfig{GridSpacingX: 10, GridSpacingY: 20, OffsetX: 3, OffsetY: 4}, [4]int{10, 20, 3, 4}},
+		{"percent", GridConfig{GridSpacingXLength: Percent(10), GridSpacingYLength: Percent(10), OffsetXLength: Percent(1), OffsetYLength: Percent(1)}, [4]int{100, 50, 10, 5}},
+		{"inches with dpi", GridConfig{GeneralConfig: GeneralConfig{DPI: 200}, GridSpacingXLength: Inches(0.5), OffsetYLength: Inches(0.1)}, [4]int{100, 0, 0, 20}},
+		{"negative spacing", GridConfig{GridSpacingXLength: Percent(-2), GridSpacingY: -5}, [4]int{-20, -5, 0, 0}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := tt.config.resolved(bounds)
+			got := [4]int{c.GridSpacingX, c.GridSpacingY, c.OffsetX, c.OffsetY}
+			if got != tt.want {
+				t.Errorf("resolved() spacing and offsets = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
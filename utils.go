@@ -4,7 +4,10 @@ import (
 	"image"
 	"image/color"
 	"image/draw"
+	"math"
 	"math/rand"
+	"runtime"
+	"sync"
 
 	"github.com/disintegration/imaging"
 	_ "golang.org/x/image/webp"
@@ -14,6 +17,7 @@ import (
 //
 // The function automatically corrects the image orientation based on EXIF metadata,
 // ensuring that images taken with different device orientations display correctly.
+// CMYK and ICC-tagged images are converted to sRGB (see OpenImageWithOptions).
 //
 // Parameters:
 //   - path: The file path to the image to be loaded.
@@ -22,25 +26,72 @@ import (
 //   - An image.Image containing the loaded image data.
 //   - An error if the file cannot be read or the format is not supported.
 func OpenImage(path string) (image.Image, error) {
-	return imaging.Open(path, imaging.AutoOrientation(true))
+	return OpenImageWithOptions(path, OpenOptions{})
 }
 
-// getNewWatermarkWidth calculates the new width of the watermark based on a percentage of the original image width.
+// getWatermarkSize calculates the new width and height of the watermark based on the configured SizeMode.
 //
-// This function is used to scale the watermark proportionally to the input image dimensions.
-// For example, if the input image is 1000px wide and watermarkWidthPercentage is 20,
-// the function will return 200.
+// This function is used to scale the watermark proportionally to the input image dimensions while keeping
+// the watermark aspect ratio. For example, with SizeWidth, if the input image is 1000px wide and
+// WatermarkWidthPercent is 20, the watermark will be 200px wide. With SizeArea and the same percentage,
+// the watermark will cover 20% of the input image area instead.
+//
+// After the size is computed, the longer side of the watermark is clamped to [MinPixels, MaxPixels]
+// (when set) and both dimensions are kept at least 1 pixel.
 //
 // Parameters:
-//   - originalImage: The original input image used as reference for width calculation.
-//   - watermarkWidthPercentage: The desired watermark width as a percentage of the original image width (0-100).
+//   - inputBounds: The bounds of the input image used as reference for the size calculation.
+//   - watermarkBounds: The bounds of the watermark image, used to keep its aspect ratio.
+//   - config: GeneralConfig containing the WatermarkWidthPercent, WatermarkHeightPercent, SizeMode and pixel clamps.
 //
 // Returns:
-//   - An int representing the new watermark width in pixels.
-func getNewWatermarkWidth(originalImage image.Image, watermarkWidthPercentage float64) int {
-	originalImageWidth := float64(originalImage.Bounds().Dx())
+//   - Two ints representing the new watermark width and height in pixels.
+func getWatermarkSize(inputBounds, watermarkBounds image.Rectangle, config GeneralConfig) (int, int) {
+	inW, inH := float64(inputBounds.Dx()), float64(inputBounds.Dy())
+	wmW, wmH := float64(watermarkBounds.Dx()), float64(watermarkBounds.Dy())
+
+	if wmW <= 0 || wmH <= 0 {
+		return 1, 1
+	}
+
+	percent := config.WatermarkWidthPercent / 100
+	aspect := wmH / wmW
 
-	return int(originalImageWidth * (watermarkWidthPercentage / 100))
+	var width float64
+
+	switch config.SizeMode {
+	case SizeHeight:
+		width = inH * percent / aspect
+	case SizeShorterSide:
+		width = math.Min(inW, inH) * percent
+	case SizeLongerSide:
+		width = math.Max(inW, inH) * percent
+	case SizeArea:
+		width = math.Sqrt(inW * inH * percent / aspect)
+	case SizeDiagonal:
+		width = math.Hypot(inW, inH) * percent / math.Hypot(1, aspect)
+	case SizeFit:
+		heightPercent := config.WatermarkHeightPercent
+		if heightPercent <= 0 {
+			heightPercent = config.WatermarkWidthPercent
+		}
+		width = math.Min(inW*percent, inH*(heightPercent/100)/aspect)
+	default:
+		width = inW * percent
+	}
+
+	height := width * aspect
+
+	longer := math.Max(width, height)
+	if config.MaxPixels > 0 && longer > float64(config.MaxPixels) {
+		scale := float64(config.MaxPixels) / longer
+		width, height = width*scale, height*scale
+	} else if config.MinPixels > 0 && longer < float64(config.MinPixels) {
+		scale := float64(config.MinPixels) / longer
+		width, height = width*scale, height*scale
+	}
+
+	return max(int(math.Round(width)), 1), max(int(math.Round(height)), 1)
 }
 
 // getWatermarkPosition calculates the position of the single watermark on the input image based on alignment and spacing settings.
@@ -53,33 +104,31 @@ func getNewWatermarkWidth(originalImage image.Image, watermarkWidthPercentage fl
 // within valid bounds. If there isn't enough space, the function defaults to the edge position.
 //
 // Parameters:
-//   - inputImage: The input image where the watermark will be placed.
-//   - watermarkImage: The watermark image whose dimensions are used for boundary calculations.
+//   - watermarkBounds: The bounds of the watermark image, used for boundary calculations.
+//   - inputBounds: The bounds of the input image where the watermark will be placed.
 //   - verticalAlign: Vertical alignment option (top, middle, bottom, or random).
 //   - horizontalAlign: Horizontal alignment option (left, middle, right, or random).
-//   - spacing: Padding in pixels from the aligned edge.
+//   - spacingX: Padding in pixels from the aligned left or right edge.
+//   - spacingY: Padding in pixels from the aligned top or bottom edge.
 //
 // Returns:
 //   - An image.Point containing the calculated X and Y coordinates for the watermark placement.
-func getWatermarkPosition(watermarkImage, inputImage image.Image, verticalAlign VerticalAlign, horizontalAlign HorizontalAlign, spacing int) image.Point {
-	inBounds := inputImage.Bounds()
-	wmBounds := watermarkImage.Bounds()
-
-	inW, inH := inBounds.Dx(), inBounds.Dy()
-	wmW, wmH := wmBounds.Dx(), wmBounds.Dy()
+func getWatermarkPosition(watermarkBounds, inputBounds image.Rectangle, verticalAlign VerticalAlign, horizontalAlign HorizontalAlign, spacingX, spacingY int) image.Point {
+	inW, inH := inputBounds.Dx(), inputBounds.Dy()
+	wmW, wmH := watermarkBounds.Dx(), watermarkBounds.Dy()
 
 	var position image.Point
 
 	switch horizontalAlign {
 	case HorizontalLeft:
-		position.X = spacing
+		position.X = spacingX
 	case HorizontalMiddle:
 		position.X = (inW - wmW) / 2
 	case HorizontalRight:
-		position.X = inW - wmW - spacing
+		position.X = inW - wmW - spacingX
 	case HorizontalRandom:
-		minX := spacing
-		maxX := inW - wmW - spacing
+		minX := spacingX
+		maxX := inW - wmW - spacingX
 		if maxX > minX {
 			position.X = rand.Intn(maxX-minX+1) + minX
 		} else {
@@ -91,14 +140,14 @@ func getWatermarkPosition(watermarkImage, inputImage image.Image, verticalAlign
 
 	switch verticalAlign {
 	case VerticalTop:
-		position.Y = spacing
+		position.Y = spacingY
 	case VerticalMiddle:
 		position.Y = (inH - wmH) / 2
 	case VerticalBottom:
-		position.Y = inH - wmH - spacing
+		position.Y = inH - wmH - spacingY
 	case VerticalRandom:
-		minY := spacing
-		maxY := inH - wmH - spacing
+		minY := spacingY
+		maxY := inH - wmH - spacingY
 		if maxY > minY {
 			position.Y = rand.Intn(maxY-minY+1) + minY
 		} else {
@@ -145,43 +194,166 @@ func applyOpacity(img image.Image, opacityAlpha float64) *image.NRGBA {
 	return result
 }
 
+// toNRGBA returns the image as an *image.NRGBA whose bounds start at the origin, copying it only when needed.
+func toNRGBA(img image.Image) *image.NRGBA {
+	if nrgba, ok := img.(*image.NRGBA); ok && nrgba.Bounds().Min == (image.Point{}) {
+		return nrgba
+	}
+
+	return imaging.Clone(img)
+}
+
+// preparedWatermark holds the image-independent result of prepareWatermark.
+//
+// Fields:
+//   - candidates: The preprocessed watermark images. The first one defines the placement footprint; the others
+//     are only present when adaptive variant selection is enabled.
+type preparedWatermark struct {
+	candidates []image.Image
+}
+
+// prepareWatermark applies the image-independent preprocessing steps to the watermark.
+//
+// The tint, when set, is applied to the main watermark first. The watermark (and every adaptive variant)
+// is converted once to *image.NRGBA so that the per-image steps work on a known pixel layout. The result can be reused across multiple input images, which is why batch
+// functions call it once before sizing, rotating and decorating the watermark for each image with fitWatermark.
+//
+// Parameters:
+//   - watermarkImg: The original watermark image.
+//   - config: GeneralConfig containing the watermark appearance settings.
+//
+// Returns:
+//   - A preparedWatermark containing the preprocessed watermark candidates.
+func prepareWatermark(watermarkImg image.Image, config GeneralConfig) preparedWatermark {
+	if config.Tint != nil {
+		watermarkImg = config.Tint.apply(watermarkImg)
+	}
+
+	candidates := []image.Image{watermarkImg}
+	if config.Adaptive != nil {
+		candidates = config.Adaptive.candidates(watermarkImg)
+	}
+
+	for i, candidate := range candidates {
+		if _, ok := candidate.(*image.NRGBA); !ok {
+			candidates[i] = imaging.Clone(candidate)
+		}
+	}
+
+	return preparedWatermark{candidates: candidates}
+}
+
+// fitWatermark resizes the preprocessed watermark for the input image, rotates it and renders its effects.
+//
+// Sizing always refers to the unrotated watermark, so a rotated watermark keeps the same scale as an unrotated one,
+// and rotation happens once at the final resolution instead of resampling the rotated bounding box again.
+// The bounds of the returned image are the rotated bounding box, which is what placement and grid stepping use.
+// Opacity is not applied here but by drawLayer, so that effects are generated from the fully opaque shape and fade
+// together with it, and so that automatic opacity can measure the watermark before fading it.
+//
+// Parameters:
+//   - preparedWM: The watermark returned by prepareWatermark.
+//   - inputImg: The input image used as reference for size calculation.
+//   - config: GeneralConfig containing the size, resampling, RotationDegrees and Effects settings.
+//
+// Returns:
+//   - An image.Image containing the final watermark, ready to be placed on the input image.
+func fitWatermark(preparedWM, inputImg image.Image, config GeneralConfig) image.Image {
+	currentWM := resizeWatermark(preparedWM, inputImg, config)
+
+	if config.RotationDegrees != 0 && config.RotationDegrees != 360 {
+		currentWM = rotateImage(currentWM, config.RotationDegrees)
+	}
+
+	return applyEffects(currentWM, config.Effects)
+}
+
 // rotateImage rotates the input image by the specified degrees in the configuration.
 // The function uses the "imaging" library to perform the rotation, which handles the necessary calculations
 // to rotate the image around its center and fills any empty areas with transparency.
 //
+// For angles that are not multiples of 90 degrees, the image is padded with a transparent border before rotating,
+// so the bilinear interpolation fades the edges into transparency (antialiasing) instead of cutting them at
+// the bounding box. Fully transparent rows and columns are trimmed afterwards to keep the bounding box tight.
+//
 // Parameters:
 //   - img: The input image to be rotated.
-//   - rotationDegrees: The angle in degrees to rotate the image. Positive values rotate clockwise.
+//   - rotationDegrees: The angle in degrees to rotate the image. Positive values rotate counter-clockwise.
 //
 // Returns:
 //   - An image.Image containing the rotated image with transparent background for any new areas created by the rotation.
 func rotateImage(img image.Image, rotationDegrees float64) image.Image {
-	return imaging.Rotate(img, rotationDegrees, image.Transparent)
+	if math.Mod(rotationDegrees, 90) == 0 {
+		return imaging.Rotate(img, rotationDegrees, image.Transparent)
+	}
+
+	bounds := img.Bounds()
+	padded := image.NewNRGBA(image.Rect(0, 0, bounds.Dx()+2, bounds.Dy()+2))
+	draw.Draw(padded, bounds.Sub(bounds.Min).Add(image.Pt(1, 1)), img, bounds.Min, draw.Src)
+
+	return trimTransparent(imaging.Rotate(padded, rotationDegrees, image.Transparent))
+}
+
+// trimTransparent crops the fully transparent rows and columns around an image.
+//
+// Parameters:
+//   - img: The image to trim.
+//
+// Returns:
+//   - A pointer to an image.NRGBA with the smallest bounds containing every non-transparent pixel,
+//     or the original image if it has no transparent border (or is fully transparent).
+func trimTransparent(img *image.NRGBA) *image.NRGBA {
+	bounds := img.Bounds()
+	opaque := image.Rectangle{Min: bounds.Max, Max: bounds.Min}
+
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		row := img.Pix[img.PixOffset(bounds.Min.X, y):]
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			if row[(x-bounds.Min.X)*4+3] == 0 {
+				continue
+			}
+			opaque.Min.X = min(opaque.Min.X, x)
+			opaque.Min.Y = min(opaque.Min.Y, y)
+			opaque.Max.X = max(opaque.Max.X, x+1)
+			opaque.Max.Y = max(opaque.Max.Y, y+1)
+		}
+	}
+
+	if opaque.Empty() || opaque == bounds {
+		return img
+	}
+
+	return imaging.Crop(img, opaque)
 }
 
-// resizeWatermark resizes the watermark image based on the specified percentage of the base image width.
+// resizeWatermark resizes the watermark image based on the size settings of the configuration.
 //
-// This function calculates the new width for the watermark using the getNewWatermarkWidth function
-// and then resizes the watermark image using the "imaging" library. The height is automatically
-// adjusted to maintain the aspect ratio. The resampling filter can be specified in the configuration,
+// This function calculates the new size for the watermark using the getWatermarkSize function
+// and then resizes the watermark image using the "imaging" library. The aspect ratio is always
+// maintained. The resampling filter can be specified in the configuration,
 // and if not provided, it defaults to CatmullRom for high-quality resizing.
+// With LinearLight, the watermark is resized in linear light with premultiplied alpha.
 //
 // Parameters:
 //   - watermarkImg: The original watermark image to be resized.
-//   - baseImage: The input image used as reference for width calculation.
-//   - config: GeneralConfig containing the WatermarkWidthPercent and optional ResampleFilter.
+//   - baseImage: The input image used as reference for size calculation.
+//   - config: GeneralConfig containing the size settings and optional ResampleFilter.
 //
 // Returns:
 //   - An image.Image containing the resized watermark, ready to be applied to the input image.
 func resizeWatermark(watermarkImg image.Image, baseImage image.Image, config GeneralConfig) image.Image {
-	watermarkWidth := getNewWatermarkWidth(baseImage, config.WatermarkWidthPercent)
+	watermarkWidth, watermarkHeight := getWatermarkSize(baseImage.Bounds(), watermarkImg.Bounds(), config)
 
 	resampleFilter := config.ResampleFilter
 	if resampleFilter.Support <= 0 {
 		resampleFilter = imaging.CatmullRom
 	}
 
-	return imaging.Resize(watermarkImg, watermarkWidth, 0, resampleFilter)
+	if config.LinearLight {
+		return resizeLinear(watermarkImg, watermarkWidth, watermarkHeight, resampleFilter)
+	}
+
+	return imaging.Resize(watermarkImg, watermarkWidth, watermarkHeight, resampleFilter)
 }
 
 // generateBaseCanvas creates a new RGBA canvas based on the input image dimensions and draws the input image onto it.
@@ -219,3 +391,80 @@ func drawWatermarkAtPosition(canvas *image.RGBA, watermarkImg image.Image, pos i
 	}
 	draw.Draw(canvas, dr, watermarkImg, image.Point{0, 0}, draw.Over)
 }
+
+// canvasAlpha copies the alpha channel of the canvas.
+//
+// Parameters:
+//   - canvas: The RGBA image whose alpha channel is copied.
+//
+// Returns:
+//   - One alpha value per canvas pixel, row by row.
+func canvasAlpha(canvas *image.RGBA) []uint8 {
+	bounds := canvas.Bounds()
+	alpha := make([]uint8, 0, bounds.Dx()*bounds.Dy())
+
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		row := canvas.Pix[canvas.PixOffset(bounds.Min.X, y):]
+		for x := 0; x < bounds.Dx(); x++ {
+			alpha = append(alpha, row[x*4+3])
+		}
+	}
+
+	return alpha
+}
+
+// maskByAlpha returns a copy of the watermark whose alpha is multiplied by the alpha of the pixels under it,
+// so that nothing is painted onto transparent areas of the input image.
+//
+// Parameters:
+//   - wm: The watermark to draw, with bounds starting at the origin.
+//   - pos: The top-left corner where it will be drawn.
+//   - bounds: The bounds of the canvas.
+//   - alpha: One alpha value per canvas pixel, row by row, as returned by canvasAlpha.
+//
+// Returns:
+//   - A pointer to a new image.NRGBA containing the masked watermark.
+func maskByAlpha(wm *image.NRGBA, pos image.Point, bounds image.Rectangle, alpha []uint8) *image.NRGBA {
+	result := imaging.Clone(wm)
+	dr := image.Rectangle{Min: pos, Max: pos.Add(wm.Bounds().Size())}.Intersect(bounds)
+	width := bounds.Dx()
+
+	for y := dr.Min.Y; y < dr.Max.Y; y++ {
+		for x := dr.Min.X; x < dr.Max.X; x++ {
+			i := (y-pos.Y)*result.Stride + (x-pos.X)*4 + 3
+			result.Pix[i] = uint8(uint16(result.Pix[i]) * uint16(alpha[(y-bounds.Min.Y)*width+x-bounds.Min.X]) / 255)
+		}
+	}
+
+	return result
+}
+
+// processBatch runs the given function once for every index in [0, numImages) using a bounded pool of goroutines.
+//
+// Parameters:
+//   - numImages: The number of images to process.
+//   - maxWorkers: Maximum number of concurrent workers. Zero or negative values default to the number of CPU cores.
+//   - process: The function called with the index of each image to process.
+func processBatch(numImages, maxWorkers int, process func(index int)) {
+	if maxWorkers <= 0 {
+		maxWorkers = runtime.NumCPU()
+	}
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, maxWorkers)
+
+	for i := 0; i < numImages; i++ {
+		wg.Add(1)
+
+		go func(index int) {
+			defer wg.Done()
+
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			process(index)
+		}(i)
+	}
+
+	wg.Wait()
+}
@@ -0,0 +1,167 @@
+package imagewatermark
+
+import (
+	"image"
+	"testing"
+)
+
+func TestGetWatermarkSize(t *testing.T) {
+	input := image.Rect(0, 0, 400, 200)
+	watermark := image.Rect(0, 0, 100, 50)
+
+	tests := []struct {
+		name       string
+		watermark  image.Rectangle
+		config     GeneralConfig
+		wantWidth  int
+		wantHeight int
+	}{
+		{"width", watermark, GeneralConfig{WatermarkWidthPercent: 20}, 80, 40},
+		{"height", watermark, GeneralConfig{WatermarkWidthPercent: 20, SizeMode: SizeHeight}, 80, 40},
+		{"shorter side", watermark, GeneralConfig{WatermarkWidthPercent: 20, SizeMode: SizeShorterSide}, 40, 20},
+		{"longer side", watermark, GeneralConfig{WatermarkWidthPercent: 20, SizeMode: SizeLongerSide}, 80, 40},
+		{"area", watermark, GeneralConfig{WatermarkWidthPercent: 8, SizeMode: SizeArea}, 113, 57},
+		{"diagonal", watermark, GeneralConfig{WatermarkWidthPercent: 10, SizeMode: SizeDiagonal}, 40, 20},
+		{"fit limited by height", watermark, GeneralConfig{WatermarkWidthPercent: 30, WatermarkHeightPercent: 10, SizeMode: SizeFit}, 40, 20},
+		{"fit limited by width", watermark, GeneralConfig{WatermarkWidthPercent: 10, WatermarkHeightPercent: 50, SizeMode: SizeFit}, 40, 20},
+		{"fit default height", watermark, GeneralConfig{WatermarkWidthPercent: 25, SizeMode: SizeFit}, 100, 50},
+		{"tall watermark by height", image.Rect(0, 0, 50, 100), GeneralConfig{WatermarkWidthPercent: 50, SizeMode: SizeHeight}, 50, 100},
+		{"max pixels", watermark, GeneralConfig{WatermarkWidthPercent: 20, MaxPixels: 50}, 50, 25},
+		{"min pixels", watermark, GeneralConfig{WatermarkWidthPercent: 20, MinPixels: 100}, 100, 50},
+		{"within clamps", watermark, GeneralConfig{WatermarkWidthPercent: 20, MinPixels: 10, MaxPixels: 200}, 80, 40},
+		{"max pixels on longer side", image.Rect(0, 0, 50, 100), GeneralConfig{WatermarkWidthPercent: 20, MaxPixels: 60}, 30, 60},
+		{"at least one pixel", watermark, GeneralConfig{WatermarkWidthPercent: 0.1}, 1, 1},
+		{"empty watermark", image.Rectangle{}, GeneralConfig{WatermarkWidthPercent: 20}, 1, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			width, height := getWatermarkSize(input, tt.watermark, tt.config)
+			if width != tt.wantWidth || height != tt.wantHeight {
+				t.Errorf("getWatermarkSize() = %dx%d, want %dx%d", width, height, tt.wantWidth, tt.wantHeight)
+			}
+		})
+	}
+}
+
+func TestGeneralConfigValidateSize(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  GeneralConfig
+		wantErr string
+	}{
+		{"valid", GeneralConfig{OpacityAlpha: 1, WatermarkWidthPercent: 20, SizeMode: SizeDiagonal}, ""},
+		{"valid clamps", GeneralConfig{OpacityAlpha: 1, WatermarkWidthPercent: 20, MinPixels: 10, MaxPixels: 10}, ""},
+		{"min pixels without max", GeneralConfig{OpacityAlpha: 1, WatermarkWidthPercent: 20, MinPixels: 500}, ""},
+		{"unknown size mode", GeneralConfig{OpacityAlpha: 1, WatermarkWidthPercent: 20, SizeMode: SizeFit + 1}, "unknown size mode"},
+		{"negative min pixels", GeneralConfig{OpacityAlpha: 1, WatermarkWidthPercent: 20, MinPixels: -1}, "min and max pixels must be non-negative"},
+		{"min above max", GeneralConfig{OpacityAlpha: 1, WatermarkWidthPercent: 20, MinPixels: 20, MaxPixels: 10}, "min pixels must not exceed max pixels"},
+		{"height percent above 100", GeneralConfig{OpacityAlpha: 1, WatermarkWidthPercent: 20, WatermarkHeightPercent: 101}, "watermark height percent"},
+		{"zero width percent", GeneralConfig{OpacityAlpha: 1}, "watermark width percent"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checkValidateError(t, tt.config.validate(), tt.wantErr)
+		})
+	}
+}
+
+func TestFitWatermarkRotation(t *testing.T) {
+	input := image.NewRGBA(image.Rect(0, 0, 400, 200))
+	watermark := image.NewNRGBA(image.Rect(0, 0, 100, 50))
+	for i := range watermark.Pix {
+		watermark.Pix[i] = 255
+	}
+
+	tests := []struct {
+		name      string
+		degrees   float64
+		minWidth  int
+		maxWidth  int
+		minHeight int
+		maxHeight int
+	}{
+		{"no rotation", 0, 80, 80, 40, 40},
+		{"full turn", 360, 80, 80, 40, 40},
+		{"quarter turn", 90, 40, 40, 80, 80},
+		{"half turn", 180, 80, 80, 40, 40},
+		{"diagonal", 45, 84, 87, 84, 87},
+		{"slight", 10, 85, 88, 52, 55},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := GeneralConfig{WatermarkWidthPercent: 20, RotationDegrees: tt.degrees}
+			bounds := fitWatermark(watermark, input, config).Bounds()
+			if bounds.Dx() < tt.minWidth || bounds.Dx() > tt.maxWidth || bounds.Dy() < tt.minHeight || bounds.Dy() > tt.maxHeight {
+				t.Errorf("fitWatermark() bounds = %dx%d, want %d-%dx%d-%d", bounds.Dx(), bounds.Dy(), tt.minWidth, tt.maxWidth, tt.minHeight, tt.maxHeight)
+			}
+		})
+	}
+}
+
+func TestRotateImageAntialiased(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 40, 40))
+	for i := range img.Pix {
+		img.Pix[i] = 255
+	}
+
+	tests := []struct {
+		name        string
+		degrees     float64
+		wantPartial bool
+	}{
+		{"right angle", 90, false},
+		{"diagonal", 45, true},
+		{"slight", 5, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rotated := rotateImage(img, tt.degrees).(*image.NRGBA)
+			partial := false
+			for i := 3; i < len(rotated.Pix); i += 4 {
+				if a := rotated.Pix[i]; a != 0 && a != 255 {
+					partial = true
+					break
+				}
+			}
+			if partial != tt.wantPartial {
+				t.Errorf("rotateImage(%v) has partially transparent edges = %v, want %v", tt.degrees, partial, tt.wantPartial)
+			}
+
+			bounds := rotated.Bounds()
+			if trimmed := trimTransparent(rotated); trimmed.Bounds().Size() != bounds.Size() {
+				t.Errorf("rotateImage(%v) bounds %v have a transparent border, trimmed to %v", tt.degrees, bounds, trimmed.Bounds())
+			}
+		})
+	}
+}
+
+func TestTrimTransparent(t *testing.T) {
+	tests := []struct {
+		name   string
+		opaque image.Rectangle
+		want   image.Rectangle
+	}{
+		{"border", image.Rect(3, 2, 7, 9), image.Rect(0, 0, 4, 7)},
+		{"no border", image.Rect(0, 0, 10, 10), image.Rect(0, 0, 10, 10)},
+		{"single pixel", image.Rect(9, 0, 10, 1), image.Rect(0, 0, 1, 1)},
+		{"fully transparent", image.Rectangle{}, image.Rect(0, 0, 10, 10)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			img := image.NewNRGBA(image.Rect(0, 0, 10, 10))
+			for y := tt.opaque.Min.Y; y < tt.opaque.Max.Y; y++ {
+				for x := tt.opaque.Min.X; x < tt.opaque.Max.X; x++ {
+					img.Pix[img.PixOffset(x, y)+3] = 255
+				}
+			}
+			if got := trimTransparent(img).Bounds(); got != tt.want {
+				t.Errorf("trimTransparent() bounds = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,722 @@
+package imagewatermark
+
+import (
+	"encoding/binary"
+	"fmt"
+	"image"
+	"io"
+	"math/bits"
+	"sort"
+)
+
+// This file implements a lossless WebP (VP8L) encoder. The bitstream is described in
+// https://developers.google.com/speed/webp/docs/webp_lossless_bitstream_specification.
+// The encoder uses the subtract green and predictor transforms, LZ77 backward references
+// and a single group of canonical Huffman codes, which keeps it small while compressing
+// photos and graphics well.
+
+const (
+	// webpMaxDimension is the maximum width and height of a VP8L image.
+	webpMaxDimension = 1 << 14
+	// webpPredictorBits is the log-2 size of the predictor transform tiles.
+	webpPredictorBits = 4
+	// webpLengthCodes is the number of LZ77 length prefix codes in the green alphabet.
+	webpLengthCodes = 24
+	// webpDistanceCodes is the size of the distance alphabet.
+	webpDistanceCodes = 40
+	// webpMaxLength is the longest LZ77 backward reference.
+	webpMaxLength = 4096
+	// webpMaxDistance is the farthest LZ77 backward reference, encoded past the 120 short distance codes.
+	webpMaxDistance = 1<<20 - 120
+	// webpMinMatch is the shortest LZ77 backward reference the encoder emits.
+	webpMinMatch = 3
+	// webpHashBits is the log-2 size of the LZ77 hash table.
+	webpHashBits = 16
+	// webpMaxChain is the number of previous occurrences examined per LZ77 search.
+	webpMaxChain = 32
+)
+
+// webpCodeLengthOrder is the order in which the code length code lengths are written.
+var webpCodeLengthOrder = [19]int{17, 18, 0, 1, 2, 3, 4, 5, 16, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+
+// webpBitWriter writes values least significant bit first, as required by VP8L.
+type webpBitWriter struct {
+	buf   []byte
+	bits  uint64
+	nBits uint
+}
+
+// write appends the n least significant bits of value.
+func (w *webpBitWriter) write(value uint32, n uint) {
+	w.bits |= uint64(value) << w.nBits
+	w.nBits += n
+	for w.nBits >= 8 {
+		w.buf = append(w.buf, byte(w.bits))
+		w.bits >>= 8
+		w.nBits -= 8
+	}
+}
+
+// bytes flushes the pending bits and returns the written data.
+func (w *webpBitWriter) bytes() []byte {
+	if w.nBits > 0 {
+		w.buf = append(w.buf, byte(w.bits))
+		w.bits, w.nBits = 0, 0
+	}
+	return w.buf
+}
+
+// huffmanCode holds the bit-reversed canonical codes of an alphabet, ready to be written LSB first.
+type huffmanCode struct {
+	codes   []uint32
+	lengths []uint8
+}
+
+// writeSymbol writes the code of a symbol.
+func (h huffmanCode) writeSymbol(w *webpBitWriter, symbol int) {
+	w.write(h.codes[symbol], uint(h.lengths[symbol]))
+}
+
+// huffmanLengths computes length-limited Huffman code lengths for the given symbol counts.
+//
+// When the lengths exceed maxLength, small counts are raised and the tree is rebuilt, which flattens it.
+//
+// Parameters:
+//   - counts: The number of occurrences of each symbol.
+//   - maxLength: The maximum code length.
+//
+// Returns:
+//   - The code length of each symbol, zero for unused symbols.
+func huffmanLengths(counts []int, maxLength int) []uint8 {
+	for countMin := 1; ; countMin *= 2 {
+		lengths := buildHuffmanLengths(counts, countMin)
+
+		longest := uint8(0)
+		for _, length := range lengths {
+			longest = max(longest, length)
+		}
+
+		if int(longest) <= maxLength {
+			return lengths
+		}
+	}
+}
+
+// buildHuffmanLengths builds an unrestricted Huffman tree, raising every used count to at least countMin.
+func buildHuffmanLengths(counts []int, countMin int) []uint8 {
+	type node struct {
+		weight int
+		parent int
+	}
+
+	var nodes []node
+	var symbols []int
+	for symbol, count := range counts {
+		if count > 0 {
+			symbols = append(symbols, symbol)
+			nodes = append(nodes, node{weight: max(count, countMin), parent: -1})
+		}
+	}
+
+	lengths := make([]uint8, len(counts))
+	if len(symbols) < 2 {
+		for _, symbol := range symbols {
+			lengths[symbol] = 1
+		}
+		return lengths
+	}
+
+	sort.SliceStable(symbols, func(a, b int) bool { return max(counts[symbols[a]], countMin) < max(counts[symbols[b]], countMin) })
+	sort.SliceStable(nodes, func(a, b int) bool { return nodes[a].weight < nodes[b].weight })
+
+	// Two-queue construction: leaves are sorted, and internal nodes are created in increasing weight order.
+	leaves := len(nodes)
+	nextLeaf, nextInternal := 0, leaves
+	pick := func() int {
+		if nextLeaf < leaves && (nextInternal >= len(nodes) || nodes[nextLeaf].weight <= nodes[nextInternal].weight) {
+			nextLeaf++
+			return nextLeaf - 1
+		}
+		nextInternal++
+		return nextInternal - 1
+	}
+
+	for len(nodes) < 2*leaves-1 {
+		a, b := pick(), pick()
+		nodes = append(nodes, node{weight: nodes[a].weight + nodes[b].weight, parent: -1})
+		nodes[a].parent = len(nodes) - 1
+		nodes[b].parent = len(nodes) - 1
+	}
+
+	depths := make([]int, len(nodes))
+	for i := len(nodes) - 2; i >= 0; i-- {
+		depths[i] = depths[nodes[i].parent] + 1
+	}
+
+	for i, symbol := range symbols {
+		lengths[symbol] = uint8(depths[i])
+	}
+
+	return lengths
+}
+
+// canonicalCodes assigns canonical Huffman codes to the given lengths and reverses them for LSB-first writing.
+func canonicalCodes(lengths []uint8) huffmanCode {
+	var lengthCounts [16]uint32
+	for _, length := range lengths {
+		lengthCounts[length]++
+	}
+	lengthCounts[0] = 0
+
+	var nextCode [16]uint32
+	code := uint32(0)
+	for length := 1; length < 16; length++ {
+		code = (code + lengthCounts[length-1]) << 1
+		nextCode[length] = code
+	}
+
+	codes := make([]uint32, len(lengths))
+	for symbol, length := range lengths {
+		if length > 0 {
+			codes[symbol] = bits.Reverse32(nextCode[length]) >> (32 - uint(length))
+			nextCode[length]++
+		}
+	}
+
+	return huffmanCode{codes: codes, lengths: lengths}
+}
+
+// writeHuffmanCode writes the Huffman code for a histogram and returns the code used to write its symbols.
+//
+// One or two symbols below 256 use the compact "simple" code. Otherwise the code lengths are written with
+// a code length code, using the run-length symbols 16, 17 and 18.
+//
+// Parameters:
+//   - w: The bit writer.
+//   - counts: The number of occurrences of each symbol of the alphabet.
+//
+// Returns:
+//   - The huffmanCode used to write the symbols.
+func writeHuffmanCode(w *webpBitWriter, counts []int) huffmanCode {
+	var used []int
+	for symbol, count := range counts {
+		if count > 0 {
+			used = append(used, symbol)
+		}
+	}
+
+	if len(used) == 0 {
+		used = []int{0}
+	}
+
+	if len(used) <= 2 && used[len(used)-1] < 256 {
+		lengths := make([]uint8, len(counts))
+		codes := make([]uint32, len(counts))
+
+		w.write(1, 1)
+		w.write(uint32(len(used)-1), 1)
+		if used[0] < 2 {
+			w.write(0, 1)
+			w.write(uint32(used[0]), 1)
+		} else {
+			w.write(1, 1)
+			w.write(uint32(used[0]), 8)
+		}
+
+		if len(used) == 2 {
+			w.write(uint32(used[1]), 8)
+			lengths[used[0]], lengths[used[1]] = 1, 1
+			codes[used[1]] = 1
+		}
+
+		return huffmanCode{codes: codes, lengths: lengths}
+	}
+
+	lengths := huffmanLengths(counts, 15)
+	w.write(0, 1)
+	writeCodeLengths(w, lengths)
+
+	if len(used) == 1 {
+		// A code with a single symbol is decoded without reading any bit.
+		return huffmanCode{codes: make([]uint32, len(counts)), lengths: make([]uint8, len(counts))}
+	}
+
+	return canonicalCodes(lengths)
+}
+
+// writeCodeLengths writes the code lengths of a normal Huffman code.
+func writeCodeLengths(w *webpBitWriter, lengths []uint8) {
+	type token struct {
+		symbol, extra int
+		extraBits     uint
+	}
+
+	var tokens []token
+	for i := 0; i < len(lengths); {
+		value := lengths[i]
+		run := 1
+		for i+run < len(lengths) && lengths[i+run] == value {
+			run++
+		}
+		i += run
+
+		if value == 0 {
+			for run >= 11 {
+				r := min(run, 138)
+				tokens = append(tokens, token{18, r - 11, 7})
+				run -= r
+			}
+			if run >= 3 {
+				tokens = append(tokens, token{17, run - 3, 3})
+				run = 0
+			}
+		} else {
+			tokens = append(tokens, token{int(value), 0, 0})
+			run--
+			for run >= 3 {
+				r := min(run, 6)
+				tokens = append(tokens, token{16, r - 3, 2})
+				run -= r
+			}
+		}
+
+		for ; run > 0; run-- {
+			tokens = append(tokens, token{int(value), 0, 0})
+		}
+	}
+
+	counts := make([]int, 19)
+	for _, t := range tokens {
+		counts[t.symbol]++
+	}
+
+	codeLengthLengths := huffmanLengths(counts, 7)
+	single := 0
+	for _, length := range codeLengthLengths {
+		if length > 0 {
+			single++
+		}
+	}
+
+	nCodes := 4
+	for i, symbol := range webpCodeLengthOrder {
+		if codeLengthLengths[symbol] > 0 {
+			nCodes = max(nCodes, i+1)
+		}
+	}
+
+	w.write(uint32(nCodes-4), 4)
+	for _, symbol := range webpCodeLengthOrder[:nCodes] {
+		w.write(uint32(codeLengthLengths[symbol]), 3)
+	}
+
+	// All code lengths are written, so the optional maximum symbol is not used.
+	w.write(0, 1)
+
+	code := canonicalCodes(codeLengthLengths)
+	if single == 1 {
+		code = huffmanCode{codes: make([]uint32, 19), lengths: make([]uint8, 19)}
+	}
+
+	for _, t := range tokens {
+		code.writeSymbol(w, t.symbol)
+		if t.extraBits > 0 {
+			w.write(uint32(t.extra), t.extraBits)
+		}
+	}
+}
+
+// prefixEncode splits an LZ77 length or distance into its prefix symbol and extra bits.
+//
+// Parameters:
+//   - value: The value to encode, at least 1.
+//
+// Returns:
+//   - The prefix symbol.
+//   - The number of extra bits.
+//   - The value of the extra bits.
+func prefixEncode(value int) (int, uint, int) {
+	value--
+	if value < 4 {
+		return value, 0, 0
+	}
+
+	highest := bits.Len(uint(value)) - 1
+	second := (value >> (highest - 1)) & 1
+	extraBits := highest - 1
+
+	return 2*highest + second, uint(extraBits), value & (1<<extraBits - 1)
+}
+
+// webpToken is a literal pixel (length 0) or an LZ77 backward reference.
+type webpToken struct {
+	pixel    uint32
+	length   int
+	distance int
+}
+
+// backwardReferences turns the pixels into literals and LZ77 backward references using a hash chain.
+func backwardReferences(pixels []uint32) []webpToken {
+	head := make([]int32, 1<<webpHashBits)
+	for i := range head {
+		head[i] = -1
+	}
+	chain := make([]int32, len(pixels))
+
+	hash := func(i int) int {
+		h := (pixels[i]*0x1e35a7bd ^ pixels[i+1]*0x9e3779b1 ^ pixels[i+2]) * 0x85ebca6b
+		return int(h >> (32 - webpHashBits))
+	}
+	insert := func(i int) {
+		if i+2 < len(pixels) {
+			h := hash(i)
+			chain[i] = head[h]
+			head[h] = int32(i)
+		}
+	}
+
+	var tokens []webpToken
+
+	for i := 0; i < len(pixels); {
+		bestLength, bestDistance := 0, 0
+
+		if i+webpMinMatch <= len(pixels) {
+			limit := min(len(pixels)-i, webpMaxLength)
+			candidate := int(head[hash(i)])
+
+			for tries := 0; candidate >= 0 && tries < webpMaxChain; tries++ {
+				distance := i - candidate
+				if distance > webpMaxDistance {
+					break
+				}
+
+				length := 0
+				for length < limit && pixels[candidate+length] == pixels[i+length] {
+					length++
+				}
+				if length > bestLength {
+					bestLength, bestDistance = length, distance
+					if length == limit {
+						break
+					}
+				}
+
+				candidate = int(chain[candidate])
+			}
+		}
+
+		if bestLength >= webpMinMatch {
+			tokens = append(tokens, webpToken{length: bestLength, distance: bestDistance})
+			for j := 0; j < bestLength; j++ {
+				insert(i + j)
+			}
+			i += bestLength
+			continue
+		}
+
+		tokens = append(tokens, webpToken{pixel: pixels[i]})
+		insert(i)
+		i++
+	}
+
+	return tokens
+}
+
+// writeEntropyImage writes an image with a single group of Huffman codes and no color cache.
+//
+// Parameters:
+//   - w: The bit writer.
+//   - pixels: The ARGB pixels, row by row.
+//   - topLevel: Whether this is the main image, which has an extra bit for meta Huffman codes.
+func writeEntropyImage(w *webpBitWriter, pixels []uint32, topLevel bool) {
+	tokens := backwardReferences(pixels)
+
+	green := make([]int, 256+webpLengthCodes)
+	red := make([]int, 256)
+	blue := make([]int, 256)
+	alpha := make([]int, 256)
+	distance := make([]int, webpDistanceCodes)
+
+	for _, t := range tokens {
+		if t.length == 0 {
+			green[t.pixel>>8&0xff]++
+			red[t.pixel>>16&0xff]++
+			blue[t.pixel&0xff]++
+			alpha[t.pixel>>24]++
+			continue
+		}
+
+		lengthSymbol, _, _ := prefixEncode(t.length)
+		distanceSymbol, _, _ := prefixEncode(t.distance + 120)
+		green[256+lengthSymbol]++
+		distance[distanceSymbol]++
+	}
+
+	// No color cache.
+	w.write(0, 1)
+	if topLevel {
+		// A single group of Huffman codes for the whole image.
+		w.write(0, 1)
+	}
+
+	greenCode := writeHuffmanCode(w, green)
+	redCode := writeHuffmanCode(w, red)
+	blueCode := writeHuffmanCode(w, blue)
+	alphaCode := writeHuffmanCode(w, alpha)
+	distanceCode := writeHuffmanCode(w, distance)
+
+	for _, t := range tokens {
+		if t.length == 0 {
+			greenCode.writeSymbol(w, int(t.pixel>>8&0xff))
+			redCode.writeSymbol(w, int(t.pixel>>16&0xff))
+			blueCode.writeSymbol(w, int(t.pixel&0xff))
+			alphaCode.writeSymbol(w, int(t.pixel>>24))
+			continue
+		}
+
+		symbol, extraBits, extra := prefixEncode(t.length)
+		greenCode.writeSymbol(w, 256+symbol)
+		w.write(uint32(extra), extraBits)
+
+		symbol, extraBits, extra = prefixEncode(t.distance + 120)
+		distanceCode.writeSymbol(w, symbol)
+		w.write(uint32(extra), extraBits)
+	}
+}
+
+// webpPredict computes the prediction of a VP8L predictor mode from the left, top, top-right and top-left pixels.
+func webpPredict(mode int, left, top, topRight, topLeft uint32) uint32 {
+	switch mode {
+	case 0:
+		return 0xff000000
+	case 1:
+		return left
+	case 2:
+		return top
+	case 3:
+		return topRight
+	case 4:
+		return topLeft
+	case 5:
+		return average2(average2(left, topRight), top)
+	case 6:
+		return average2(left, topLeft)
+	case 7:
+		return average2(left, top)
+	case 8:
+		return average2(topLeft, top)
+	case 9:
+		return average2(top, topRight)
+	case 10:
+		return average2(average2(left, topLeft), average2(top, topRight))
+	case 11:
+		var predictLeft, predictTop int32
+		for shift := 0; shift < 32; shift += 8 {
+			tl, t, l := int32(topLeft>>shift&0xff), int32(top>>shift&0xff), int32(left>>shift&0xff)
+			predictLeft += abs32(tl - t)
+			predictTop += abs32(tl - l)
+		}
+		if predictLeft < predictTop {
+			return left
+		}
+		return top
+	case 12:
+		return mapChannels(func(c int) int32 {
+			return channel(left, c) + channel(top, c) - channel(topLeft, c)
+		})
+	default:
+		average := average2(left, top)
+		return mapChannels(func(c int) int32 {
+			a := channel(average, c)
+			return a + (a-channel(topLeft, c))/2
+		})
+	}
+}
+
+// channel returns one 8-bit channel of an ARGB pixel.
+func channel(pixel uint32, c int) int32 {
+	return int32(pixel >> (8 * c) & 0xff)
+}
+
+// mapChannels builds an ARGB pixel from a per-channel function, clamping each channel to [0, 255].
+func mapChannels(fn func(c int) int32) uint32 {
+	var pixel uint32
+	for c := 0; c < 4; c++ {
+		pixel |= uint32(min(max(fn(c), 0), 255)) << (8 * c)
+	}
+	return pixel
+}
+
+// average2 averages two ARGB pixels channel by channel, rounding down.
+func average2(a, b uint32) uint32 {
+	return (a^b)&0xfefefefe>>1 + a&b
+}
+
+// subtractPixels subtracts two ARGB pixels channel by channel, modulo 256.
+func subtractPixels(a, b uint32) uint32 {
+	alphaGreen := 0x00ff00ff + (a & 0xff00ff00) - (b & 0xff00ff00)
+	redBlue := 0xff00ff00 + (a & 0x00ff00ff) - (b & 0x00ff00ff)
+	return alphaGreen&0xff00ff00 | redBlue&0x00ff00ff
+}
+
+// abs32 returns the absolute value of x.
+func abs32(x int32) int32 {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+// predictorTransform replaces the pixels with their residuals, choosing for every tile the predictor mode
+// with the smallest residuals.
+//
+// Parameters:
+//   - pixels: The ARGB pixels, row by row. They are replaced by the residuals.
+//   - width: The image width.
+//   - height: The image height.
+//
+// Returns:
+//   - The predictor modes image, one pixel per tile, with the mode in the green channel.
+func predictorTransform(pixels []uint32, width, height int) []uint32 {
+	tileSize := 1 << webpPredictorBits
+	tilesX := (width + tileSize - 1) >> webpPredictorBits
+	tilesY := (height + tileSize - 1) >> webpPredictorBits
+	modes := make([]uint32, tilesX*tilesY)
+
+	predict := func(mode, x, y int) uint32 {
+		i := y*width + x
+		switch {
+		case x == 0 && y == 0:
+			return 0xff000000
+		case y == 0:
+			return pixels[i-1]
+		case x == 0:
+			return pixels[i-width]
+		}
+		// For the rightmost column, the top-right pixel is the leftmost pixel of the current row.
+		return webpPredict(mode, pixels[i-1], pixels[i-width], pixels[i-width+1], pixels[i-width-1])
+	}
+
+	for ty := 0; ty < tilesY; ty++ {
+		for tx := 0; tx < tilesX; tx++ {
+			bestMode, bestCost := 0, int64(-1)
+
+			for mode := 0; mode < 14; mode++ {
+				var cost int64
+				for y := ty * tileSize; y < min((ty+1)*tileSize, height); y++ {
+					for x := tx * tileSize; x < min((tx+1)*tileSize, width); x++ {
+						residual := subtractPixels(pixels[y*width+x], predict(mode, x, y))
+						for shift := 0; shift < 32; shift += 8 {
+							cost += int64(abs32(int32(int8(residual >> shift))))
+						}
+					}
+				}
+
+				if bestCost < 0 || cost < bestCost {
+					bestMode, bestCost = mode, cost
+				}
+			}
+
+			modes[ty*tilesX+tx] = 0xff000000 | uint32(bestMode)<<8
+		}
+	}
+
+	// Residuals are computed from the bottom-right corner, so predictions still read the original pixels.
+	for y := height - 1; y >= 0; y-- {
+		for x := width - 1; x >= 0; x-- {
+			mode := int(modes[(y>>webpPredictorBits)*tilesX+x>>webpPredictorBits] >> 8 & 0xff)
+			pixels[y*width+x] = subtractPixels(pixels[y*width+x], predict(mode, x, y))
+		}
+	}
+
+	return modes
+}
+
+// encodeWebP encodes an image as a lossless WebP file.
+//
+// Fully transparent pixels are stored as transparent black, which is invisible but compresses better.
+//
+// Parameters:
+//   - w: The destination writer.
+//   - img: The image to encode.
+//
+// Returns:
+//   - An error if the image is too large or the data cannot be written.
+func encodeWebP(w io.Writer, img image.Image) error {
+	src := toNRGBA(img)
+	width, height := src.Bounds().Dx(), src.Bounds().Dy()
+
+	if width < 1 || height < 1 || width > webpMaxDimension || height > webpMaxDimension {
+		return fmt.Errorf("webp dimensions must be between 1 and %d pixels: %dx%d", webpMaxDimension, width, height)
+	}
+
+	pixels := make([]uint32, width*height)
+	hasAlpha := false
+	for y := 0; y < height; y++ {
+		row := src.Pix[y*src.Stride:]
+		for x := 0; x < width; x++ {
+			p := row[x*4 : x*4+4 : x*4+4]
+			if p[3] == 0 {
+				hasAlpha = true
+				continue
+			}
+			if p[3] != 0xff {
+				hasAlpha = true
+			}
+			pixels[y*width+x] = uint32(p[3])<<24 | uint32(p[0])<<16 | uint32(p[1])<<8 | uint32(p[2])
+		}
+	}
+
+	bw := &webpBitWriter{}
+	bw.write(0x2f, 8)
+	bw.write(uint32(width-1), 14)
+	bw.write(uint32(height-1), 14)
+	if hasAlpha {
+		bw.write(1, 1)
+	} else {
+		bw.write(0, 1)
+	}
+	bw.write(0, 3)
+
+	// Subtract green transform.
+	for i, pixel := range pixels {
+		green := pixel >> 8 & 0xff
+		pixels[i] = pixel&0xff00ff00 | (pixel>>16-green)&0xff<<16 | (pixel-green)&0xff
+	}
+	bw.write(1, 1)
+	bw.write(2, 2)
+
+	// Predictor transform.
+	modes := predictorTransform(pixels, width, height)
+	bw.write(1, 1)
+	bw.write(0, 2)
+	bw.write(webpPredictorBits-2, 3)
+	writeEntropyImage(bw, modes, false)
+
+	// No more transforms.
+	bw.write(0, 1)
+	writeEntropyImage(bw, pixels, true)
+
+	data := bw.bytes()
+	padding := len(data) & 1
+
+	header := make([]byte, 20)
+	copy(header[0:4], "RIFF")
+	binary.LittleEndian.PutUint32(header[4:8], uint32(12+len(data)+padding))
+	copy(header[8:12], "WEBP")
+	copy(header[12:16], "VP8L")
+	binary.LittleEndian.PutUint32(header[16:20], uint32(len(data)))
+
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	if _, err := w.Write(data); err != nil {
+		return err
+	}
+	if padding == 1 {
+		_, err := w.Write([]byte{0})
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,138 @@
+package imagewatermark
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"math/rand"
+	"testing"
+
+	"golang.org/x/image/webp"
+)
+
+func TestEncodeWebPRoundTrip(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+
+	noise := image.NewNRGBA(image.Rect(0, 0, 67, 45))
+	rng.Read(noise.Pix)
+
+	opaqueNoise := image.NewRGBA(image.Rect(0, 0, 64, 64))
+	rng.Read(opaqueNoise.Pix)
+	for i := 3; i < len(opaqueNoise.Pix); i += 4 {
+		opaqueNoise.Pix[i] = 0xff
+	}
+
+	solid := image.NewNRGBA(image.Rect(0, 0, 300, 200))
+	for i := 0; i < len(solid.Pix); i += 4 {
+		copy(solid.Pix[i:], []uint8{12, 200, 99, 0xff})
+	}
+
+	gradient := image.NewNRGBA(image.Rect(0, 0, 256, 16))
+	for y := 0; y < 16; y++ {
+		for x := 0; x < 256; x++ {
+			gradient.SetNRGBA(x, y, color.NRGBA{R: uint8(x), G: uint8(255 - x), B: uint8(y * 16), A: uint8(x)})
+		}
+	}
+
+	// Rows repeating every 7 rows exercise long LZ77 backward references.
+	tiles := image.NewNRGBA(image.Rect(0, 0, 512, 300))
+	for y := 0; y < 300; y++ {
+		copy(tiles.Pix[y*tiles.Stride:(y+1)*tiles.Stride], noise.Pix[(y%7)*noise.Stride:])
+	}
+
+	single := image.NewNRGBA(image.Rect(0, 0, 1, 1))
+	single.SetNRGBA(0, 0, color.NRGBA{R: 1, G: 2, B: 3, A: 4})
+
+	offset := image.NewNRGBA(image.Rect(-13, 7, 40, 31))
+	for y := offset.Rect.Min.Y; y < offset.Rect.Max.Y; y++ {
+		for x := offset.Rect.Min.X; x < offset.Rect.Max.X; x++ {
+			offset.SetNRGBA(x, y, color.NRGBA{R: uint8(x * 5), G: uint8(y * 9), B: uint8(x * y), A: 0xff})
+		}
+	}
+
+	// A sub-image shares the pixels of its parent, with a stride larger than its width.
+	subImage := noise.SubImage(image.Rect(10, 5, 50, 40))
+
+	gray := image.NewGray(image.Rect(0, 0, 33, 17))
+	rng.Read(gray.Pix)
+
+	tests := []struct {
+		name string
+		img  image.Image
+	}{
+		{"noise", noise},
+		{"opaque noise", opaqueNoise},
+		{"solid", solid},
+		{"repeated rows", tiles},
+		{"alpha gradient", gradient},
+		{"1x1", single},
+		{"non-zero origin", offset},
+		{"sub-image", subImage},
+		{"gray", gray},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := EncodeImage(&buf, tt.img, FormatWebP, SaveOptions{}); err != nil {
+				t.Fatalf("EncodeImage: %v", err)
+			}
+
+			decoded, err := webp.Decode(bytes.NewReader(buf.Bytes()))
+			if err != nil {
+				t.Fatalf("webp.Decode: %v", err)
+			}
+
+			bounds := tt.img.Bounds()
+			if decoded.Bounds().Size() != bounds.Size() {
+				t.Fatalf("decoded size = %v, want %v", decoded.Bounds().Size(), bounds.Size())
+			}
+
+			for y := 0; y < bounds.Dy(); y++ {
+				for x := 0; x < bounds.Dx(); x++ {
+					want := color.NRGBAModel.Convert(tt.img.At(bounds.Min.X+x, bounds.Min.Y+y)).(color.NRGBA)
+					got := color.NRGBAModel.Convert(decoded.At(decoded.Bounds().Min.X+x, decoded.Bounds().Min.Y+y)).(color.NRGBA)
+					// Fully transparent pixels are stored as transparent black.
+					if want.A == 0 {
+						want = color.NRGBA{}
+					}
+					if got != want {
+						t.Fatalf("pixel (%d, %d) = %v, want %v", x, y, got, want)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestEncodeWebPDimensions(t *testing.T) {
+	tests := []struct {
+		name    string
+		bounds  image.Rectangle
+		wantErr bool
+	}{
+		{"empty", image.Rect(0, 0, 0, 0), true},
+		{"maximum width", image.Rect(0, 0, webpMaxDimension, 1), false},
+		{"too wide", image.Rect(0, 0, webpMaxDimension+1, 1), true},
+		{"too tall", image.Rect(0, 0, 1, webpMaxDimension+1), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			err := encodeWebP(&buf, image.NewNRGBA(tt.bounds))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("encodeWebP error = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestFormatFromFilenameWebP(t *testing.T) {
+	for _, name := range []string{"out.webp", "OUT.WEBP", "dir/photo.WebP"} {
+		format, err := FormatFromFilename(name)
+		if err != nil || format != FormatWebP {
+			t.Errorf("FormatFromFilename(%q) = %v, %v, want FormatWebP", name, format, err)
+		}
+	}
+}